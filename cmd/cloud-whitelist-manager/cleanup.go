@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runCleanup removes every entry owned by an agent, never hand-added ones
+func runCleanup(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	agent := flags.String("agent", "", "Agent whose entries are removed (default: agent_name of this configuration)")
+	targets := flags.String("targets", "", "Comma-separated target patterns (default: all targets)")
+	dryRun := flags.Bool("dry-run", false, "Only report the entries that would be removed")
+	flags.Parse(args)
+
+	cfg := loadConfig(logger, *configPath)
+	eng := newEngine(logger, cfg)
+
+	if *agent == "" {
+		*agent = cfg.GetAgentName()
+	}
+
+	results, err := eng.Cleanup(*agent, splitList(*targets), *dryRun)
+	for _, result := range results {
+		if *dryRun {
+			logger.Infof("Would remove from %s: %s", result.Key, strings.Join(result.Entries, ", "))
+		} else {
+			logger.Infof("Removed from %s: %s", result.Key, strings.Join(result.Entries, ", "))
+		}
+	}
+	if err != nil {
+		logger.Fatalf("Cleanup failed: %v", err)
+	}
+	if len(results) == 0 {
+		logger.Infof("No entries owned by agent %s found", *agent)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+// runConfig dispatches the config subcommands
+func runConfig(logger *logrus.Logger, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: cloud-whitelist-manager config show|render [--config config.yaml]\n")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "show":
+		runConfigShow(logger, args[1:])
+	case "render":
+		runConfigRender(logger, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown config command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// runConfigShow prints the configuration after interpolation with secret
+// values redacted
+func runConfigShow(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("config show", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	flags.Parse(args)
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		logger.Fatalf("Failed to load configuration: %v", err)
+	}
+	fmt.Print(cfg.String())
+}
+
+// runConfigRender prints the effective configuration: templates expanded,
+// the legacy aliyun block turned into an account and defaults filled in,
+// with secret values redacted
+func runConfigRender(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("config render", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	flags.Parse(args)
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		logger.Fatalf("Failed to load configuration: %v", err)
+	}
+	fmt.Print(cfg.Effective().String())
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runDoctor runs read-only preflight checks against the cloud APIs and
+// prints a pass/fail matrix, exiting with status 1 when any check fails.
+// Unverified checks do not fail.
+func runDoctor(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	flags.Parse(args)
+
+	cfg := loadConfig(logger, *configPath)
+	eng := newEngine(logger, cfg)
+
+	checks := eng.Doctor()
+	failed, unverified := 0, 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBJECT\tCHECK\tRESULT\tDETAIL")
+	for _, check := range checks {
+		result := "PASS"
+		switch {
+		case check.Unverified:
+			result = "UNVERIFIED"
+			unverified++
+		case !check.OK:
+			result = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", check.Subject, check.Name, result, check.Detail)
+	}
+	w.Flush()
+
+	verified := len(checks) - unverified
+	if failed > 0 {
+		fmt.Printf("\n%d of %d checks failed, %d unverified\n", failed, verified, unverified)
+		os.Exit(1)
+	}
+	fmt.Printf("\nAll %d checks passed, %d unverified\n", verified, unverified)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+// runEncryptValue encrypts a value for the configuration file. The value is
+// read from standard input when not given, keeping it out of shell history.
+func runEncryptValue(logger *logrus.Logger, kmsProvider *aliyun.KMSProvider, args []string) {
+	flags := flag.NewFlagSet("encrypt-value", flag.ExitOnError)
+	provider := flags.String("provider", config.LocalKeyProvider, "Key provider: local or kms")
+	kmsKeyID := flags.String("kms-key-id", "", "KMS key used by the kms provider (default: CWM_KMS_KEY_ID)")
+	generateKey := flags.Bool("generate-key", false, "Print a new key for the local provider and exit")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: cloud-whitelist-manager encrypt-value [--provider local|kms] [value]\n")
+		flags.PrintDefaults()
+	}
+
+	positional := parseArgs(flags, args)
+	if *generateKey {
+		key, err := config.GenerateLocalKey()
+		if err != nil {
+			logger.Fatalf("Failed to generate key: %v", err)
+		}
+		fmt.Println(key)
+		return
+	}
+	if len(positional) > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var value string
+	if len(positional) == 1 {
+		value = positional[0]
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			logger.Fatalf("Failed to read value: %v", err)
+		}
+		value = strings.TrimRight(line, "\r\n")
+	}
+
+	if *kmsKeyID != "" {
+		kmsProvider.KeyID = *kmsKeyID
+	}
+	envelope, err := config.EncryptValue(*provider, value)
+	if err != nil {
+		logger.Fatalf("Failed to encrypt value: %v", err)
+	}
+	fmt.Println(envelope)
+}
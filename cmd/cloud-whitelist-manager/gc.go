@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/engine"
+)
+
+// runGC revokes orphaned managed ECS rules once
+func runGC(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	minAge := flags.Duration("min-age", 0, "Minimum rule age, e.g. 24h (default: gc.min_age of the configuration)")
+	dryRun := flags.Bool("dry-run", false, "Only report the rules that would be revoked")
+	flags.Parse(args)
+
+	cfg := loadConfig(logger, *configPath)
+	eng := newEngine(logger, cfg)
+
+	if *minAge <= 0 {
+		*minAge = cfg.GC.GetMinAge()
+	}
+
+	results, err := eng.CollectGarbage(*minAge, *dryRun)
+	logGCResults(logger, results, *dryRun)
+	if err != nil {
+		logger.Fatalf("Garbage collection failed: %v", err)
+	}
+	if len(results) == 0 {
+		logger.Info("No orphaned rules found")
+	}
+}
+
+// collectGarbage runs the garbage collection pass of the daemon if enabled,
+// returning whether it failed
+func collectGarbage(logger *logrus.Logger, cfg *config.Config, eng *engine.Engine) bool {
+	if !cfg.GC.Enabled {
+		return false
+	}
+
+	results, err := eng.CollectGarbage(cfg.GC.GetMinAge(), cfg.GC.DryRun)
+	logGCResults(logger, results, cfg.GC.DryRun)
+	if err != nil {
+		logger.Errorf("Garbage collection failed: %v", err)
+		return true
+	}
+	return false
+}
+
+// logGCResults logs the orphaned rules found by garbage collection
+func logGCResults(logger *logrus.Logger, results []engine.GCResult, dryRun bool) {
+	for _, result := range results {
+		for _, entry := range result.Entries {
+			message := fmt.Sprintf("rule %s for %s (created %s, %q) via %s", entry.RuleID, entry.CIDR,
+				entry.CreatedAt.Format(time.RFC3339), entry.Description, result.Key)
+			if dryRun {
+				logger.Infof("Would revoke orphaned %s", message)
+			} else {
+				logger.Infof("Revoked orphaned %s", message)
+			}
+		}
+	}
+}
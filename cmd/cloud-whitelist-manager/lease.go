@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+// runAdd grants access to an IP or CIDR immediately and records it as a lease
+func runAdd(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	ttl := flags.Duration("ttl", 0, "Time until the entry is revoked, e.g. 4h (default: never)")
+	targets := flags.String("targets", "", "Comma-separated target patterns, e.g. prod/ecs,*/rds (default: all targets)")
+	comment := flags.String("comment", "", "Comment recorded with the lease")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: cloud-whitelist-manager add <ip|cidr> [--ttl 4h] [--targets ...]\n")
+		flags.PrintDefaults()
+	}
+
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *ttl < 0 {
+		logger.Fatalf("TTL must not be negative")
+	}
+
+	cfg := loadConfig(logger, *configPath)
+	eng := newEngine(logger, cfg)
+
+	lease := state.Lease{
+		CIDR:      positional[0],
+		Targets:   splitList(*targets),
+		CreatedAt: time.Now(),
+		Comment:   *comment,
+	}
+	if *ttl > 0 {
+		lease.ExpiresAt = lease.CreatedAt.Add(*ttl)
+	}
+
+	updated, err := eng.Grant(lease)
+	for _, key := range updated {
+		logger.Infof("Added %s to %s", positional[0], key)
+	}
+	if err != nil {
+		logger.Fatalf("Failed to add %s: %v", positional[0], err)
+	}
+	if !lease.ExpiresAt.IsZero() {
+		logger.Infof("Access for %s expires at %s", positional[0], lease.ExpiresAt.Format(time.RFC3339))
+	}
+}
+
+// runRemove revokes access for an IP or CIDR immediately and deletes its leases
+func runRemove(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("remove", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	targets := flags.String("targets", "", "Comma-separated target patterns to revoke from (default: all targets)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: cloud-whitelist-manager remove <ip|cidr> [--targets ...]\n")
+		flags.PrintDefaults()
+	}
+
+	positional := parseArgs(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg := loadConfig(logger, *configPath)
+	eng := newEngine(logger, cfg)
+
+	updated, err := eng.Revoke(positional[0], splitList(*targets))
+	for _, key := range updated {
+		logger.Infof("Removed %s from %s", positional[0], key)
+	}
+	if err != nil {
+		logger.Fatalf("Failed to remove %s: %v", positional[0], err)
+	}
+	if len(updated) == 0 {
+		logger.Infof("%s was not applied to any matching target", positional[0])
+	}
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runList prints the current entries of every whitelist with the agent that
+// added them, exiting with status 1 when a whitelist cannot be listed
+func runList(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	targets := flags.String("targets", "", "Comma-separated target patterns, e.g. prod/ecs,*/rds (default: all targets)")
+	flags.Parse(args)
+
+	cfg := loadConfig(logger, *configPath)
+	eng := newEngine(logger, cfg)
+
+	results, err := eng.List(splitList(*targets))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tENTRY\tOWNER\tDESCRIPTION")
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(w, "%s\t-\t-\tfailed to list entries: %v\n", result.Key, result.Err)
+			continue
+		}
+		if len(result.Entries) == 0 {
+			fmt.Fprintf(w, "%s\t-\t-\t(empty)\n", result.Key)
+			continue
+		}
+		for _, entry := range result.Entries {
+			// Entries added by hand have no owner
+			owner := "-"
+			if entry.Managed {
+				owner = entry.Owner
+				if owner == "" {
+					owner = "managed"
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Key, entry.CIDR, owner, entry.Description)
+		}
+	}
+	w.Flush()
+
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -12,89 +14,169 @@ import (
 
 	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
 	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
-	"github.com/ConanStudio/cloud-whitelist-manager/internal/ip"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/engine"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
 )
 
-var (
-	configPath = flag.String("config", "config.yaml", "Path to configuration file")
-)
+// leaseCheckInterval is how often expired leases are revoked
+const leaseCheckInterval = time.Minute
 
 func main() {
-	flag.Parse()
-
 	// Initialize logger
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 	})
 
-	// Load configuration
-	cfg, err := config.LoadConfig(*configPath)
-	if err != nil {
-		logger.Fatalf("Failed to load configuration: %v", err)
-	}
-
-	// Validate configuration
-	err = cfg.Validate()
-	if err != nil {
-		logger.Fatalf("Invalid configuration: %v", err)
-	}
-
-	logger.Info("Configuration loaded successfully")
+	// Values encrypted with KMS are decrypted when the configuration is loaded
+	kmsProvider := aliyun.NewKMSProvider()
+	config.RegisterKeyProvider(aliyun.KMSKeyProvider, kmsProvider)
 
-	// Create Aliyun clients for all accounts
-	var aliyunClients []*aliyun.Client
-	
-	// If accounts are configured, use them
-	if len(cfg.Accounts) > 0 {
-		for _, account := range cfg.Accounts {
-			client, err := aliyun.NewClient(account.GetAliyun())
-			if err != nil {
-				logger.Fatalf("Failed to create Aliyun client for account %s: %v", account.Name, err)
+	// Dispatch subcommands, running the daemon by default
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "run":
+			runDaemon(logger, os.Args[2:])
+			return
+		case "once":
+			runOnce(logger, os.Args[2:])
+			return
+		case "status":
+			runStatus(logger, os.Args[2:])
+			return
+		case "list":
+			runList(logger, os.Args[2:])
+			return
+		case "add":
+			runAdd(logger, os.Args[2:])
+			return
+		case "remove":
+			runRemove(logger, os.Args[2:])
+			return
+		case "cleanup":
+			runCleanup(logger, os.Args[2:])
+			return
+		case "gc":
+			runGC(logger, os.Args[2:])
+			return
+		case "config":
+			runConfig(logger, os.Args[2:])
+			return
+		case "doctor":
+			runDoctor(logger, os.Args[2:])
+			return
+		case "policy":
+			runPolicy(logger, os.Args[2:])
+			return
+		case "migrate-config":
+			runMigrateConfig(logger, os.Args[2:])
+			return
+		case "validate":
+			runValidate(logger, os.Args[2:])
+			return
+		case "encrypt-value":
+			runEncryptValue(logger, kmsProvider, os.Args[2:])
+			return
+		case "help", "-h", "-help", "--help":
+			usage(os.Stdout)
+			return
+		default:
+			// Flags without a command are flags of the daemon
+			if !strings.HasPrefix(os.Args[1], "-") {
+				fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+				usage(os.Stderr)
+				os.Exit(2)
 			}
-			aliyunClients = append(aliyunClients, client)
-			logger.Infof("Aliyun client created for account %s", account.Name)
 		}
-	} else {
-		// Backward compatibility: use single account
-		aliyunClient, err := aliyun.NewClient(&cfg.Aliyun)
-		if err != nil {
-			logger.Fatalf("Failed to create Aliyun client: %v", err)
-		}
-		aliyunClients = append(aliyunClients, aliyunClient)
-		logger.Info("Aliyun client created successfully")
 	}
 
-	// State to keep track of current IP
-	var currentState struct {
-		LastIP    string
-		CurrentIP string
-	}
+	runDaemon(logger, os.Args[1:])
+}
+
+// usage prints the available commands
+func usage(w io.Writer) {
+	fmt.Fprint(w, `Usage: cloud-whitelist-manager <command> [flags]
+
+Commands:
+  run             Keep the whitelists updated, the default without a command
+  once            Update the whitelists once, exiting with status 1 on failure
+  status          Show the state store and the results of the last update
+  list            Print the current entries of every configured whitelist
+  add             Grant access to an IP or CIDR, optionally for a limited time
+  remove          Revoke access for an IP or CIDR
+  cleanup         Remove every entry owned by an agent
+  gc              Revoke orphaned managed ECS rules
+  doctor          Run read-only preflight checks against the cloud APIs
+  policy          Generate a least-privilege RAM policy
+  config          Show or render the configuration
+  validate        Validate the configuration
+  migrate-config  Move the legacy aliyun block into accounts
+  encrypt-value   Encrypt a configuration value
+
+Run 'cloud-whitelist-manager <command> -h' for the flags of a command.
+`)
+}
+
+// runDaemon checks the public IP periodically and keeps the whitelists updated
+func runDaemon(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	watch := flags.Bool("watch", false, "Reload the configuration when its files change")
+	watchInterval := flags.Duration("watch-interval", 5*time.Second, "How often the configuration files are checked for changes")
+	flags.Parse(args)
+
+	cfg := loadConfig(logger, *configPath)
+	eng := newEngine(logger, cfg)
 
 	// Create a channel to handle OS signals for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// Reload the configuration on SIGHUP and, when watching, on file changes
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	var changes <-chan struct{}
+	if *watch {
+		changes = watchConfig(logger, *configPath, *watchInterval)
+	}
+
 	// Create a channel for the ticker
 	ticker := time.NewTicker(cfg.GetInterval())
 	defer ticker.Stop()
 
+	// Create a channel for the lease expiry scheduler
+	leaseTicker := time.NewTicker(leaseCheckInterval)
+	defer leaseTicker.Stop()
+
 	// Run the IP update immediately on startup
 	logger.Info("Running initial IP update")
-	err = updateIP(logger, cfg, aliyunClients, &currentState)
+	err := eng.Reconcile()
 	if err != nil {
 		logger.Errorf("Initial IP update failed: %v", err)
 	}
+	collectGarbage(logger, cfg, eng)
 
 	// Main loop
 	for {
 		select {
 		case <-ticker.C:
 			logger.Info("Running scheduled IP update")
-			err := updateIP(logger, cfg, aliyunClients, &currentState)
+			err := eng.Reconcile()
 			if err != nil {
 				logger.Errorf("Scheduled IP update failed: %v", err)
 			}
+			collectGarbage(logger, cfg, eng)
+		case <-reloadChan:
+			logger.Info("Received SIGHUP, reloading configuration")
+			cfg = reloadConfig(logger, *configPath, cfg, eng, ticker)
+		case <-changes:
+			logger.Info("Configuration file changed, reloading")
+			cfg = reloadConfig(logger, *configPath, cfg, eng, ticker)
+		case <-leaseTicker.C:
+			err := eng.ExpireLeases()
+			if err != nil {
+				logger.Errorf("Lease expiry failed: %v", err)
+			}
 		case <-sigChan:
 			logger.Info("Received shutdown signal, exiting...")
 			return
@@ -102,80 +184,80 @@ func main() {
 	}
 }
 
-// updateIP performs the IP update process
-func updateIP(logger *logrus.Logger, cfg *config.Config, aliyunClients []*aliyun.Client, state *struct{ LastIP, CurrentIP string }) error {
-	// Get current public IP
-	currentIP, err := ip.GetPublicIP([]config.IPSource{cfg.IPSource})
+// loadConfig loads and validates the configuration, exiting on failure
+func loadConfig(logger *logrus.Logger, path string) *config.Config {
+	// Load configuration
+	cfg, err := config.LoadConfig(path)
 	if err != nil {
-		return fmt.Errorf("failed to get public IP: %v", err)
+		logger.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	logger.Infof("Current public IP: %s", currentIP)
-
-	// Check if IP has changed
-	if currentIP == state.CurrentIP {
-		logger.Info("IP has not changed, nothing to update")
-		return nil
+	// Validate configuration
+	err = cfg.Validate()
+	if err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
 	}
 
-	// Update state
-	state.LastIP = state.CurrentIP
-	state.CurrentIP = currentIP
+	logger.Info("Configuration loaded successfully")
+	return cfg
+}
 
-	// Update all accounts
-	for i, client := range aliyunClients {
-		accountName := "account"
-		if len(cfg.Accounts) > 0 && i < len(cfg.Accounts) {
-			accountName = cfg.Accounts[i].Name
-		}
+// newEngine creates the Aliyun clients and the state store and wires them
+// into an engine, exiting on failure
+func newEngine(logger *logrus.Logger, cfg *config.Config) *engine.Engine {
+	accounts, err := newAccounts(logger, cfg, nil)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
 
-		logger.Infof("Updating resources for account: %s", accountName)
+	// Open the state store shared by the daemon and the commands
+	store, err := state.Open(cfg.GetStateFile())
+	if err != nil {
+		logger.Fatalf("Failed to open state file: %v", err)
+	}
 
-		// Update ECS security group
-		if client.GetConfig().ECS.Enabled {
-			logger.Infof("Updating ECS security group for account: %s", accountName)
-			err := client.UpdateECSWhitelist(state.LastIP, state.CurrentIP)
-			if err != nil {
-				logger.Errorf("Failed to update ECS whitelist for account %s: %v. Please check if the security group ID is correct and the AccessKey has proper permissions.", accountName, err)
-			} else {
-				logger.Infof("ECS security group updated successfully for account: %s", accountName)
-			}
-		}
+	return engine.New(logger, cfg, accounts, store)
+}
 
-		// Update RDS whitelist
-		if client.GetConfig().RDS.Enabled {
-			logger.Infof("Updating RDS whitelist for account: %s", accountName)
-			err := client.UpdateRDSWhitelist(state.LastIP, state.CurrentIP)
-			if err != nil {
-				logger.Errorf("Failed to update RDS whitelist for account %s: %v. Please check if the RDS instance ID is correct and the AccessKey has proper permissions.", accountName, err)
-			} else {
-				logger.Infof("RDS whitelist updated successfully for account: %s", accountName)
-			}
+// newAccounts creates the Aliyun clients of all accounts. Clients of
+// previous accounts with the same name are reconfigured instead, reusing
+// their connections when the credentials and region are unchanged.
+func newAccounts(logger *logrus.Logger, cfg *config.Config, previous []engine.Account) ([]engine.Account, error) {
+	clients := make(map[string]*aliyun.Client)
+	for _, account := range previous {
+		clients[account.Name] = account.Client
+	}
+	newClient := func(name string, aliyunConfig *config.Aliyun) (*aliyun.Client, error) {
+		if client, ok := clients[name]; ok {
+			return client.Reconfigure(aliyunConfig)
 		}
+		return aliyun.NewClient(aliyunConfig)
+	}
 
-		// Update Redis whitelist
-		if client.GetConfig().Redis.Enabled {
-			logger.Infof("Updating Redis whitelist for account: %s", accountName)
-			err := client.UpdateRedisWhitelist(state.LastIP, state.CurrentIP)
-			if err != nil {
-				logger.Errorf("Failed to update Redis whitelist for account %s: %v. Please check if the Redis instance ID is correct and the AccessKey has proper permissions.", accountName, err)
-			} else {
-				logger.Infof("Redis whitelist updated successfully for account: %s", accountName)
-			}
+	// Create Aliyun clients for all accounts, the legacy aliyun block being
+	// normalized into an account when loaded
+	var accounts []engine.Account
+	for _, account := range cfg.Accounts {
+		client, err := newClient(account.Name, account.GetAliyun())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Aliyun client for account %s: %v", account.Name, err)
 		}
+		accounts = append(accounts, engine.Account{Name: account.Name, Client: client})
+		logger.Infof("Aliyun client created for account %s", account.Name)
+	}
+	return accounts, nil
+}
 
-		// Update CLB whitelist
-		if client.GetConfig().CLB.Enabled {
-			logger.Infof("Updating CLB whitelist for account: %s", accountName)
-			err := client.UpdateCLBWhitelist(state.LastIP, state.CurrentIP)
-			if err != nil {
-				logger.Errorf("Failed to update CLB whitelist for account %s: %v. Please check if the CLB instance ID is correct and the AccessKey has proper permissions.", accountName, err)
-			} else {
-				logger.Infof("CLB whitelist updated successfully for account: %s", accountName)
-			}
+// parseArgs parses flags that may appear before or after positional
+// arguments and returns the positional arguments
+func parseArgs(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
 		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
 	}
-
-	logger.Infof("IP updated from %s to %s", state.LastIP, state.CurrentIP)
-	return nil
-}
\ No newline at end of file
+}
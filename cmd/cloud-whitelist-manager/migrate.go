@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+// runMigrateConfig rewrites a configuration file using the legacy aliyun
+// block into the accounts format, printing it or replacing the file
+func runMigrateConfig(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("migrate-config", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file")
+	name := flags.String("name", config.LegacyAccountName, "Name of the account, changing it makes the entries added so far orphans")
+	write := flags.Bool("write", false, "Replace the configuration file instead of printing the result")
+	flags.Parse(args)
+
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		logger.Fatalf("Failed to read config file: %v", err)
+	}
+	migrated, err := config.Migrate(data, *name)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+
+	if !*write {
+		os.Stdout.Write(migrated)
+		return
+	}
+	info, err := os.Stat(*configPath)
+	if err != nil {
+		logger.Fatalf("Failed to read config file: %v", err)
+	}
+	if err := os.WriteFile(*configPath, migrated, info.Mode().Perm()); err != nil {
+		logger.Fatalf("Failed to write config file: %v", err)
+	}
+	logger.Infof("Migrated %s to the accounts format", *configPath)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runOnce revokes expired leases and updates every whitelist once, as the
+// daemon does on each tick, exiting with status 1 when anything fails. It
+// suits cron jobs and systemd timers.
+func runOnce(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("once", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	flags.Parse(args)
+
+	cfg := loadConfig(logger, *configPath)
+	eng := newEngine(logger, cfg)
+
+	failed := false
+	if err := eng.ExpireLeases(); err != nil {
+		logger.Errorf("Lease expiry failed: %v", err)
+		failed = true
+	}
+	if err := eng.Reconcile(); err != nil {
+		logger.Errorf("IP update failed: %v", err)
+		failed = true
+	}
+	if collectGarbage(logger, cfg, eng) {
+		failed = true
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+	logger.Info("IP update completed")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+// runPolicy prints the least-privilege RAM policy of an account, granting
+// only the actions used on the configured resources
+func runPolicy(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("policy", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	accountName := flags.String("account", "", "Account to generate the policy for, required with several accounts")
+	accountID := flags.String("account-id", "*", "Aliyun account ID used in the resource ARNs")
+	flags.Parse(args)
+
+	cfg := loadConfig(logger, *configPath)
+
+	var aliyunConfig *config.Aliyun
+	var names []string
+	for _, account := range cfg.Accounts {
+		names = append(names, account.Name)
+		if account.Name == *accountName || (*accountName == "" && len(cfg.Accounts) == 1) {
+			aliyunConfig = account.GetAliyun()
+		}
+	}
+	if aliyunConfig == nil {
+		logger.Fatalf("--account must be one of: %s", strings.Join(names, ", "))
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(aliyun.NewPolicy(aliyunConfig, *accountID))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/engine"
+)
+
+// reloadConfig loads and validates the configuration again and switches the
+// engine to it, then runs an update. An invalid configuration is logged and
+// the current one kept. It returns the configuration in use.
+func reloadConfig(logger *logrus.Logger, path string, current *config.Config, eng *engine.Engine, ticker *time.Ticker) *config.Config {
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		logger.Errorf("Failed to reload configuration, keeping the current one: %v", err)
+		return current
+	}
+	err = cfg.Validate()
+	if err != nil {
+		logger.Errorf("Invalid configuration, keeping the current one: %v", err)
+		return current
+	}
+	if cfg.GetStateFile() != current.GetStateFile() {
+		logger.Warnf("state_file changes take effect after a restart, still using %s", current.GetStateFile())
+	}
+
+	accounts, err := newAccounts(logger, cfg, eng.Accounts())
+	if err != nil {
+		logger.Errorf("Failed to reload configuration, keeping the current one: %v", err)
+		return current
+	}
+	err = eng.Reload(cfg, accounts)
+	if err != nil {
+		logger.Errorf("Failed to revoke entries of removed targets: %v", err)
+	}
+	ticker.Reset(cfg.GetInterval())
+	logger.Info("Configuration reloaded successfully")
+
+	err = eng.Reconcile()
+	if err != nil {
+		logger.Errorf("IP update after reload failed: %v", err)
+	}
+	collectGarbage(logger, cfg, eng)
+	return cfg
+}
+
+// watchConfig polls the configuration files and signals when their content
+// changes, or files are added to or removed from a configuration directory
+// or its includes. Polling the content also catches Kubernetes ConfigMap
+// updates, which swap symlinks.
+func watchConfig(logger *logrus.Logger, path string, interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	last, err := configHash(path)
+	if err != nil {
+		logger.Errorf("Failed to read %s for watching: %v", path, err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			hash, err := configHash(path)
+			if err != nil {
+				// Possibly being replaced, checked again on the next tick
+				continue
+			}
+			if hash == last {
+				continue
+			}
+			last = hash
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes
+}
+
+// configHash returns the SHA-256 of the names and content of the
+// configuration files
+func configHash(path string) ([sha256.Size]byte, error) {
+	files, err := config.Files(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	hash := sha256.New()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		hash.Write([]byte(file))
+		hash.Write(data)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+	return sum, nil
+}
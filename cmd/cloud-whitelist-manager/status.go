@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/engine"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+// runStatus prints the state store: the last update, the result and applied
+// entries of every target and the active leases. It never calls the cloud
+// APIs and exits with status 1 when the last update failed.
+func runStatus(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	flags.Parse(args)
+
+	// Only the configuration and the state are read, no clients are created
+	cfg := loadConfig(logger, *configPath)
+	store, err := state.Open(cfg.GetStateFile())
+	if err != nil {
+		logger.Fatalf("Failed to open state file: %v", err)
+	}
+
+	status, err := engine.ReadStatus(cfg, store)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+
+	fmt.Printf("State file: %s\n", cfg.GetStateFile())
+	if run := status.LastRun; run != nil {
+		detected := run.DetectedIP
+		if detected == "" {
+			detected = "detection failed"
+		}
+		fmt.Printf("Last update: %s (%s ago), public IP %s, %d of %d targets failed\n",
+			run.Time.Format(time.RFC3339), time.Since(run.Time).Round(time.Second), detected, run.Failed, run.Targets)
+	} else {
+		fmt.Println("Last update: never")
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tSTATUS\tUPDATED\tAPPLIED\tERROR")
+	for _, target := range status.Targets {
+		result, updated := "pending", "-"
+		if target.Result != nil {
+			result = "ok"
+			if target.Result.Error != "" {
+				result = "failed"
+			}
+			updated = target.Result.Time.Format(time.RFC3339)
+		} else if !target.Configured {
+			// Applied by an earlier configuration or discovered by tags
+			result = "unknown"
+		}
+		applied := strings.Join(target.Applied, ", ")
+		if applied == "" {
+			applied = "-"
+		}
+		var errText string
+		if target.Result != nil {
+			errText = target.Result.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", target.Key, result, updated, applied, errText)
+	}
+	w.Flush()
+
+	if len(status.Leases) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "LEASE\tTARGETS\tEXPIRES\tCOMMENT")
+		for _, lease := range status.Leases {
+			targets, expires := "all", "never"
+			if len(lease.Targets) > 0 {
+				targets = strings.Join(lease.Targets, ", ")
+			}
+			if !lease.ExpiresAt.IsZero() {
+				expires = lease.ExpiresAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", lease.CIDR, targets, expires, lease.Comment)
+		}
+		w.Flush()
+	}
+
+	if status.LastRun != nil && status.LastRun.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+// validateResult is the JSON output of the validate command
+type validateResult struct {
+	Valid  bool           `json:"valid"`
+	Error  string         `json:"error,omitempty"`
+	Issues []config.Issue `json:"issues"`
+}
+
+// runValidate checks a configuration file and reports every issue, exiting
+// with status 1 when any is found
+func runValidate(logger *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file or directory")
+	format := flags.String("format", "text", "Output format: text or json")
+	flags.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		logger.Fatalf("Unknown output format %q", *format)
+	}
+
+	issues, err := config.ValidateFile(*configPath)
+	result := validateResult{Valid: err == nil && len(issues) == 0, Issues: issues}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if result.Issues == nil {
+		result.Issues = []config.Issue{}
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+	} else {
+		if err != nil {
+			fmt.Printf("%s: %v\n", *configPath, err)
+		}
+		for _, issue := range issues {
+			file := issue.File
+			if file == "" {
+				file = *configPath
+			}
+			fmt.Printf("%s:%v\n", file, formatIssue(issue))
+		}
+		if result.Valid {
+			fmt.Printf("%s: configuration is valid\n", *configPath)
+		} else if err == nil {
+			fmt.Printf("%s: %d issues found\n", *configPath, len(issues))
+		}
+	}
+
+	if !result.Valid {
+		os.Exit(1)
+	}
+}
+
+// formatIssue formats an issue after the file name, like compilers do
+func formatIssue(issue config.Issue) string {
+	if issue.Line > 0 {
+		return fmt.Sprintf("%d: %s: %s", issue.Line, issue.Path, issue.Message)
+	}
+	return fmt.Sprintf(" %s: %s", issue.Path, issue.Message)
+}
@@ -3,15 +3,18 @@ package aliyun
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
-	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/r-kvstore"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/rds"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/slb"
 	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/ipset"
 )
 
 // Client represents the Aliyun client wrapper
@@ -21,36 +24,52 @@ type Client struct {
 	redisClient *r_kvstore.Client
 	clbClient  *slb.Client
 	config     *config.Aliyun
+	credential *credential // shared with the clients of other regions
+
+	root    *Client            // client of the account region, nil for the root itself
+	mu      sync.Mutex         // guards regions
+	regions map[string]*Client // clients of other regions, created on first use
 }
 
 // NewClient creates a new Aliyun client wrapper
 func NewClient(aliyunConfig *config.Aliyun) (*Client, error) {
 	// Create credentials
-	credential := credentials.NewAccessKeyCredential(aliyunConfig.AccessKeyID, aliyunConfig.AccessKeySecret)
+	credential, err := newCredential(aliyunConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create credentials: %v", err)
+	}
+	return newClient(aliyunConfig, credential)
+}
 
+// newClient creates a client wrapper with the given credentials
+func newClient(aliyunConfig *config.Aliyun, credential *credential) (*Client, error) {
 	// Create ECS client
-	ecsClient, err := ecs.NewClientWithOptions(aliyunConfig.RegionID, sdk.NewConfig(), credential)
+	ecsClient, err := ecs.NewClientWithOptions(aliyunConfig.RegionID, sdk.NewConfig(), credential.sdkCredential())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create ECS client: %v", err)
 	}
+	credential.apply(&ecsClient.Client)
 
 	// Create RDS client
-	rdsClient, err := rds.NewClientWithOptions(aliyunConfig.RegionID, sdk.NewConfig(), credential)
+	rdsClient, err := rds.NewClientWithOptions(aliyunConfig.RegionID, sdk.NewConfig(), credential.sdkCredential())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create RDS client: %v", err)
 	}
+	credential.apply(&rdsClient.Client)
 
 	// Create Redis client
-	redisClient, err := r_kvstore.NewClientWithOptions(aliyunConfig.RegionID, sdk.NewConfig(), credential)
+	redisClient, err := r_kvstore.NewClientWithOptions(aliyunConfig.RegionID, sdk.NewConfig(), credential.sdkCredential())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Redis client: %v", err)
 	}
+	credential.apply(&redisClient.Client)
 
 	// Create CLB client
-	clbClient, err := slb.NewClientWithOptions(aliyunConfig.RegionID, sdk.NewConfig(), credential)
+	clbClient, err := slb.NewClientWithOptions(aliyunConfig.RegionID, sdk.NewConfig(), credential.sdkCredential())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create CLB client: %v", err)
 	}
+	credential.apply(&clbClient.Client)
 
 	return &Client{
 		ecsClient:   ecsClient,
@@ -58,131 +77,336 @@ func NewClient(aliyunConfig *config.Aliyun) (*Client, error) {
 		redisClient: redisClient,
 		clbClient:   clbClient,
 		config:      aliyunConfig,
+		credential:  credential,
 	}, nil
 }
 
-// UpdateECSWhitelist updates ECS security group whitelist for multiple security groups
-func (c *Client) UpdateECSWhitelist(oldIP, newIP string) error {
-	if !c.config.ECS.Enabled {
-		return nil
+// Reconfigure returns a client for a new configuration of the same account.
+// The SDK clients and credentials, including those of other regions already
+// in use, are reused when the region and credentials are unchanged,
+// otherwise a new client is created.
+func (c *Client) Reconfigure(aliyunConfig *config.Aliyun) (*Client, error) {
+	if c.root != nil {
+		return c.root.Reconfigure(aliyunConfig)
+	}
+	if aliyunConfig.RegionID != c.config.RegionID ||
+		aliyunConfig.AccessKeyID != c.config.AccessKeyID ||
+		aliyunConfig.AccessKeySecret != c.config.AccessKeySecret ||
+		!reflect.DeepEqual(aliyunConfig.Credentials, c.config.Credentials) {
+		return NewClient(aliyunConfig)
 	}
 
-	// Remove old IP from all security groups if it exists
-	if oldIP != "" {
-		for _, sg := range c.config.ECS.SecurityGroupIDs {
-			err := c.removeIPFromECSSecurityGroup(oldIP, sg)
-			if err != nil {
-				return fmt.Errorf("failed to remove old IP from ECS security group %s: %v", sg.SecurityGroupID, err)
-			}
+	root := c.withConfig(aliyunConfig)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for regionID, regional := range c.regions {
+		regionConfig := *aliyunConfig
+		regionConfig.RegionID = regionID
+		client := regional.withConfig(&regionConfig)
+		client.root = root
+		if root.regions == nil {
+			root.regions = make(map[string]*Client)
 		}
+		root.regions[regionID] = client
 	}
+	return root, nil
+}
 
-	// Add new IP to all security groups
-	if newIP != "" {
-		for _, sg := range c.config.ECS.SecurityGroupIDs {
-			err := c.addIPToECSSecurityGroup(newIP, sg)
-			if err != nil {
-				return fmt.Errorf("failed to add new IP to ECS security group %s: %v", sg.SecurityGroupID, err)
-			}
+// withConfig returns a client sharing the SDK clients and credentials with a
+// new configuration
+func (c *Client) withConfig(aliyunConfig *config.Aliyun) *Client {
+	return &Client{
+		ecsClient:   c.ecsClient,
+		rdsClient:   c.rdsClient,
+		redisClient: c.redisClient,
+		clbClient:   c.clbClient,
+		config:      aliyunConfig,
+		credential:  c.credential,
+	}
+}
+
+// Region returns the client of the given region of the same account, creating
+// it on first use. An empty region means the region of the account.
+func (c *Client) Region(regionID string) (*Client, error) {
+	if regionID == "" || regionID == c.config.RegionID {
+		return c, nil
+	}
+	root := c
+	if c.root != nil {
+		root = c.root
+	}
+	if regionID == root.config.RegionID {
+		return root, nil
+	}
+
+	root.mu.Lock()
+	defer root.mu.Unlock()
+
+	if client, ok := root.regions[regionID]; ok {
+		return client, nil
+	}
+
+	regionConfig := *root.config
+	regionConfig.RegionID = regionID
+	client, err := newClient(&regionConfig, root.credential)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for region %s: %v", regionID, err)
+	}
+	client.root = root
+
+	if root.regions == nil {
+		root.regions = make(map[string]*Client)
+	}
+	root.regions[regionID] = client
+	return client, nil
+}
+
+// maxECSPermissions is the maximum number of rules in a single authorize or
+// revoke request
+const maxECSPermissions = 100
+
+// SyncECSSecurityGroup removes and adds the given CIDR entries in an ECS
+// security group for every configured port, tagging added rules with the
+// owner ID. Rules are authorized and revoked in batches.
+func (c *Client) SyncECSSecurityGroup(sg config.SecurityGroup, owner string, add, remove []string) error {
+	regional, err := c.Region(sg.RegionID)
+	if err != nil {
+		return err
+	}
+	if regional != c {
+		return regional.SyncECSSecurityGroup(sg, owner, add, remove)
+	}
+
+	// Remove stale entries first
+	for batch := range slices.Chunk(ecsPermissions(sg, remove), maxECSPermissions) {
+		err := c.revokeECSPermissions(sg, batch)
+		if err != nil {
+			return fmt.Errorf("failed to remove entries from ECS security group %s: %v", sg.SecurityGroupID, err)
+		}
+	}
+
+	// Add new entries
+	for batch := range slices.Chunk(ecsPermissions(sg, add), maxECSPermissions) {
+		err := c.authorizeECSPermissions(sg, owner, batch)
+		if err != nil {
+			return fmt.Errorf("failed to add entries to ECS security group %s: %v", sg.SecurityGroupID, err)
 		}
 	}
 
 	return nil
 }
 
-// removeIPFromECSSecurityGroup removes an IP from ECS security group
-func (c *Client) removeIPFromECSSecurityGroup(ip string, sg config.SecurityGroup) error {
-	request := ecs.CreateRevokeSecurityGroupRequest()
-	request.Scheme = "https"
-	request.SecurityGroupId = sg.SecurityGroupID
-	
-	// Parse port configuration
-	portRange := sg.Port
-	ipProtocol := "tcp"
-	
-	// Handle special port ranges
-	if portRange == "-1/-1" {
-		ipProtocol = "all"
-		portRange = "-1/-1"
-	} else if strings.Contains(portRange, "/") {
+// ecsPermission is a single security group rule for one port and CIDR
+type ecsPermission struct {
+	protocol  string
+	portRange string
+	cidr      string
+}
+
+// ecsPermissions returns the rules of every configured port for the CIDRs
+func ecsPermissions(sg config.SecurityGroup, cidrs []string) []ecsPermission {
+	var permissions []ecsPermission
+	for _, port := range sg.GetPorts() {
+		protocol, portRange := ecsPortRange(sg, port)
+		for _, cidr := range cidrs {
+			permissions = append(permissions, ecsPermission{protocol: protocol, portRange: portRange, cidr: cidr})
+		}
+	}
+	return permissions
+}
+
+// ecsPortRange returns the IP protocol and port range for a security group
+// rule of a single port
+func ecsPortRange(sg config.SecurityGroup, port string) (string, string) {
+	protocol := sg.GetProtocol()
+	if protocol != "tcp" && protocol != "udp" {
+		return protocol, "-1/-1"
+	} else if strings.Contains(port, "/") {
 		// Port range like "80/80" or "1/65535"
-		ipProtocol = "tcp"
+		return protocol, port
+	}
+	// Single port like "22", convert to range
+	return protocol, fmt.Sprintf("%s/%s", port, port)
+}
+
+// revokeECSPermissions revokes rules from an ECS security group, matching the
+// rules added by authorizeECSPermissions. Rules that no longer exist are
+// ignored.
+func (c *Client) revokeECSPermissions(sg config.SecurityGroup, permissions []ecsPermission) error {
+	return revokeIgnoringMissing(permissions, func(batch []ecsPermission) error {
+		return c.revokeECSBatch(sg, batch)
+	})
+}
+
+// revokeIgnoringMissing revokes a batch of rules. A batch fails as a whole
+// when any of its rules is already gone, so the rules are then revoked one
+// at a time, ignoring only the missing ones.
+func revokeIgnoringMissing(permissions []ecsPermission, revoke func([]ecsPermission) error) error {
+	err := revoke(permissions)
+	if err == nil || !isMissingRuleError(err) {
+		return err
+	}
+	if len(permissions) == 1 {
+		return nil
+	}
+	for _, permission := range permissions {
+		err := revoke([]ecsPermission{permission})
+		if err != nil && !isMissingRuleError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isMissingRuleError reports whether a revoke failed because a rule does not
+// exist
+func isMissingRuleError(err error) bool {
+	return strings.Contains(err.Error(), "InvalidParam.SourceCidrIp") || strings.Contains(err.Error(), "InvalidParam.DestCidrIp")
+}
+
+// revokeECSBatch revokes rules from an ECS security group in one request
+func (c *Client) revokeECSBatch(sg config.SecurityGroup, permissions []ecsPermission) error {
+	priority := fmt.Sprintf("%d", sg.Priority)
+
+	var err error
+	if sg.GetDirection() == "egress" {
+		var rules []ecs.RevokeSecurityGroupEgressPermissions
+		for _, permission := range permissions {
+			rule := ecs.RevokeSecurityGroupEgressPermissions{
+				Policy:     sg.GetPolicy(),
+				Priority:   priority,
+				IpProtocol: permission.protocol,
+				PortRange:  permission.portRange,
+				NicType:    sg.GetNicType(),
+			}
+			if ipset.IsIPv6(permission.cidr) {
+				rule.Ipv6DestCidrIp = permission.cidr
+			} else {
+				rule.DestCidrIp = permission.cidr
+			}
+			rules = append(rules, rule)
+		}
+
+		request := ecs.CreateRevokeSecurityGroupEgressRequest()
+		request.Scheme = "https"
+		request.SecurityGroupId = sg.SecurityGroupID
+		request.Permissions = &rules
+		_, err = c.ecsClient.RevokeSecurityGroupEgress(request)
 	} else {
-		// Single port like "22", convert to range
-		ipProtocol = "tcp"
-		portRange = fmt.Sprintf("%s/%s", sg.Port, sg.Port)
+		var rules []ecs.RevokeSecurityGroupPermissions
+		for _, permission := range permissions {
+			rule := ecs.RevokeSecurityGroupPermissions{
+				Policy:     sg.GetPolicy(),
+				Priority:   priority,
+				IpProtocol: permission.protocol,
+				PortRange:  permission.portRange,
+				NicType:    sg.GetNicType(),
+			}
+			if ipset.IsIPv6(permission.cidr) {
+				rule.Ipv6SourceCidrIp = permission.cidr
+			} else {
+				rule.SourceCidrIp = permission.cidr
+			}
+			rules = append(rules, rule)
+		}
+
+		request := ecs.CreateRevokeSecurityGroupRequest()
+		request.Scheme = "https"
+		request.SecurityGroupId = sg.SecurityGroupID
+		request.Permissions = &rules
+		_, err = c.ecsClient.RevokeSecurityGroup(request)
 	}
-	
-	request.IpProtocol = ipProtocol
-	request.PortRange = portRange
-	request.SourceCidrIp = fmt.Sprintf("%s/32", ip)
-	request.Priority = fmt.Sprintf("%d", sg.Priority)
+	return err
+}
 
-	_, err := c.ecsClient.RevokeSecurityGroup(request)
-	if err != nil {
-		// If the rule doesn't exist, it's not an error for us
-		if strings.Contains(err.Error(), "InvalidParam.SourceCidrIp") {
-			return nil
+// authorizeECSPermissions adds rules to an ECS security group
+func (c *Client) authorizeECSPermissions(sg config.SecurityGroup, owner string, permissions []ecsPermission) error {
+	priority := fmt.Sprintf("%d", sg.Priority)
+	description := Description(owner, maxECSDescriptionLen)
+
+	if sg.GetDirection() == "egress" {
+		var rules []ecs.AuthorizeSecurityGroupEgressPermissions
+		for _, permission := range permissions {
+			rule := ecs.AuthorizeSecurityGroupEgressPermissions{
+				Policy:      sg.GetPolicy(),
+				Priority:    priority,
+				IpProtocol:  permission.protocol,
+				PortRange:   permission.portRange,
+				NicType:     sg.GetNicType(),
+				Description: description,
+			}
+			if ipset.IsIPv6(permission.cidr) {
+				rule.Ipv6DestCidrIp = permission.cidr
+			} else {
+				rule.DestCidrIp = permission.cidr
+			}
+			rules = append(rules, rule)
 		}
+
+		request := ecs.CreateAuthorizeSecurityGroupEgressRequest()
+		request.Scheme = "https"
+		request.SecurityGroupId = sg.SecurityGroupID
+		request.Permissions = &rules
+		_, err := c.ecsClient.AuthorizeSecurityGroupEgress(request)
 		return err
 	}
 
-	return nil
-}
+	var rules []ecs.AuthorizeSecurityGroupPermissions
+	for _, permission := range permissions {
+		rule := ecs.AuthorizeSecurityGroupPermissions{
+			Policy:      sg.GetPolicy(),
+			Priority:    priority,
+			IpProtocol:  permission.protocol,
+			PortRange:   permission.portRange,
+			NicType:     sg.GetNicType(),
+			Description: description,
+		}
+		if ipset.IsIPv6(permission.cidr) {
+			rule.Ipv6SourceCidrIp = permission.cidr
+		} else {
+			rule.SourceCidrIp = permission.cidr
+		}
+		rules = append(rules, rule)
+	}
 
-// addIPToECSSecurityGroup adds an IP to ECS security group
-func (c *Client) addIPToECSSecurityGroup(ip string, sg config.SecurityGroup) error {
 	request := ecs.CreateAuthorizeSecurityGroupRequest()
 	request.Scheme = "https"
 	request.SecurityGroupId = sg.SecurityGroupID
-	
-	// Parse port configuration
-	portRange := sg.Port
-	ipProtocol := "tcp"
-	
-	// Handle special port ranges
-	if portRange == "-1/-1" {
-		ipProtocol = "all"
-		portRange = "-1/-1"
-	} else if strings.Contains(portRange, "/") {
-		// Port range like "80/80" or "1/65535"
-		ipProtocol = "tcp"
-	} else {
-		// Single port like "22", convert to range
-		ipProtocol = "tcp"
-		portRange = fmt.Sprintf("%s/%s", sg.Port, sg.Port)
-	}
-	
-	request.IpProtocol = ipProtocol
-	request.PortRange = portRange
-	request.SourceCidrIp = fmt.Sprintf("%s/32", ip)
-	request.Priority = fmt.Sprintf("%d", sg.Priority)
-	request.Description = "Auto added by cloud-whitelist-manager"
-
+	request.Permissions = &rules
 	_, err := c.ecsClient.AuthorizeSecurityGroup(request)
 	return err
 }
 
-// UpdateRDSWhitelist updates RDS whitelist for multiple instances
-func (c *Client) UpdateRDSWhitelist(oldIP, newIP string) error {
-	if !c.config.RDS.Enabled {
-		return nil
+// SyncRDSWhitelist removes and adds the given CIDR entries in an RDS whitelist group
+func (c *Client) SyncRDSWhitelist(iw config.InstanceWhitelist, add, remove []string) error {
+	regional, err := c.Region(iw.RegionID)
+	if err != nil {
+		return err
+	}
+	if regional != c {
+		return regional.SyncRDSWhitelist(iw, add, remove)
 	}
 
-	// Process each RDS instance whitelist
-	for _, iw := range c.config.RDS.InstanceWhitelists {
-		// Get current whitelist for this instance
-		currentWhitelist, err := c.getRDSWhitelist(iw)
+	// Get current whitelist for this instance
+	currentWhitelist, err := c.getRDSWhitelist(iw)
+	if err != nil {
+		return fmt.Errorf("failed to get RDS whitelist for instance %s: %v", iw.InstanceID, err)
+	}
+
+	add, remove = filterWhitelistChanges(currentWhitelist, add, remove)
+
+	if len(remove) > 0 {
+		err = c.modifyRDSWhitelist(iw, "Delete", remove)
 		if err != nil {
-			return fmt.Errorf("failed to get RDS whitelist for instance %s: %v", iw.InstanceID, err)
+			return fmt.Errorf("failed to remove entries from RDS whitelist for instance %s: %v", iw.InstanceID, err)
 		}
+	}
 
-		// Update whitelist
-		newWhitelist := updateIPList(currentWhitelist, oldIP, newIP)
-		
-		err = c.setRDSWhitelist(newWhitelist, iw)
+	if len(add) > 0 {
+		err = c.modifyRDSWhitelist(iw, "Append", add)
 		if err != nil {
-			return fmt.Errorf("failed to update RDS whitelist for instance %s: %v", iw.InstanceID, err)
+			return fmt.Errorf("failed to add entries to RDS whitelist for instance %s: %v", iw.InstanceID, err)
 		}
 	}
 
@@ -207,17 +431,22 @@ func (c *Client) getRDSWhitelist(iw config.InstanceWhitelist) (string, error) {
 		}
 	}
 
+	// A dedicated group is created by the first append
+	if iw.Dedicated {
+		return "", nil
+	}
 	return "", fmt.Errorf("whitelist group %s not found for RDS instance %s", iw.WhitelistName, iw.InstanceID)
 }
 
-// setRDSWhitelist sets RDS whitelist for a specific instance
-func (c *Client) setRDSWhitelist(whitelist string, iw config.InstanceWhitelist) error {
+// modifyRDSWhitelist appends or deletes entries of an RDS whitelist group
+func (c *Client) modifyRDSWhitelist(iw config.InstanceWhitelist, mode string, cidrs []string) error {
 	request := rds.CreateModifySecurityIpsRequest()
 	request.Scheme = "https"
 	request.DBInstanceId = iw.InstanceID
-	request.SecurityIps = whitelist
+	request.SecurityIps = whitelistEntries(cidrs)
 	request.WhitelistNetworkType = "MIX" // Support both VPC and classic
 	request.DBInstanceIPArrayName = iw.WhitelistName // Add the whitelist name
+	request.ModifyMode = mode
 
 	_, err := c.rdsClient.ModifySecurityIps(request)
 	return err
@@ -228,26 +457,35 @@ func (c *Client) GetConfig() *config.Aliyun {
 	return c.config
 }
 
-// UpdateRedisWhitelist updates Redis whitelist for multiple instances
-func (c *Client) UpdateRedisWhitelist(oldIP, newIP string) error {
-	if !c.config.Redis.Enabled {
-		return nil
+// SyncRedisWhitelist removes and adds the given CIDR entries in a Redis whitelist group
+func (c *Client) SyncRedisWhitelist(iw config.InstanceWhitelist, add, remove []string) error {
+	regional, err := c.Region(iw.RegionID)
+	if err != nil {
+		return err
+	}
+	if regional != c {
+		return regional.SyncRedisWhitelist(iw, add, remove)
 	}
 
-	// Process each Redis instance whitelist
-	for _, iw := range c.config.Redis.InstanceWhitelists {
-		// Get current whitelist for this instance
-		currentWhitelist, err := c.getRedisWhitelist(iw)
+	// Get current whitelist for this instance
+	currentWhitelist, err := c.getRedisWhitelist(iw)
+	if err != nil {
+		return fmt.Errorf("failed to get Redis whitelist for instance %s: %v", iw.InstanceID, err)
+	}
+
+	add, remove = filterWhitelistChanges(currentWhitelist, add, remove)
+
+	if len(remove) > 0 {
+		err = c.modifyRedisWhitelist(iw, "Delete", remove)
 		if err != nil {
-			return fmt.Errorf("failed to get Redis whitelist for instance %s: %v", iw.InstanceID, err)
+			return fmt.Errorf("failed to remove entries from Redis whitelist for instance %s: %v", iw.InstanceID, err)
 		}
+	}
 
-		// Update whitelist
-		newWhitelist := updateIPList(currentWhitelist, oldIP, newIP)
-		
-		err = c.setRedisWhitelist(newWhitelist, iw)
+	if len(add) > 0 {
+		err = c.modifyRedisWhitelist(iw, "Append", add)
 		if err != nil {
-			return fmt.Errorf("failed to update Redis whitelist for instance %s: %v", iw.InstanceID, err)
+			return fmt.Errorf("failed to add entries to Redis whitelist for instance %s: %v", iw.InstanceID, err)
 		}
 	}
 
@@ -272,41 +510,56 @@ func (c *Client) getRedisWhitelist(iw config.InstanceWhitelist) (string, error)
 		}
 	}
 
+	// A dedicated group is created by the first append
+	if iw.Dedicated {
+		return "", nil
+	}
 	return "", fmt.Errorf("whitelist group %s not found for Redis instance %s", iw.WhitelistName, iw.InstanceID)
 }
 
-// setRedisWhitelist sets Redis whitelist for a specific instance
-func (c *Client) setRedisWhitelist(whitelist string, iw config.InstanceWhitelist) error {
+// modifyRedisWhitelist appends or deletes entries of a Redis whitelist group
+func (c *Client) modifyRedisWhitelist(iw config.InstanceWhitelist, mode string, cidrs []string) error {
 	request := r_kvstore.CreateModifySecurityIpsRequest()
 	request.Scheme = "https"
 	request.InstanceId = iw.InstanceID
-	request.SecurityIps = whitelist
+	request.SecurityIps = whitelistEntries(cidrs)
 	request.SecurityIpGroupName = iw.WhitelistName
+	request.ModifyMode = mode
 
 	_, err := c.redisClient.ModifySecurityIps(request)
 	return err
 }
 
-// UpdateCLBWhitelist updates CLB whitelist for multiple ACLs
-func (c *Client) UpdateCLBWhitelist(oldIP, newIP string) error {
-	if !c.config.CLB.Enabled {
-		return nil
+// SyncCLBWhitelist removes and adds the given CIDR entries in a CLB access
+// control list, tagging added entries with the owner ID
+func (c *Client) SyncCLBWhitelist(lbw config.LoadBalancerWhitelist, owner string, add, remove []string) error {
+	regional, err := c.Region(lbw.RegionID)
+	if err != nil {
+		return err
+	}
+	if regional != c {
+		return regional.SyncCLBWhitelist(lbw, owner, add, remove)
+	}
+
+	// Get current entries for this ACL
+	currentWhitelist, err := c.getCLBWhitelist(lbw)
+	if err != nil {
+		return fmt.Errorf("failed to get CLB whitelist for ACL %s: %v", lbw.AclID, err)
 	}
 
-	// Process each CLB whitelist
-	for _, lbw := range c.config.CLB.LoadBalancerWhitelists {
-		// Get current whitelist for this ACL
-		currentWhitelist, err := c.getCLBWhitelist(lbw)
+	add, remove = filterWhitelistChanges(currentWhitelist, add, remove)
+
+	if len(remove) > 0 {
+		err = c.modifyCLBWhitelist(lbw, "", remove)
 		if err != nil {
-			return fmt.Errorf("failed to get CLB whitelist for ACL %s: %v", lbw.AclID, err)
+			return fmt.Errorf("failed to remove entries from CLB whitelist for ACL %s: %v", lbw.AclID, err)
 		}
+	}
 
-		// Update whitelist
-		newWhitelist := updateIPList(currentWhitelist, oldIP, newIP)
-		
-		err = c.setCLBWhitelist(newWhitelist, lbw)
+	if len(add) > 0 {
+		err = c.modifyCLBWhitelist(lbw, Description(owner, maxCLBCommentLen), add)
 		if err != nil {
-			return fmt.Errorf("failed to update CLB whitelist for ACL %s: %v", lbw.AclID, err)
+			return fmt.Errorf("failed to add entries to CLB whitelist for ACL %s: %v", lbw.AclID, err)
 		}
 	}
 
@@ -327,110 +580,81 @@ func (c *Client) getCLBWhitelist(lbw config.LoadBalancerWhitelist) (string, erro
 	// Build whitelist string from entries
 	var ips []string
 	for _, entry := range response.AclEntrys.AclEntry {
-		ips = append(ips, strings.TrimSuffix(entry.AclEntryIP, "/32"))
+		ips = append(ips, entry.AclEntryIP)
 	}
 	return strings.Join(ips, ","), nil
 }
 
-// setCLBWhitelist sets CLB whitelist for a specific ACL
-func (c *Client) setCLBWhitelist(whitelist string, lbw config.LoadBalancerWhitelist) error {
-	// Clear existing entries
-	clearRequest := slb.CreateRemoveAccessControlListEntryRequest()
-	clearRequest.Scheme = "https"
-	clearRequest.AclId = lbw.AclID
-	
-	// We need to get current entries first to remove them
-	entriesRequest := slb.CreateDescribeAccessControlListAttributeRequest()
-	entriesRequest.Scheme = "https"
-	entriesRequest.AclId = lbw.AclID
-	
-	entriesResponse, err := c.clbClient.DescribeAccessControlListAttribute(entriesRequest)
+// modifyCLBWhitelist adds entries with the given comment to a CLB access
+// control list, or removes them when the comment is empty
+func (c *Client) modifyCLBWhitelist(lbw config.LoadBalancerWhitelist, comment string, cidrs []string) error {
+	add := comment != ""
+	var entries []map[string]string
+	for _, cidr := range cidrs {
+		entry := map[string]string{"entry": cidr}
+		if add {
+			entry["comment"] = comment
+		}
+		entries = append(entries, entry)
+	}
+	entriesJSON, err := json.Marshal(entries)
 	if err != nil {
 		return err
 	}
-	
-	if len(entriesResponse.AclEntrys.AclEntry) > 0 {
-		// Prepare entries to remove
-		var entriesToRemove []map[string]string
-		for _, entry := range entriesResponse.AclEntrys.AclEntry {
-			entriesToRemove = append(entriesToRemove, map[string]string{
-				"entry":   entry.AclEntryIP,
-				"comment": entry.AclEntryComment,
-			})
-		}
-		
-		// Convert to JSON string
-		entriesJSON, _ := json.Marshal(entriesToRemove)
-		clearRequest.AclEntrys = string(entriesJSON)
-		
-		_, err = c.clbClient.RemoveAccessControlListEntry(clearRequest)
-		if err != nil {
-			return err
+
+	if add {
+		request := slb.CreateAddAccessControlListEntryRequest()
+		request.Scheme = "https"
+		request.AclId = lbw.AclID
+		request.AclEntrys = string(entriesJSON)
+
+		_, err = c.clbClient.AddAccessControlListEntry(request)
+		return err
+	}
+
+	request := slb.CreateRemoveAccessControlListEntryRequest()
+	request.Scheme = "https"
+	request.AclId = lbw.AclID
+	request.AclEntrys = string(entriesJSON)
+
+	_, err = c.clbClient.RemoveAccessControlListEntry(request)
+	return err
+}
+
+// filterWhitelistChanges drops additions that are already present in the
+// current whitelist and removals that are already absent from it
+func filterWhitelistChanges(currentList string, add, remove []string) ([]string, []string) {
+	current := make(map[string]bool)
+	for _, entry := range strings.Split(currentList, ",") {
+		normalized, err := ipset.Normalize(entry)
+		if err == nil {
+			current[normalized] = true
 		}
 	}
 
-	// Add new entries
-	if whitelist != "" {
-		addRequest := slb.CreateAddAccessControlListEntryRequest()
-		addRequest.Scheme = "https"
-		addRequest.AclId = lbw.AclID
-		
-		// Prepare new entries
-		ips := strings.Split(whitelist, ",")
-		var entriesToAdd []map[string]string
-		for _, ip := range ips {
-			ip = strings.TrimSpace(ip)
-			if ip != "" {
-				entriesToAdd = append(entriesToAdd, map[string]string{
-					"entry":   ip + "/32",
-					"comment": "Auto added by cloud-whitelist-manager",
-				})
-			}
+	var toAdd, toRemove []string
+	for _, cidr := range add {
+		if !current[cidr] {
+			toAdd = append(toAdd, cidr)
 		}
-		
-		if len(entriesToAdd) > 0 {
-			entriesJSON, _ := json.Marshal(entriesToAdd)
-			addRequest.AclEntrys = string(entriesJSON)
-			
-			_, err = c.clbClient.AddAccessControlListEntry(addRequest)
-			if err != nil {
-				return err
-			}
+	}
+	for _, cidr := range remove {
+		if current[cidr] {
+			toRemove = append(toRemove, cidr)
 		}
 	}
-
-	return nil
+	return toAdd, toRemove
 }
 
-// updateIPList updates IP list by removing old IP and adding new IP
-func updateIPList(currentList, oldIP, newIP string) string {
-	// Split current list into IPs
-	ips := strings.Split(currentList, ",")
-	ipMap := make(map[string]bool)
-	
-	// Add all IPs to map
-	for _, ip := range ips {
-		ip = strings.TrimSpace(ip)
-		if ip != "" {
-			ipMap[ip] = true
+// whitelistEntries formats CIDR entries for RDS and Redis whitelists, which
+// list single hosts as plain IP addresses
+func whitelistEntries(cidrs []string) string {
+	var entries []string
+	for _, cidr := range cidrs {
+		if ipset.IsHost(cidr) {
+			cidr = cidr[:strings.Index(cidr, "/")]
 		}
+		entries = append(entries, cidr)
 	}
-	
-	// Remove old IP if it exists
-	if oldIP != "" {
-		delete(ipMap, oldIP)
-	}
-	
-	// Add new IP if it doesn't exist
-	if newIP != "" {
-		ipMap[newIP] = true
-	}
-	
-	// Build new list
-	var newIPs []string
-	for ip := range ipMap {
-		newIPs = append(newIPs, ip)
-	}
-	
-	return strings.Join(newIPs, ",")
-}
\ No newline at end of file
+	return strings.Join(entries, ",")
+}
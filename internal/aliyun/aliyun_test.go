@@ -1,6 +1,8 @@
 package aliyun
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
@@ -52,4 +54,159 @@ func TestCLBConfigStructures(t *testing.T) {
 	if lbw.AclID != "acl-12345" {
 		t.Errorf("Expected acl-12345, got %s", lbw.AclID)
 	}
-}
\ No newline at end of file
+}
+
+func TestECSPortRange(t *testing.T) {
+	tests := []struct {
+		sg       config.SecurityGroup
+		protocol string
+		port     string
+	}{
+		{config.SecurityGroup{Port: "22"}, "tcp", "22/22"},
+		{config.SecurityGroup{Port: "80/443"}, "tcp", "80/443"},
+		{config.SecurityGroup{Port: "-1/-1"}, "all", "-1/-1"},
+		{config.SecurityGroup{Port: "53", Protocol: "UDP"}, "udp", "53/53"},
+		{config.SecurityGroup{Port: "-1/-1", Protocol: "icmp"}, "icmp", "-1/-1"},
+	}
+	for _, test := range tests {
+		protocol, port := ecsPortRange(test.sg, test.sg.Port)
+		if protocol != test.protocol || port != test.port {
+			t.Errorf("ecsPortRange(%+v) = %s %s, expected %s %s", test.sg, protocol, port, test.protocol, test.port)
+		}
+	}
+}
+
+func TestECSPermissions(t *testing.T) {
+	sg := config.SecurityGroup{Ports: []string{"80", "8000/8100"}, Protocol: "tcp"}
+	permissions := ecsPermissions(sg, []string{"192.168.1.1/32", "2001:db8::/32"})
+
+	expected := []ecsPermission{
+		{protocol: "tcp", portRange: "80/80", cidr: "192.168.1.1/32"},
+		{protocol: "tcp", portRange: "80/80", cidr: "2001:db8::/32"},
+		{protocol: "tcp", portRange: "8000/8100", cidr: "192.168.1.1/32"},
+		{protocol: "tcp", portRange: "8000/8100", cidr: "2001:db8::/32"},
+	}
+	if !reflect.DeepEqual(permissions, expected) {
+		t.Errorf("Expected %v, got %v", expected, permissions)
+	}
+
+	if permissions := ecsPermissions(sg, nil); len(permissions) != 0 {
+		t.Errorf("Expected no permissions without CIDRs, got %v", permissions)
+	}
+}
+
+func TestRevokeIgnoringMissing(t *testing.T) {
+	permissions := []ecsPermission{
+		{protocol: "tcp", portRange: "22/22", cidr: "192.168.1.1/32"},
+		{protocol: "tcp", portRange: "22/22", cidr: "192.168.1.2/32"},
+		{protocol: "tcp", portRange: "22/22", cidr: "192.168.1.3/32"},
+	}
+	missing := "192.168.1.2/32"
+
+	// The batch fails because one rule is gone, the others are still revoked
+	var revoked []string
+	err := revokeIgnoringMissing(permissions, func(batch []ecsPermission) error {
+		for _, permission := range batch {
+			if permission.cidr == missing {
+				return errors.New("InvalidParam.SourceCidrIp: rule not found")
+			}
+		}
+		for _, permission := range batch {
+			revoked = append(revoked, permission.cidr)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected missing rule to be ignored, got %v", err)
+	}
+	if expected := []string{"192.168.1.1/32", "192.168.1.3/32"}; !reflect.DeepEqual(revoked, expected) {
+		t.Errorf("Expected %v revoked, got %v", expected, revoked)
+	}
+
+	// Other errors are returned
+	err = revokeIgnoringMissing(permissions, func(batch []ecsPermission) error {
+		return errors.New("Forbidden.RAM")
+	})
+	if err == nil {
+		t.Error("Expected error to be returned")
+	}
+}
+
+func TestRegion(t *testing.T) {
+	client, err := NewClient(&config.Aliyun{AccessKeyID: "test_key", AccessKeySecret: "test_secret", RegionID: "cn-hangzhou"})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if regional, _ := client.Region(""); regional != client {
+		t.Error("Expected empty region to return the account client")
+	}
+
+	shanghai, err := client.Region("cn-shanghai")
+	if err != nil {
+		t.Fatalf("Failed to create regional client: %v", err)
+	}
+	if shanghai == client || shanghai.GetConfig().RegionID != "cn-shanghai" {
+		t.Errorf("Expected a client of cn-shanghai, got %s", shanghai.GetConfig().RegionID)
+	}
+	if again, _ := client.Region("cn-shanghai"); again != shanghai {
+		t.Error("Expected regional client to be cached")
+	}
+	if back, _ := shanghai.Region("cn-hangzhou"); back != client {
+		t.Error("Expected account region to return the account client")
+	}
+	beijing, _ := shanghai.Region("cn-beijing")
+	if cached, _ := client.Region("cn-beijing"); cached != beijing {
+		t.Error("Expected regional clients to share the cache of the account client")
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	cfg := &config.Aliyun{AccessKeyID: "test_key", AccessKeySecret: "test_secret", RegionID: "cn-hangzhou"}
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	changed := *cfg
+	changed.ECS.Enabled = true
+	reconfigured, err := client.Reconfigure(&changed)
+	if err != nil {
+		t.Fatalf("Failed to reconfigure: %v", err)
+	}
+	if reconfigured.ecsClient != client.ecsClient || !reconfigured.GetConfig().ECS.Enabled {
+		t.Error("Expected the SDK clients to be reused with the new configuration")
+	}
+
+	// Clients of other regions are kept with the new configuration
+	shanghai, err := client.Region("cn-shanghai")
+	if err != nil {
+		t.Fatalf("Failed to create regional client: %v", err)
+	}
+	reconfigured, err = client.Reconfigure(&changed)
+	if err != nil {
+		t.Fatalf("Failed to reconfigure: %v", err)
+	}
+	regional, err := reconfigured.Region("cn-shanghai")
+	if err != nil {
+		t.Fatalf("Failed to get regional client: %v", err)
+	}
+	if regional.ecsClient != shanghai.ecsClient || !regional.GetConfig().ECS.Enabled || regional.GetConfig().RegionID != "cn-shanghai" {
+		t.Error("Expected the regional SDK clients to be reused with the new configuration")
+	}
+	if back, _ := regional.Region(""); back != regional {
+		t.Error("Expected empty region to return the regional client")
+	}
+	if root, _ := regional.Region("cn-hangzhou"); root != reconfigured {
+		t.Error("Expected regional client to belong to the reconfigured client")
+	}
+
+	changed.AccessKeySecret = "rotated_secret"
+	reconfigured, err = client.Reconfigure(&changed)
+	if err != nil {
+		t.Fatalf("Failed to reconfigure: %v", err)
+	}
+	if reconfigured.ecsClient == client.ecsClient {
+		t.Error("Expected new SDK clients for new credentials")
+	}
+}
@@ -0,0 +1,361 @@
+package aliyun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials/providers"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/signers"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+// Environment variables read by the env and oidc credential types, the same
+// ones used by the Aliyun CLI and the ACK RRSA webhook
+const (
+	envAccessKeyID     = "ALIBABA_CLOUD_ACCESS_KEY_ID"
+	envAccessKeySecret = "ALIBABA_CLOUD_ACCESS_KEY_SECRET"
+	envSecurityToken   = "ALIBABA_CLOUD_SECURITY_TOKEN"
+	envProfile         = "ALIBABA_CLOUD_PROFILE"
+	envRoleArn         = "ALIBABA_CLOUD_ROLE_ARN"
+	envOIDCProviderArn = "ALIBABA_CLOUD_OIDC_PROVIDER_ARN"
+	envOIDCTokenFile   = "ALIBABA_CLOUD_OIDC_TOKEN_FILE"
+)
+
+// stsEndpoint is the STS endpoint used for anonymous OIDC requests
+var stsEndpoint = "https://sts.aliyuncs.com"
+
+// stsRefreshMargin is how long before expiration temporary credentials are
+// refreshed
+const stsRefreshMargin = 3 * time.Minute
+
+// credential is how the SDK clients of an account authenticate, either an SDK
+// credential or a signer refreshing its own temporary credentials
+type credential struct {
+	credential auth.Credential
+	signer     auth.Signer
+}
+
+// sdkCredential returns the credential passed when creating SDK clients
+func (c *credential) sdkCredential() auth.Credential {
+	if c.signer != nil {
+		// Replaced by the signer in apply
+		return credentials.NewAccessKeyCredential("", "")
+	}
+	return c.credential
+}
+
+// apply installs the signer, if any, on an SDK client
+func (c *credential) apply(client *sdk.Client) {
+	if c.signer != nil {
+		client.SetSigner(c.signer)
+	}
+}
+
+// newCredential creates the credential of an account, using the access key
+// when no credentials block is configured
+func newCredential(cfg *config.Aliyun) (*credential, error) {
+	if cfg.Credentials == nil {
+		return &credential{credential: credentials.NewAccessKeyCredential(cfg.AccessKeyID, cfg.AccessKeySecret)}, nil
+	}
+	return resolveCredentials(cfg.Credentials, cfg.RegionID)
+}
+
+// resolveCredentials creates the credential of a credentials block
+func resolveCredentials(c *config.Credentials, regionID string) (*credential, error) {
+	switch c.Type {
+	case config.CredentialAccessKey:
+		return &credential{credential: credentials.NewAccessKeyCredential(c.AccessKeyID, c.AccessKeySecret)}, nil
+	case config.CredentialEnv:
+		return envCredential()
+	case config.CredentialProfile:
+		return profileCredential(c)
+	case config.CredentialECSRamRole:
+		roleName := c.RoleName
+		if roleName == "" {
+			var err error
+			roleName, err = (&providers.InstanceMetadataProvider{}).GetRoleName()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get the RAM role of the instance: %v", err)
+			}
+		}
+		return &credential{credential: credentials.NewEcsRamRoleCredential(roleName)}, nil
+	case config.CredentialAssumeRole:
+		return assumeRoleCredential(c, regionID)
+	case config.CredentialOIDC:
+		return oidcCredential(c)
+	default:
+		return nil, fmt.Errorf("unsupported credential type %q", c.Type)
+	}
+}
+
+// envCredential reads an access key, and optionally an STS token, from the
+// environment
+func envCredential() (*credential, error) {
+	id := os.Getenv(envAccessKeyID)
+	secret := os.Getenv(envAccessKeySecret)
+	if id == "" || secret == "" {
+		return nil, fmt.Errorf("%s and %s must be set", envAccessKeyID, envAccessKeySecret)
+	}
+	if token := os.Getenv(envSecurityToken); token != "" {
+		return &credential{credential: credentials.NewStsTokenCredential(id, secret, token)}, nil
+	}
+	return &credential{credential: credentials.NewAccessKeyCredential(id, secret)}, nil
+}
+
+// cliConfig is the Aliyun CLI configuration file
+type cliConfig struct {
+	Current  string       `json:"current"`
+	Profiles []cliProfile `json:"profiles"`
+}
+
+// cliProfile is a profile of the Aliyun CLI configuration file
+type cliProfile struct {
+	Name            string `json:"name"`
+	Mode            string `json:"mode"`
+	AccessKeyID     string `json:"access_key_id"`
+	AccessKeySecret string `json:"access_key_secret"`
+	StsToken        string `json:"sts_token"`
+	RamRoleName     string `json:"ram_role_name"`
+	RamRoleArn      string `json:"ram_role_arn"`
+	RamSessionName  string `json:"ram_session_name"`
+	ExpiredSeconds  int    `json:"expired_seconds"`
+}
+
+// profileCredential reads a profile of the Aliyun CLI configuration file
+func profileCredential(c *config.Credentials) (*credential, error) {
+	path := c.ProfileFile
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the home directory: %v", err)
+		}
+		path = filepath.Join(home, ".aliyun", "config.json")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile file: %v", err)
+	}
+	var cfg cliConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse profile file %s: %v", path, err)
+	}
+
+	name := c.Profile
+	if name == "" {
+		name = os.Getenv(envProfile)
+	}
+	if name == "" {
+		name = cfg.Current
+	}
+	if name == "" {
+		name = "default"
+	}
+
+	for _, profile := range cfg.Profiles {
+		if profile.Name != name {
+			continue
+		}
+		switch profile.Mode {
+		case "AK", "":
+			return &credential{credential: credentials.NewAccessKeyCredential(profile.AccessKeyID, profile.AccessKeySecret)}, nil
+		case "StsToken":
+			return &credential{credential: credentials.NewStsTokenCredential(profile.AccessKeyID, profile.AccessKeySecret, profile.StsToken)}, nil
+		case "RamRoleArn":
+			sessionName := profile.RamSessionName
+			if sessionName == "" {
+				sessionName = config.DefaultRoleSessionName
+			}
+			return &credential{credential: credentials.NewRamRoleArnCredential(profile.AccessKeyID, profile.AccessKeySecret, profile.RamRoleArn, sessionName, profile.ExpiredSeconds)}, nil
+		case "EcsRamRole":
+			return &credential{credential: credentials.NewEcsRamRoleCredential(profile.RamRoleName)}, nil
+		default:
+			return nil, fmt.Errorf("profile %s: unsupported mode %s", name, profile.Mode)
+		}
+	}
+	return nil, fmt.Errorf("profile %s not found in %s", name, path)
+}
+
+// assumeRoleCredential assumes a RAM role with the source credentials, env by
+// default, refreshing the session before it expires
+func assumeRoleCredential(c *config.Credentials, regionID string) (*credential, error) {
+	source := c.Source
+	if source == nil {
+		source = &config.Credentials{Type: config.CredentialEnv}
+	}
+	sourceCredential, err := resolveCredentials(source, regionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create source credentials: %v", err)
+	}
+
+	client, err := sts.NewClientWithOptions(regionID, sdk.NewConfig(), sourceCredential.sdkCredential())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create STS client: %v", err)
+	}
+	sourceCredential.apply(&client.Client)
+
+	signer := &stsSigner{fetch: func() (*sts.Credentials, error) {
+		request := sts.CreateAssumeRoleRequest()
+		request.Scheme = "https"
+		request.RoleArn = c.RoleArn
+		request.RoleSessionName = c.GetRoleSessionName()
+		request.DurationSeconds = requests.NewInteger(c.GetDurationSeconds())
+		if c.ExternalID != "" {
+			// Not modeled by the SDK request
+			request.QueryParams["ExternalId"] = c.ExternalID
+		}
+
+		response, err := client.AssumeRole(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to assume role %s: %v", c.RoleArn, err)
+		}
+		return &response.Credentials, nil
+	}}
+	return &credential{signer: signer}, nil
+}
+
+// oidcCredential assumes a RAM role with an OIDC token, as mounted into ACK
+// pods by RRSA. Unset values are read from the environment, and the token file
+// is read again on every refresh since it is rotated.
+func oidcCredential(c *config.Credentials) (*credential, error) {
+	roleArn := valueOrEnv(c.RoleArn, envRoleArn)
+	providerArn := valueOrEnv(c.OIDCProviderArn, envOIDCProviderArn)
+	tokenFile := valueOrEnv(c.OIDCTokenFile, envOIDCTokenFile)
+	if roleArn == "" || providerArn == "" || tokenFile == "" {
+		return nil, fmt.Errorf("role_arn, oidc_provider_arn and oidc_token_file are required, or %s, %s and %s must be set", envRoleArn, envOIDCProviderArn, envOIDCTokenFile)
+	}
+
+	signer := &stsSigner{fetch: func() (*sts.Credentials, error) {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OIDC token: %v", err)
+		}
+		return assumeRoleWithOIDC(roleArn, providerArn, strings.TrimSpace(string(token)), c.GetRoleSessionName(), c.GetDurationSeconds())
+	}}
+	return &credential{signer: signer}, nil
+}
+
+// assumeRoleWithOIDC calls the anonymous AssumeRoleWithOIDC API, which the SDK
+// can only call signed
+func assumeRoleWithOIDC(roleArn, providerArn, token, sessionName string, duration int) (*sts.Credentials, error) {
+	form := url.Values{
+		"Action":          {"AssumeRoleWithOIDC"},
+		"Format":          {"JSON"},
+		"Version":         {"2015-04-01"},
+		"Timestamp":       {time.Now().UTC().Format("2006-01-02T15:04:05Z")},
+		"RoleArn":         {roleArn},
+		"OIDCProviderArn": {providerArn},
+		"OIDCToken":       {token},
+		"RoleSessionName": {sessionName},
+		"DurationSeconds": {strconv.Itoa(duration)},
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.PostForm(stsEndpoint, form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assume role %s with OIDC: %v", roleArn, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read STS response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to assume role %s with OIDC: HTTP %d: %s", roleArn, resp.StatusCode, body)
+	}
+
+	var response sts.AssumeRoleWithOIDCResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse STS response: %v", err)
+	}
+	return &response.Credentials, nil
+}
+
+// valueOrEnv returns the value, or the environment variable when it is empty
+func valueOrEnv(value, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
+
+// stsSigner signs requests with temporary STS credentials, fetching new ones
+// shortly before they expire
+type stsSigner struct {
+	fetch func() (*sts.Credentials, error)
+
+	mu         sync.Mutex
+	id         string
+	secret     string
+	token      string
+	expiration time.Time
+}
+
+// GetName returns the signature method
+func (s *stsSigner) GetName() string {
+	return "HMAC-SHA1"
+}
+
+// GetType returns the signature type
+func (s *stsSigner) GetType() string {
+	return ""
+}
+
+// GetVersion returns the signature version
+func (s *stsSigner) GetVersion() string {
+	return "1.0"
+}
+
+// GetAccessKeyId returns the current access key ID, refreshing the
+// credentials when needed. It is called first when a request is signed.
+func (s *stsSigner) GetAccessKeyId() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.id != "" && time.Now().Add(stsRefreshMargin).Before(s.expiration) {
+		return s.id, nil
+	}
+
+	creds, err := s.fetch()
+	if err != nil {
+		return "", err
+	}
+	expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+	if err != nil {
+		return "", fmt.Errorf("invalid STS credential expiration %q: %v", creds.Expiration, err)
+	}
+	s.id = creds.AccessKeyId
+	s.secret = creds.AccessKeySecret
+	s.token = creds.SecurityToken
+	s.expiration = expiration
+	return s.id, nil
+}
+
+// GetExtraParam returns the security token sent with every request
+func (s *stsSigner) GetExtraParam() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]string{"SecurityToken": s.token}
+}
+
+// Sign signs a request with the current access key secret
+func (s *stsSigner) Sign(stringToSign, secretSuffix string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return signers.ShaHmac1(stringToSign, s.secret+secretSuffix)
+}
@@ -0,0 +1,142 @@
+package aliyun
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+func TestEnvCredential(t *testing.T) {
+	t.Setenv(envAccessKeyID, "")
+	t.Setenv(envAccessKeySecret, "")
+	if _, err := envCredential(); err == nil {
+		t.Error("Expected error when the environment is not set")
+	}
+
+	t.Setenv(envAccessKeyID, "env_key")
+	t.Setenv(envAccessKeySecret, "env_secret")
+	t.Setenv(envSecurityToken, "env_token")
+	cred, err := envCredential()
+	if err != nil {
+		t.Fatalf("Failed to read credentials: %v", err)
+	}
+	token, ok := cred.credential.(*credentials.StsTokenCredential)
+	if !ok || token.AccessKeyId != "env_key" || token.AccessKeyStsToken != "env_token" {
+		t.Errorf("Expected STS token credential, got %#v", cred.credential)
+	}
+}
+
+func TestProfileCredential(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{
+  "current": "default",
+  "profiles": [
+    {"name": "default", "mode": "AK", "access_key_id": "default_key", "access_key_secret": "default_secret"},
+    {"name": "ops", "mode": "RamRoleArn", "access_key_id": "ops_key", "access_key_secret": "ops_secret", "ram_role_arn": "acs:ram::123456789012:role/ops"},
+    {"name": "sso", "mode": "CloudSSO"}
+  ]
+}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envProfile, "")
+
+	cred, err := profileCredential(&config.Credentials{Type: config.CredentialProfile, ProfileFile: path})
+	if err != nil {
+		t.Fatalf("Failed to read current profile: %v", err)
+	}
+	if ak, ok := cred.credential.(*credentials.AccessKeyCredential); !ok || ak.AccessKeyId != "default_key" {
+		t.Errorf("Expected access key of the current profile, got %#v", cred.credential)
+	}
+
+	cred, err = profileCredential(&config.Credentials{Type: config.CredentialProfile, ProfileFile: path, Profile: "ops"})
+	if err != nil {
+		t.Fatalf("Failed to read ops profile: %v", err)
+	}
+	if role, ok := cred.credential.(*credentials.RamRoleArnCredential); !ok || role.RoleArn != "acs:ram::123456789012:role/ops" || role.RoleSessionName != config.DefaultRoleSessionName {
+		t.Errorf("Expected RAM role credential, got %#v", cred.credential)
+	}
+
+	if _, err := profileCredential(&config.Credentials{Type: config.CredentialProfile, ProfileFile: path, Profile: "sso"}); err == nil {
+		t.Error("Expected error for unsupported mode")
+	}
+	if _, err := profileCredential(&config.Credentials{Type: config.CredentialProfile, ProfileFile: path, Profile: "missing"}); err == nil {
+		t.Error("Expected error for missing profile")
+	}
+}
+
+func TestSTSSigner(t *testing.T) {
+	fetches := 0
+	expiration := time.Now().Add(time.Hour)
+	signer := &stsSigner{fetch: func() (*sts.Credentials, error) {
+		fetches++
+		return &sts.Credentials{
+			AccessKeyId:     fmt.Sprintf("key_%d", fetches),
+			AccessKeySecret: "secret",
+			SecurityToken:   "token",
+			Expiration:      expiration.UTC().Format(time.RFC3339),
+		}, nil
+	}}
+
+	id, err := signer.GetAccessKeyId()
+	if err != nil || id != "key_1" {
+		t.Fatalf("Expected key_1, got %s, %v", id, err)
+	}
+	if id, _ := signer.GetAccessKeyId(); id != "key_1" {
+		t.Errorf("Expected cached credentials, got %s", id)
+	}
+	if signer.GetExtraParam()["SecurityToken"] != "token" {
+		t.Error("Expected security token parameter")
+	}
+
+	// Credentials about to expire are refreshed
+	signer.expiration = time.Now().Add(time.Minute)
+	if id, _ := signer.GetAccessKeyId(); id != "key_2" {
+		t.Errorf("Expected refreshed credentials, got %s", id)
+	}
+}
+
+func TestOIDCCredential(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("oidc_token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("Action") != "AssumeRoleWithOIDC" || r.FormValue("OIDCToken") != "oidc_token" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"Credentials": {"AccessKeyId": "oidc_key", "AccessKeySecret": "oidc_secret", "SecurityToken": "oidc_sts", "Expiration": %q}}`,
+			time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+	endpoint := stsEndpoint
+	stsEndpoint = server.URL
+	defer func() { stsEndpoint = endpoint }()
+
+	t.Setenv(envRoleArn, "acs:ram::123456789012:role/ack")
+	t.Setenv(envOIDCProviderArn, "acs:ram::123456789012:oidc-provider/ack")
+	t.Setenv(envOIDCTokenFile, tokenFile)
+
+	cred, err := oidcCredential(&config.Credentials{Type: config.CredentialOIDC})
+	if err != nil {
+		t.Fatalf("Failed to create OIDC credentials: %v", err)
+	}
+	if id, err := cred.signer.GetAccessKeyId(); err != nil || id != "oidc_key" {
+		t.Errorf("Expected oidc_key, got %s, %v", id, err)
+	}
+
+	t.Setenv(envOIDCTokenFile, "")
+	if _, err := oidcCredential(&config.Credentials{Type: config.CredentialOIDC}); err == nil {
+		t.Error("Expected error without a token file")
+	}
+}
@@ -0,0 +1,153 @@
+package aliyun
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/r-kvstore"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/rds"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/slb"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+// discoverPageSize is the page size used when listing resources, accepted by
+// every Describe API used for discovery
+const discoverPageSize = 50
+
+// DiscoverSecurityGroups returns the IDs of the security groups matching the
+// selector
+func (c *Client) DiscoverSecurityGroups(selector config.Selector) ([]string, error) {
+	var tags []ecs.DescribeSecurityGroupsTag
+	for _, key := range tagKeys(selector) {
+		tags = append(tags, ecs.DescribeSecurityGroupsTag{Key: key, Value: selector.Tags[key]})
+	}
+
+	var ids []string
+	for page := 1; ; page++ {
+		request := ecs.CreateDescribeSecurityGroupsRequest()
+		request.Scheme = "https"
+		request.ResourceGroupId = selector.ResourceGroupID
+		request.Tag = &tags
+		request.PageNumber = requests.NewInteger(page)
+		request.PageSize = requests.NewInteger(discoverPageSize)
+
+		response, err := c.ecsClient.DescribeSecurityGroups(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe security groups: %v", err)
+		}
+		for _, sg := range response.SecurityGroups.SecurityGroup {
+			ids = append(ids, sg.SecurityGroupId)
+		}
+		if len(response.SecurityGroups.SecurityGroup) < discoverPageSize || len(ids) >= response.TotalCount {
+			return ids, nil
+		}
+	}
+}
+
+// DiscoverRDSInstances returns the IDs of the RDS instances matching the
+// selector
+func (c *Client) DiscoverRDSInstances(selector config.Selector) ([]string, error) {
+	var tags string
+	if len(selector.Tags) > 0 {
+		// RDS expects the tags as a JSON object
+		data, err := json.Marshal(selector.Tags)
+		if err != nil {
+			return nil, err
+		}
+		tags = string(data)
+	}
+
+	var ids []string
+	for page := 1; ; page++ {
+		request := rds.CreateDescribeDBInstancesRequest()
+		request.Scheme = "https"
+		request.ResourceGroupId = selector.ResourceGroupID
+		request.Tags = tags
+		request.PageNumber = requests.NewInteger(page)
+		request.PageSize = requests.NewInteger(discoverPageSize)
+
+		response, err := c.rdsClient.DescribeDBInstances(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe RDS instances: %v", err)
+		}
+		for _, instance := range response.Items.DBInstance {
+			ids = append(ids, instance.DBInstanceId)
+		}
+		if len(response.Items.DBInstance) < discoverPageSize || len(ids) >= response.TotalRecordCount {
+			return ids, nil
+		}
+	}
+}
+
+// DiscoverRedisInstances returns the IDs of the Redis instances matching the
+// selector
+func (c *Client) DiscoverRedisInstances(selector config.Selector) ([]string, error) {
+	var tags []r_kvstore.DescribeInstancesTag
+	for _, key := range tagKeys(selector) {
+		tags = append(tags, r_kvstore.DescribeInstancesTag{Key: key, Value: selector.Tags[key]})
+	}
+
+	var ids []string
+	for page := 1; ; page++ {
+		request := r_kvstore.CreateDescribeInstancesRequest()
+		request.Scheme = "https"
+		request.ResourceGroupId = selector.ResourceGroupID
+		request.Tag = &tags
+		request.PageNumber = requests.NewInteger(page)
+		request.PageSize = requests.NewInteger(discoverPageSize)
+
+		response, err := c.redisClient.DescribeInstances(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe Redis instances: %v", err)
+		}
+		for _, instance := range response.Instances.KVStoreInstance {
+			ids = append(ids, instance.InstanceId)
+		}
+		if len(response.Instances.KVStoreInstance) < discoverPageSize || len(ids) >= response.TotalCount {
+			return ids, nil
+		}
+	}
+}
+
+// DiscoverCLBAcls returns the IDs of the CLB access control lists matching
+// the selector
+func (c *Client) DiscoverCLBAcls(selector config.Selector) ([]string, error) {
+	var tags []slb.DescribeAccessControlListsTag
+	for _, key := range tagKeys(selector) {
+		tags = append(tags, slb.DescribeAccessControlListsTag{Key: key, Value: selector.Tags[key]})
+	}
+
+	var ids []string
+	for page := 1; ; page++ {
+		request := slb.CreateDescribeAccessControlListsRequest()
+		request.Scheme = "https"
+		request.ResourceGroupId = selector.ResourceGroupID
+		request.Tag = &tags
+		request.PageNumber = requests.NewInteger(page)
+		request.PageSize = requests.NewInteger(discoverPageSize)
+
+		response, err := c.clbClient.DescribeAccessControlLists(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe CLB access control lists: %v", err)
+		}
+		for _, acl := range response.Acls.Acl {
+			ids = append(ids, acl.AclId)
+		}
+		if len(response.Acls.Acl) < discoverPageSize || len(ids) >= response.TotalCount {
+			return ids, nil
+		}
+	}
+}
+
+// tagKeys returns the tag keys of a selector in a stable order
+func tagKeys(selector config.Selector) []string {
+	var keys []string
+	for key := range selector.Tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,74 @@
+package aliyun
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
+	sdkerrors "github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+)
+
+// Kinds of API failures
+const (
+	FailureCredentials = "invalid credentials"
+	FailurePermission  = "permission denied"
+	FailureNotFound    = "not found"
+	FailureOther       = "error"
+)
+
+// serverErrorPattern extracts the code and message of SDK server errors
+// formatted into wrapping errors
+var serverErrorPattern = regexp.MustCompile(`ErrorCode: (\S+)(?s:.*?)Message: ([^\n]*)`)
+
+// Identity returns the ARN of the caller, verifying that the credentials of
+// the account work
+func (c *Client) Identity() (string, error) {
+	client, err := sts.NewClientWithOptions(c.config.RegionID, sdk.NewConfig(), c.credential.sdkCredential())
+	if err != nil {
+		return "", fmt.Errorf("failed to create STS client: %v", err)
+	}
+	c.credential.apply(&client.Client)
+
+	request := sts.CreateGetCallerIdentityRequest()
+	request.Scheme = "https"
+
+	response, err := client.GetCallerIdentity(request)
+	if err != nil {
+		return "", err
+	}
+	return response.Arn, nil
+}
+
+// Diagnose classifies an API failure and summarizes it on a single line
+func Diagnose(err error) (kind, summary string) {
+	var code, message string
+	var serverErr *sdkerrors.ServerError
+	if errors.As(err, &serverErr) {
+		code, message = serverErr.ErrorCode(), serverErr.Message()
+	} else if match := serverErrorPattern.FindStringSubmatch(err.Error()); match != nil {
+		code, message = match[1], match[2]
+	}
+
+	if code == "" {
+		summary = strings.Join(strings.Fields(err.Error()), " ")
+		if strings.Contains(summary, "not found") {
+			return FailureNotFound, summary
+		}
+		return FailureOther, summary
+	}
+
+	summary = code + ": " + strings.Join(strings.Fields(message), " ")
+	switch {
+	case strings.HasPrefix(code, "InvalidAccessKeyId"), code == "SignatureDoesNotMatch", strings.HasPrefix(code, "InvalidSecurityToken"):
+		return FailureCredentials, summary
+	case strings.HasPrefix(code, "Forbidden"), strings.HasPrefix(code, "NoPermission"), code == "AccessDenied":
+		return FailurePermission, summary
+	case strings.Contains(code, "NotFound"), strings.Contains(code, "NotExist"):
+		return FailureNotFound, summary
+	default:
+		return FailureOther, summary
+	}
+}
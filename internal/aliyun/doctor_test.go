@@ -0,0 +1,35 @@
+package aliyun
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	sdkerrors "github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+)
+
+func TestDiagnose(t *testing.T) {
+	serverError := func(code, message string) error {
+		return sdkerrors.NewServerError(400, fmt.Sprintf(`{"Code": %q, "Message": %q}`, code, message), "")
+	}
+
+	tests := []struct {
+		err     error
+		kind    string
+		summary string
+	}{
+		{serverError("InvalidAccessKeyId.NotFound", "Specified access key is not found."), FailureCredentials, "InvalidAccessKeyId.NotFound: Specified access key is not found."},
+		{serverError("Forbidden.RAM", "User not authorized to operate on the specified resource."), FailurePermission, "Forbidden.RAM: User not authorized to operate on the specified resource."},
+		{serverError("InvalidSecurityGroupId.NotFound", "The specified SecurityGroupId does not exist."), FailureNotFound, "InvalidSecurityGroupId.NotFound: The specified SecurityGroupId does not exist."},
+		// Server errors formatted into another error
+		{fmt.Errorf("failed to describe: %v", serverError("Throttling", "Request was denied due to request throttling.")), FailureOther, "Throttling: Request was denied due to request throttling."},
+		{errors.New("whitelist group default not found for RDS instance rm-test"), FailureNotFound, "whitelist group default not found for RDS instance rm-test"},
+	}
+
+	for _, test := range tests {
+		kind, summary := Diagnose(test.err)
+		if kind != test.kind || summary != test.summary {
+			t.Errorf("Expected %s, %q, got %s, %q", test.kind, test.summary, kind, summary)
+		}
+	}
+}
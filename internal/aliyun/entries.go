@@ -0,0 +1,249 @@
+package aliyun
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/slb"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/ipset"
+)
+
+// ManagedDescription prefixes the description of every ECS rule and CLB
+// entry added by the tool
+const ManagedDescription = "Auto added by cloud-whitelist-manager"
+
+// Description length limits of the cloud APIs
+const (
+	maxECSDescriptionLen = 512
+	maxCLBCommentLen     = 100
+)
+
+// Entry represents a whitelist entry as listed by the cloud API
+type Entry struct {
+	CIDR        string    // normalized CIDR
+	Description string    // ECS rule description or CLB entry comment
+	RuleID      string    // ECS security group rule ID
+	CreatedAt   time.Time // ECS rule creation time
+}
+
+// Description returns the description of an entry owned by owner, truncated
+// to the given length. The owner ID starts with the agent name, so the agent
+// survives truncation.
+func Description(owner string, maxLen int) string {
+	description := ManagedDescription
+	if owner != "" {
+		description += " owner=" + owner
+	}
+	if len(description) > maxLen {
+		description = description[:maxLen]
+	}
+	return description
+}
+
+// ParseOwner returns the owner ID encoded in a description and whether the
+// entry was added by the tool at all. Entries added before ownership tagging
+// are managed but have no owner.
+func ParseOwner(description string) (string, bool) {
+	if !strings.HasPrefix(description, ManagedDescription) {
+		return "", false
+	}
+	rest := strings.TrimPrefix(description, ManagedDescription)
+	return strings.TrimPrefix(rest, " owner="), true
+}
+
+// Owns reports whether an entry was added for the given owner ID. The
+// description may have been truncated to the limit of any of the cloud APIs,
+// but an owner ID that is merely a prefix of another does not match.
+func Owns(owner string, entry Entry) bool {
+	for _, maxLen := range []int{maxECSDescriptionLen, maxCLBCommentLen, maxPrefixListDescriptionLen} {
+		if entry.Description == Description(owner, maxLen) {
+			return true
+		}
+	}
+	return false
+}
+
+// OwnedBy reports whether an owner ID belongs to the given agent
+func OwnedBy(owner, agent string) bool {
+	return owner == agent || strings.HasPrefix(owner, agent+"/")
+}
+
+// ListECSEntries lists the rules of an ECS security group in the direction
+// of the configured rule
+func (c *Client) ListECSEntries(sg config.SecurityGroup) ([]Entry, error) {
+	regional, err := c.Region(sg.RegionID)
+	if err != nil {
+		return nil, err
+	}
+	if regional != c {
+		return regional.ListECSEntries(sg)
+	}
+
+	request := ecs.CreateDescribeSecurityGroupAttributeRequest()
+	request.Scheme = "https"
+	request.SecurityGroupId = sg.SecurityGroupID
+	request.Direction = sg.GetDirection()
+
+	response, err := c.ecsClient.DescribeSecurityGroupAttribute(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, permission := range response.Permissions.Permission {
+		source := permission.SourceCidrIp
+		if source == "" {
+			source = permission.Ipv6SourceCidrIp
+		}
+		if sg.GetDirection() == "egress" {
+			source = permission.DestCidrIp
+			if source == "" {
+				source = permission.Ipv6DestCidrIp
+			}
+		}
+		cidr, err := ipset.Normalize(source)
+		if err != nil {
+			// Rules referencing security groups or prefix lists
+			continue
+		}
+		createdAt, _ := time.Parse(time.RFC3339, permission.CreateTime)
+		entries = append(entries, Entry{
+			CIDR:        cidr,
+			Description: permission.Description,
+			RuleID:      permission.SecurityGroupRuleId,
+			CreatedAt:   createdAt,
+		})
+	}
+	return entries, nil
+}
+
+// RevokeECSEntries revokes listed rules from an ECS security group by rule ID
+func (c *Client) RevokeECSEntries(sg config.SecurityGroup, entries []Entry) error {
+	regional, err := c.Region(sg.RegionID)
+	if err != nil {
+		return err
+	}
+	if regional != c {
+		return regional.RevokeECSEntries(sg, entries)
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var ruleIDs []string
+	for _, entry := range entries {
+		ruleIDs = append(ruleIDs, entry.RuleID)
+	}
+
+	for batch := range slices.Chunk(ruleIDs, maxECSPermissions) {
+		var err error
+		if sg.GetDirection() == "egress" {
+			request := ecs.CreateRevokeSecurityGroupEgressRequest()
+			request.Scheme = "https"
+			request.SecurityGroupId = sg.SecurityGroupID
+			request.SecurityGroupRuleId = &batch
+			_, err = c.ecsClient.RevokeSecurityGroupEgress(request)
+		} else {
+			request := ecs.CreateRevokeSecurityGroupRequest()
+			request.Scheme = "https"
+			request.SecurityGroupId = sg.SecurityGroupID
+			request.SecurityGroupRuleId = &batch
+			_, err = c.ecsClient.RevokeSecurityGroup(request)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to revoke rules from ECS security group %s: %v", sg.SecurityGroupID, err)
+		}
+	}
+	return nil
+}
+
+// ListRDSEntries lists the entries of an RDS whitelist group
+func (c *Client) ListRDSEntries(iw config.InstanceWhitelist) ([]Entry, error) {
+	regional, err := c.Region(iw.RegionID)
+	if err != nil {
+		return nil, err
+	}
+	if regional != c {
+		return regional.ListRDSEntries(iw)
+	}
+
+	whitelist, err := c.getRDSWhitelist(iw)
+	if err != nil {
+		return nil, err
+	}
+	return parseEntries(whitelist), nil
+}
+
+// ListRedisEntries lists the entries of a Redis whitelist group
+func (c *Client) ListRedisEntries(iw config.InstanceWhitelist) ([]Entry, error) {
+	regional, err := c.Region(iw.RegionID)
+	if err != nil {
+		return nil, err
+	}
+	if regional != c {
+		return regional.ListRedisEntries(iw)
+	}
+
+	whitelist, err := c.getRedisWhitelist(iw)
+	if err != nil {
+		return nil, err
+	}
+	return parseEntries(whitelist), nil
+}
+
+// ListCLBEntries lists the entries of a CLB access control list
+func (c *Client) ListCLBEntries(lbw config.LoadBalancerWhitelist) ([]Entry, error) {
+	regional, err := c.Region(lbw.RegionID)
+	if err != nil {
+		return nil, err
+	}
+	if regional != c {
+		return regional.ListCLBEntries(lbw)
+	}
+
+	request := slb.CreateDescribeAccessControlListAttributeRequest()
+	request.Scheme = "https"
+	request.AclId = lbw.AclID
+
+	response, err := c.clbClient.DescribeAccessControlListAttribute(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, aclEntry := range response.AclEntrys.AclEntry {
+		cidr, err := ipset.Normalize(aclEntry.AclEntryIP)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, Entry{CIDR: cidr, Description: aclEntry.AclEntryComment})
+	}
+	return entries, nil
+}
+
+// parseEntries parses a comma-separated RDS or Redis whitelist
+func parseEntries(whitelist string) []Entry {
+	var entries []Entry
+	for _, item := range strings.Split(whitelist, ",") {
+		cidr, err := ipset.Normalize(item)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, Entry{CIDR: cidr})
+	}
+	return entries
+}
+
+// CIDRs returns the CIDRs of the entries
+func CIDRs(entries []Entry) []string {
+	var cidrs []string
+	for _, entry := range entries {
+		cidrs = append(cidrs, entry.CIDR)
+	}
+	return cidrs
+}
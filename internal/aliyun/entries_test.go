@@ -0,0 +1,77 @@
+package aliyun
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDescriptionOwner(t *testing.T) {
+	description := Description("agent-1/prod/ecs/sg-test:22", maxECSDescriptionLen)
+	if description != "Auto added by cloud-whitelist-manager owner=agent-1/prod/ecs/sg-test:22" {
+		t.Errorf("Unexpected description: %s", description)
+	}
+
+	owner, managed := ParseOwner(description)
+	if !managed || owner != "agent-1/prod/ecs/sg-test:22" {
+		t.Errorf("Expected managed entry owned by agent-1/prod/ecs/sg-test:22, got %q (managed: %v)", owner, managed)
+	}
+	if !OwnedBy(owner, "agent-1") {
+		t.Error("Expected entry to be owned by agent-1")
+	}
+	if OwnedBy(owner, "agent") {
+		t.Error("Expected entry not to be owned by agent")
+	}
+
+	// Legacy entries are managed but have no owner
+	owner, managed = ParseOwner("Auto added by cloud-whitelist-manager")
+	if !managed || owner != "" {
+		t.Errorf("Expected managed entry without owner, got %q (managed: %v)", owner, managed)
+	}
+
+	// Hand-added entries are not managed
+	if _, managed := ParseOwner("office VPN"); managed {
+		t.Error("Expected hand-added entry not to be managed")
+	}
+
+	// Truncated comments keep the agent
+	comment := Description("agent-1/"+strings.Repeat("x", 200), maxCLBCommentLen)
+	if len(comment) != maxCLBCommentLen {
+		t.Errorf("Expected comment of %d characters, got %d", maxCLBCommentLen, len(comment))
+	}
+	if owner, _ := ParseOwner(comment); !OwnedBy(owner, "agent-1") {
+		t.Error("Expected truncated comment to be owned by agent-1")
+	}
+	if !Owns("agent-1/"+strings.Repeat("x", 200), Entry{Description: comment}) {
+		t.Error("Expected truncated comment to match its owner ID")
+	}
+}
+
+func TestOwns(t *testing.T) {
+	entry := Entry{Description: Description("agent-1/prod/ecs/sg-test:2", maxECSDescriptionLen)}
+	if !Owns("agent-1/prod/ecs/sg-test:2", entry) {
+		t.Error("Expected entry to be owned by agent-1/prod/ecs/sg-test:2")
+	}
+	// An owner ID of which the description holds a prefix is another owner
+	if Owns("agent-1/prod/ecs/sg-test:22", entry) {
+		t.Error("Expected entry not to be owned by agent-1/prod/ecs/sg-test:22")
+	}
+	if Owns("agent-1/prod/ecs/sg-test:2", Entry{Description: "office VPN"}) {
+		t.Error("Expected hand-added entry not to be owned")
+	}
+}
+
+func TestParseEntries(t *testing.T) {
+	entries := parseEntries("127.0.0.1,192.168.1.1, 10.0.0.0/8,%")
+	cidrs := CIDRs(entries)
+	expected := []string{"127.0.0.1/32", "192.168.1.1/32", "10.0.0.0/8"}
+	if strings.Join(cidrs, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, cidrs)
+	}
+}
+
+func TestWhitelistEntries(t *testing.T) {
+	result := whitelistEntries([]string{"192.168.1.1/32", "10.0.0.0/8", "2001:db8::1/128"})
+	if result != "192.168.1.1,10.0.0.0/8,2001:db8::1" {
+		t.Errorf("Unexpected whitelist entries: %s", result)
+	}
+}
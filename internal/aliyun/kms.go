@@ -0,0 +1,123 @@
+package aliyun
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/kms"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+// KMSKeyProvider is the envelope scheme of values encrypted with Aliyun KMS
+const KMSKeyProvider = "kms"
+
+// Environment variables configuring the KMS key provider
+const (
+	envRegionID = "ALIBABA_CLOUD_REGION_ID"
+	envKMSKeyID = "CWM_KMS_KEY_ID"
+)
+
+// kmsAPI is the part of the KMS client used to encrypt configuration values
+type kmsAPI interface {
+	Encrypt(request *kms.EncryptRequest) (*kms.EncryptResponse, error)
+	Decrypt(request *kms.DecryptRequest) (*kms.DecryptResponse, error)
+}
+
+// KMSProvider encrypts and decrypts configuration values with Aliyun KMS. It
+// runs before the configuration is loaded, so its region and credentials come
+// from the environment: an access key when set, else the ACK OIDC token, else
+// the ECS instance RAM role. The client is created on first use.
+type KMSProvider struct {
+	KeyID string // key used to encrypt, defaults to CWM_KMS_KEY_ID
+
+	once   sync.Once
+	client kmsAPI
+	err    error
+}
+
+// NewKMSProvider creates a KMS key provider
+func NewKMSProvider() *KMSProvider {
+	return &KMSProvider{KeyID: os.Getenv(envKMSKeyID)}
+}
+
+// Encrypt encrypts a value with the KMS key, returning the ciphertext blob
+func (p *KMSProvider) Encrypt(plaintext string) (string, error) {
+	if p.KeyID == "" {
+		return "", fmt.Errorf("a KMS key ID is required, set %s", envKMSKeyID)
+	}
+	client, err := p.getClient()
+	if err != nil {
+		return "", err
+	}
+
+	request := kms.CreateEncryptRequest()
+	request.Scheme = "https"
+	request.KeyId = p.KeyID
+	request.Plaintext = plaintext
+
+	response, err := client.Encrypt(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt with KMS: %v", err)
+	}
+	return response.CiphertextBlob, nil
+}
+
+// Decrypt decrypts a ciphertext produced by Encrypt
+func (p *KMSProvider) Decrypt(ciphertext string) (string, error) {
+	client, err := p.getClient()
+	if err != nil {
+		return "", err
+	}
+
+	request := kms.CreateDecryptRequest()
+	request.Scheme = "https"
+	request.CiphertextBlob = ciphertext
+
+	response, err := client.Decrypt(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt with KMS: %v", err)
+	}
+	return response.Plaintext, nil
+}
+
+// getClient creates the KMS client on first use
+func (p *KMSProvider) getClient() (kmsAPI, error) {
+	p.once.Do(func() {
+		if p.client != nil {
+			return
+		}
+		regionID := os.Getenv(envRegionID)
+		if regionID == "" {
+			p.err = fmt.Errorf("%s must be set to use KMS", envRegionID)
+			return
+		}
+
+		credential, err := resolveCredentials(kmsCredentials(), regionID)
+		if err != nil {
+			p.err = fmt.Errorf("failed to create KMS credentials: %v", err)
+			return
+		}
+		client, err := kms.NewClientWithOptions(regionID, sdk.NewConfig(), credential.sdkCredential())
+		if err != nil {
+			p.err = fmt.Errorf("failed to create KMS client: %v", err)
+			return
+		}
+		credential.apply(&client.Client)
+		p.client = client
+	})
+	return p.client, p.err
+}
+
+// kmsCredentials picks the credentials of the KMS client from the environment
+func kmsCredentials() *config.Credentials {
+	switch {
+	case os.Getenv(envAccessKeyID) != "":
+		return &config.Credentials{Type: config.CredentialEnv}
+	case os.Getenv(envOIDCTokenFile) != "":
+		return &config.Credentials{Type: config.CredentialOIDC}
+	default:
+		return &config.Credentials{Type: config.CredentialECSRamRole}
+	}
+}
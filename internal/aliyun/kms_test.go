@@ -0,0 +1,50 @@
+package aliyun
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/kms"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+// fakeKMS "encrypts" by reversing the plaintext
+type fakeKMS struct{}
+
+func (fakeKMS) Encrypt(request *kms.EncryptRequest) (*kms.EncryptResponse, error) {
+	return &kms.EncryptResponse{KeyId: request.KeyId, CiphertextBlob: reverse(request.Plaintext)}, nil
+}
+
+func (fakeKMS) Decrypt(request *kms.DecryptRequest) (*kms.DecryptResponse, error) {
+	return &kms.DecryptResponse{Plaintext: reverse(request.CiphertextBlob)}, nil
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+func TestKMSProvider(t *testing.T) {
+	provider := &KMSProvider{client: fakeKMS{}}
+	if _, err := provider.Encrypt("abc"); err == nil {
+		t.Error("Expected error without a key ID")
+	}
+
+	provider.KeyID = "key-test"
+	config.RegisterKeyProvider(KMSKeyProvider, provider)
+	envelope, err := config.EncryptValue(KMSKeyProvider, "secret")
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if envelope != "ENC[kms:terces]" {
+		t.Errorf("Expected ENC[kms:terces], got %s", envelope)
+	}
+
+	plaintext, err := provider.Decrypt(strings.TrimSuffix(strings.TrimPrefix(envelope, "ENC[kms:"), "]"))
+	if err != nil || plaintext != "secret" {
+		t.Errorf("Expected secret, got %s, %v", plaintext, err)
+	}
+}
@@ -0,0 +1,169 @@
+package aliyun
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+// Policy is a RAM policy document
+type Policy struct {
+	Version   string      `json:"Version"`
+	Statement []Statement `json:"Statement"`
+}
+
+// Statement is a statement of a RAM policy
+type Statement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// Actions called for each kind of resource, reading the current entries
+// first and then adding and removing entries
+var (
+	ingressActions    = []string{"ecs:DescribeSecurityGroupAttribute", "ecs:AuthorizeSecurityGroup", "ecs:RevokeSecurityGroup"}
+	egressActions     = []string{"ecs:DescribeSecurityGroupAttribute", "ecs:AuthorizeSecurityGroupEgress", "ecs:RevokeSecurityGroupEgress"}
+	prefixListActions = []string{"ecs:DescribePrefixListAttributes", "ecs:ModifyPrefixList"}
+	rdsActions        = []string{"rds:DescribeDBInstanceIPArrayList", "rds:ModifySecurityIps"}
+	redisActions      = []string{"kvstore:DescribeSecurityIps", "kvstore:ModifySecurityIps"}
+	clbActions        = []string{"slb:DescribeAccessControlListAttribute", "slb:AddAccessControlListEntry", "slb:RemoveAccessControlListEntry"}
+)
+
+// NewPolicy returns the least-privilege RAM policy for an account: only the
+// actions used for its targets, scoped to the ARNs of the configured
+// resources. Resources discovered by selectors are not known in advance, so
+// selectors grant the actions on every resource of that kind in their
+// region. Use "*" as account ID to match any account.
+func NewPolicy(cfg *config.Aliyun, accountID string) *Policy {
+	p := &policyBuilder{cfg: cfg, accountID: accountID, actions: make(map[string][]string)}
+
+	if cfg.ECS.Enabled {
+		for _, sg := range cfg.ECS.SecurityGroupIDs {
+			p.allow(p.arn("ecs", sg.RegionID, "securitygroup", sg.SecurityGroupID), securityGroupActions(sg)...)
+		}
+		for _, selector := range cfg.ECS.Selectors {
+			p.allow(p.arn("ecs", selector.RegionID, "securitygroup", "*"), "ecs:DescribeSecurityGroups")
+			p.allow(p.arn("ecs", selector.RegionID, "securitygroup", "*"), securityGroupActions(selector.SecurityGroup)...)
+		}
+	}
+	if cfg.PrefixList.Enabled {
+		for _, pl := range cfg.PrefixList.PrefixLists {
+			p.allow(p.arn("ecs", pl.RegionID, "prefixlist", pl.PrefixListID), prefixListActions...)
+		}
+	}
+	if cfg.RDS.Enabled {
+		for _, iw := range cfg.RDS.InstanceWhitelists {
+			p.allow(p.arn("rds", iw.RegionID, "dbinstance", iw.InstanceID), rdsActions...)
+		}
+		for _, selector := range cfg.RDS.Selectors {
+			p.allow(p.arn("rds", selector.RegionID, "dbinstance", "*"), "rds:DescribeDBInstances")
+			p.allow(p.arn("rds", selector.RegionID, "dbinstance", "*"), rdsActions...)
+		}
+	}
+	if cfg.Redis.Enabled {
+		for _, iw := range cfg.Redis.InstanceWhitelists {
+			p.allow(p.arn("kvstore", iw.RegionID, "instance", iw.InstanceID), redisActions...)
+		}
+		for _, selector := range cfg.Redis.Selectors {
+			p.allow(p.arn("kvstore", selector.RegionID, "instance", "*"), "kvstore:DescribeInstances")
+			p.allow(p.arn("kvstore", selector.RegionID, "instance", "*"), redisActions...)
+		}
+	}
+	if cfg.CLB.Enabled {
+		for _, lbw := range cfg.CLB.LoadBalancerWhitelists {
+			p.allow(p.arn("slb", lbw.RegionID, "acl", lbw.AclID), clbActions...)
+		}
+		for _, selector := range cfg.CLB.Selectors {
+			p.allow(p.arn("slb", selector.RegionID, "acl", "*"), "slb:DescribeAccessControlLists")
+			p.allow(p.arn("slb", selector.RegionID, "acl", "*"), clbActions...)
+		}
+	}
+
+	return p.policy()
+}
+
+// securityGroupActions returns the actions used for a security group rule
+func securityGroupActions(sg config.SecurityGroup) []string {
+	if sg.GetDirection() == "egress" {
+		return egressActions
+	}
+	return ingressActions
+}
+
+// WriteActions returns the actions that change the entries of a target of
+// the given kind, and for ECS rules direction
+func WriteActions(kind, direction string) []string {
+	var actions []string
+	switch kind {
+	case "ecs":
+		actions = securityGroupActions(config.SecurityGroup{Direction: direction})
+	case "prefix_list":
+		actions = prefixListActions
+	case "rds":
+		actions = rdsActions
+	case "redis":
+		actions = redisActions
+	case "clb":
+		actions = clbActions
+	}
+
+	var writes []string
+	for _, action := range actions {
+		if _, name, _ := strings.Cut(action, ":"); !strings.HasPrefix(name, "Describe") {
+			writes = append(writes, action)
+		}
+	}
+	return writes
+}
+
+// policyBuilder collects the actions allowed on each resource
+type policyBuilder struct {
+	cfg       *config.Aliyun
+	accountID string
+	actions   map[string][]string // actions by resource ARN
+}
+
+// arn returns the ARN of a resource, in the region of the account unless
+// another region is given
+func (p *policyBuilder) arn(service, regionID, resourceType, id string) string {
+	if regionID == "" {
+		regionID = p.cfg.RegionID
+	}
+	return fmt.Sprintf("acs:%s:%s:%s:%s/%s", service, regionID, p.accountID, resourceType, id)
+}
+
+// allow grants actions on a resource
+func (p *policyBuilder) allow(resource string, actions ...string) {
+	for _, action := range actions {
+		if !slices.Contains(p.actions[resource], action) {
+			p.actions[resource] = append(p.actions[resource], action)
+		}
+	}
+}
+
+// policy groups the resources sharing the same actions into statements
+func (p *policyBuilder) policy() *Policy {
+	resources := make([]string, 0, len(p.actions))
+	for resource := range p.actions {
+		resources = append(resources, resource)
+	}
+	slices.Sort(resources)
+
+	policy := &Policy{Version: "1", Statement: []Statement{}}
+	statements := make(map[string]int)
+	for _, resource := range resources {
+		actions := slices.Clone(p.actions[resource])
+		slices.Sort(actions)
+		key := strings.Join(actions, ",")
+		if i, ok := statements[key]; ok {
+			policy.Statement[i].Resource = append(policy.Statement[i].Resource, resource)
+			continue
+		}
+		statements[key] = len(policy.Statement)
+		policy.Statement = append(policy.Statement, Statement{Effect: "Allow", Action: actions, Resource: []string{resource}})
+	}
+	return policy
+}
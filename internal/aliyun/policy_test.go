@@ -0,0 +1,65 @@
+package aliyun
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+func TestNewPolicy(t *testing.T) {
+	cfg := &config.Aliyun{
+		RegionID: "cn-hangzhou",
+		ECS: config.ECS{
+			Enabled: true,
+			SecurityGroupIDs: []config.SecurityGroup{
+				{SecurityGroupID: "sg-1", Port: "22"},
+				{SecurityGroupID: "sg-1", Port: "443"},
+				{SecurityGroupID: "sg-2", Port: "443", Direction: "egress", RegionID: "cn-shanghai"},
+			},
+		},
+		RDS: config.RDS{
+			Enabled:            true,
+			InstanceWhitelists: []config.InstanceWhitelist{{InstanceID: "rm-1", WhitelistName: "default"}},
+		},
+		Redis: config.Redis{
+			// Disabled products are not granted
+			InstanceWhitelists: []config.InstanceWhitelist{{InstanceID: "r-1", WhitelistName: "default"}},
+		},
+		CLB: config.CLB{
+			Enabled:   true,
+			Selectors: []config.LoadBalancerWhitelistSelector{{Selector: config.Selector{Tags: map[string]string{"team": "ops"}}}},
+		},
+	}
+
+	expected := &Policy{
+		Version: "1",
+		Statement: []Statement{
+			{
+				Effect:   "Allow",
+				Action:   []string{"ecs:AuthorizeSecurityGroup", "ecs:DescribeSecurityGroupAttribute", "ecs:RevokeSecurityGroup"},
+				Resource: []string{"acs:ecs:cn-hangzhou:123:securitygroup/sg-1"},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"ecs:AuthorizeSecurityGroupEgress", "ecs:DescribeSecurityGroupAttribute", "ecs:RevokeSecurityGroupEgress"},
+				Resource: []string{"acs:ecs:cn-shanghai:123:securitygroup/sg-2"},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"rds:DescribeDBInstanceIPArrayList", "rds:ModifySecurityIps"},
+				Resource: []string{"acs:rds:cn-hangzhou:123:dbinstance/rm-1"},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"slb:AddAccessControlListEntry", "slb:DescribeAccessControlListAttribute", "slb:DescribeAccessControlLists", "slb:RemoveAccessControlListEntry"},
+				Resource: []string{"acs:slb:cn-hangzhou:123:acl/*"},
+			},
+		},
+	}
+
+	policy := NewPolicy(cfg, "123")
+	if !reflect.DeepEqual(policy, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, policy)
+	}
+}
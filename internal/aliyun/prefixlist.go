@@ -0,0 +1,133 @@
+package aliyun
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/ipset"
+)
+
+// maxPrefixListDescriptionLen is the description length limit of prefix
+// list entries
+const maxPrefixListDescriptionLen = 256
+
+// prefixList is the current content of a prefix list
+type prefixList struct {
+	ipv6       bool
+	maxEntries int
+	entries    []Entry
+}
+
+// SyncPrefixList removes and adds the given CIDR entries in a VPC prefix
+// list, tagging added entries with the owner ID. Entries of the other address
+// family are skipped, and changes that would exceed the maximum number of
+// entries are rejected.
+func (c *Client) SyncPrefixList(pl config.ManagedPrefixList, owner string, add, remove []string) error {
+	regional, err := c.Region(pl.RegionID)
+	if err != nil {
+		return err
+	}
+	if regional != c {
+		return regional.SyncPrefixList(pl, owner, add, remove)
+	}
+
+	current, err := c.getPrefixList(pl)
+	if err != nil {
+		return fmt.Errorf("failed to get prefix list %s: %v", pl.PrefixListID, err)
+	}
+
+	add = filterFamily(add, current.ipv6)
+	remove = filterFamily(remove, current.ipv6)
+	add, remove = filterWhitelistChanges(strings.Join(CIDRs(current.entries), ","), add, remove)
+	if len(add) == 0 && len(remove) == 0 {
+		return nil
+	}
+
+	maxEntries := current.maxEntries
+	if pl.MaxEntries > 0 && (maxEntries == 0 || pl.MaxEntries < maxEntries) {
+		maxEntries = pl.MaxEntries
+	}
+	if total := len(current.entries) + len(add) - len(remove); maxEntries > 0 && total > maxEntries {
+		return fmt.Errorf("prefix list %s would have %d entries, exceeding the maximum of %d", pl.PrefixListID, total, maxEntries)
+	}
+
+	request := ecs.CreateModifyPrefixListRequest()
+	request.Scheme = "https"
+	request.PrefixListId = pl.PrefixListID
+	if len(add) > 0 {
+		description := Description(owner, maxPrefixListDescriptionLen)
+		var entries []ecs.ModifyPrefixListAddEntry
+		for _, cidr := range add {
+			entries = append(entries, ecs.ModifyPrefixListAddEntry{Cidr: cidr, Description: description})
+		}
+		request.AddEntry = &entries
+	}
+	if len(remove) > 0 {
+		var entries []ecs.ModifyPrefixListRemoveEntry
+		for _, cidr := range remove {
+			entries = append(entries, ecs.ModifyPrefixListRemoveEntry{Cidr: cidr})
+		}
+		request.RemoveEntry = &entries
+	}
+
+	_, err = c.ecsClient.ModifyPrefixList(request)
+	if err != nil {
+		return fmt.Errorf("failed to modify prefix list %s: %v", pl.PrefixListID, err)
+	}
+	return nil
+}
+
+// ListPrefixListEntries lists the entries of a VPC prefix list
+func (c *Client) ListPrefixListEntries(pl config.ManagedPrefixList) ([]Entry, error) {
+	regional, err := c.Region(pl.RegionID)
+	if err != nil {
+		return nil, err
+	}
+	if regional != c {
+		return regional.ListPrefixListEntries(pl)
+	}
+
+	current, err := c.getPrefixList(pl)
+	if err != nil {
+		return nil, err
+	}
+	return current.entries, nil
+}
+
+// getPrefixList gets the address family, size limit and entries of a prefix list
+func (c *Client) getPrefixList(pl config.ManagedPrefixList) (*prefixList, error) {
+	request := ecs.CreateDescribePrefixListAttributesRequest()
+	request.Scheme = "https"
+	request.PrefixListId = pl.PrefixListID
+
+	response, err := c.ecsClient.DescribePrefixListAttributes(request)
+	if err != nil {
+		return nil, err
+	}
+
+	current := &prefixList{
+		ipv6:       response.AddressFamily == "IPv6",
+		maxEntries: response.MaxEntries,
+	}
+	for _, entry := range response.Entries.Entry {
+		cidr, err := ipset.Normalize(entry.Cidr)
+		if err != nil {
+			continue
+		}
+		current.entries = append(current.entries, Entry{CIDR: cidr, Description: entry.Description})
+	}
+	return current, nil
+}
+
+// filterFamily returns the CIDRs of the given address family
+func filterFamily(cidrs []string, ipv6 bool) []string {
+	var filtered []string
+	for _, cidr := range cidrs {
+		if ipset.IsIPv6(cidr) == ipv6 {
+			filtered = append(filtered, cidr)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,17 @@
+package aliyun
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterFamily(t *testing.T) {
+	cidrs := []string{"192.168.1.1/32", "2001:db8::/32", "10.0.0.0/8"}
+
+	if filtered := filterFamily(cidrs, false); !reflect.DeepEqual(filtered, []string{"192.168.1.1/32", "10.0.0.0/8"}) {
+		t.Errorf("Expected IPv4 entries, got %v", filtered)
+	}
+	if filtered := filterFamily(cidrs, true); !reflect.DeepEqual(filtered, []string{"2001:db8::/32"}) {
+		t.Errorf("Expected IPv6 entries, got %v", filtered)
+	}
+}
@@ -2,7 +2,10 @@ package config
 
 import (
 	"fmt"
-	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -12,10 +15,22 @@ import (
 type Config struct {
 	Interval  int       `yaml:"interval"`
 	IPSource  IPSource  `yaml:"ip_source"`
+	IPSets    []IPSet   `yaml:"ip_sets"`
+	StateFile string    `yaml:"state_file"` // path of the persistent state file
+	AgentName string    `yaml:"agent_name"` // name of this instance, defaults to the host name
+	GC        GC        `yaml:"gc"`
 	Accounts  []Account `yaml:"accounts"`
-	Aliyun    Aliyun    `yaml:"aliyun"` // For backward compatibility
+	Aliyun    *Aliyun   `yaml:"aliyun,omitempty"` // legacy single account, normalized into Accounts when loaded
+
+	legacy  bool              // whether Accounts was normalized from the legacy aliyun block
+	secrets []string          // values read from file references or decrypted, redacted when printed
+	origins map[string]origin // files defining the top-level values, when merged from several
 }
 
+// LegacyAccountName is the name of the account normalized from the legacy
+// aliyun block, part of the keys of the entries it manages
+const LegacyAccountName = "account"
+
 // IPSource represents IP source configuration
 type IPSource struct {
 	Type      string            `yaml:"type"`      // http, command, interface
@@ -27,76 +42,248 @@ type IPSource struct {
 	IPv6      bool              `yaml:"ipv6"`      // for interface type
 }
 
+// IPSet represents a named set of addresses composed from multiple inputs
+type IPSet struct {
+	Name        string       `yaml:"name"`
+	Detected    bool         `yaml:"detected"`     // include the IP detected via ip_source
+	CIDRs       []string     `yaml:"cidrs"`        // static IPs or CIDR blocks
+	DNSNames    []string     `yaml:"dns_names"`    // host names resolved on every check
+	RemoteLists []RemoteList `yaml:"remote_lists"` // remote lists of IP ranges
+}
+
+// RemoteList represents a published list of IP ranges, such as GitHub meta
+// or Cloudflare ranges
+type RemoteList struct {
+	URL         string            `yaml:"url"`
+	Timeout     int               `yaml:"timeout"`      // timeout in seconds, defaults to DefaultRemoteListTimeout
+	Headers     map[string]string `yaml:"headers"`      // custom request headers
+	Format      string            `yaml:"format"`       // lines (default) or json
+	JSONPath    string            `yaml:"json_path"`    // for json format, e.g. "result.ipv4_cidrs"
+	LinePattern string            `yaml:"line_pattern"` // for lines format, regexp extracting the entry
+	Refresh     int               `yaml:"refresh"`      // refresh interval in seconds, 0 fetches on every check
+	MaxEntries  int               `yaml:"max_entries"`  // sanity limit, defaults to DefaultRemoteListMaxEntries
+}
+
+// DefaultRemoteListMaxEntries is the default sanity limit for remote lists
+const DefaultRemoteListMaxEntries = 1000
+
+// DefaultRemoteListTimeout is the default timeout of remote list requests in
+// seconds
+const DefaultRemoteListTimeout = 30
+
+// GC represents garbage collection of orphaned managed ECS rules
+type GC struct {
+	Enabled bool `yaml:"enabled"`
+	MinAge  int  `yaml:"min_age"` // minimum rule age in seconds, defaults to DefaultGCMinAge
+	DryRun  bool `yaml:"dry_run"` // only report orphaned rules
+}
+
+// DefaultGCMinAge is the default minimum age of a rule before it is collected
+const DefaultGCMinAge = 3600
+
 // Aliyun represents Aliyun configuration
 type Aliyun struct {
-	AccessKeyID     string `yaml:"access_key_id"`
-	AccessKeySecret string `yaml:"access_key_secret"`
-	RegionID        string `yaml:"region_id"`
-	ECS             ECS    `yaml:"ecs"`
-	RDS             RDS    `yaml:"rds"`
-	Redis           Redis  `yaml:"redis"`
-	CLB             CLB    `yaml:"clb"`
+	AccessKeyID     string       `yaml:"access_key_id"`
+	AccessKeySecret string       `yaml:"access_key_secret"`
+	Credentials     *Credentials `yaml:"credentials"` // used instead of the access key
+	RegionID        string       `yaml:"region_id"`
+	ECS             ECS          `yaml:"ecs"`
+	RDS             RDS          `yaml:"rds"`
+	Redis           Redis        `yaml:"redis"`
+	CLB             CLB          `yaml:"clb"`
+	PrefixList      PrefixList   `yaml:"prefix_list"`
 }
 
 // Account represents an Aliyun account configuration
 type Account struct {
-	Name            string `yaml:"name"`
-	AccessKeyID     string `yaml:"access_key_id"`
-	AccessKeySecret string `yaml:"access_key_secret"`
-	RegionID        string `yaml:"region_id"`
-	ECS             ECS    `yaml:"ecs"`
-	RDS             RDS    `yaml:"rds"`
-	Redis           Redis  `yaml:"redis"`
-	CLB             CLB    `yaml:"clb"`
+	Name            string       `yaml:"name"`
+	AccessKeyID     string       `yaml:"access_key_id"`
+	AccessKeySecret string       `yaml:"access_key_secret"`
+	Credentials     *Credentials `yaml:"credentials"` // used instead of the access key
+	RegionID        string       `yaml:"region_id"`
+	ECS             ECS          `yaml:"ecs"`
+	RDS             RDS          `yaml:"rds"`
+	Redis           Redis        `yaml:"redis"`
+	CLB             CLB          `yaml:"clb"`
+	PrefixList      PrefixList   `yaml:"prefix_list"`
+}
+
+// Credential types
+const (
+	CredentialAccessKey  = "access_key"
+	CredentialEnv        = "env"
+	CredentialProfile    = "profile"
+	CredentialECSRamRole = "ecs_ram_role"
+	CredentialAssumeRole = "assume_role"
+	CredentialOIDC       = "oidc"
+)
+
+// Credentials represents how an account authenticates to Aliyun
+type Credentials struct {
+	Type            string       `yaml:"type"`
+	AccessKeyID     string       `yaml:"access_key_id"`     // for access_key
+	AccessKeySecret string       `yaml:"access_key_secret"` // for access_key
+	Profile         string       `yaml:"profile"`           // for profile, defaults to the current profile
+	ProfileFile     string       `yaml:"profile_file"`      // for profile, defaults to ~/.aliyun/config.json
+	RoleName        string       `yaml:"role_name"`         // for ecs_ram_role, defaults to the role of the instance
+	RoleArn         string       `yaml:"role_arn"`          // for assume_role and oidc
+	RoleSessionName string       `yaml:"role_session_name"` // for assume_role and oidc
+	ExternalID      string       `yaml:"external_id"`       // for assume_role
+	DurationSeconds int          `yaml:"duration_seconds"`  // for assume_role and oidc
+	Source          *Credentials `yaml:"source"`            // for assume_role, credentials used to assume the role, defaults to env
+	OIDCProviderArn string       `yaml:"oidc_provider_arn"` // for oidc
+	OIDCTokenFile   string       `yaml:"oidc_token_file"`   // for oidc
+}
+
+// DefaultRoleSessionName is the default session name of assumed roles
+const DefaultRoleSessionName = "cloud-whitelist-manager"
+
+// DefaultRoleSessionDuration is the default duration of assumed role sessions
+// in seconds
+const DefaultRoleSessionDuration = 3600
+
+// GetRoleSessionName returns the session name of assumed roles
+func (c *Credentials) GetRoleSessionName() string {
+	if c.RoleSessionName != "" {
+		return c.RoleSessionName
+	}
+	return DefaultRoleSessionName
+}
+
+// GetDurationSeconds returns the duration of assumed role sessions
+func (c *Credentials) GetDurationSeconds() int {
+	if c.DurationSeconds > 0 {
+		return c.DurationSeconds
+	}
+	return DefaultRoleSessionDuration
 }
 
 // ECS represents ECS security group configuration
 type ECS struct {
-	Enabled          bool          `yaml:"enabled"`
-	SecurityGroupIDs []SecurityGroup `yaml:"security_groups"`
+	Enabled          bool                    `yaml:"enabled"`
+	SecurityGroupIDs []SecurityGroup         `yaml:"security_groups"`
+	Selectors        []SecurityGroupSelector `yaml:"selectors"` // security groups discovered by tags
 }
 
 // SecurityGroup represents a single ECS security group configuration
 type SecurityGroup struct {
-	SecurityGroupID string `yaml:"security_group_id"`
-	Port            string `yaml:"port"`  // Support port range like "22", "80/80", "-1/-1", "1/65535"
-	Priority        int    `yaml:"priority"`
+	SecurityGroupID string   `yaml:"security_group_id"`
+	RegionID        string   `yaml:"region_id"` // defaults to the region of the account
+	Port            string   `yaml:"port"`      // Support port range like "22", "80/80", "-1/-1", "1/65535"
+	Ports           []string `yaml:"ports"`     // multiple ports or port ranges, used instead of port
+	Protocol        string   `yaml:"protocol"`  // tcp (default), udp, icmp, icmpv6, gre or all
+	Policy          string   `yaml:"policy"`    // accept (default) or drop
+	NicType         string   `yaml:"nic_type"`  // intranet (default) or internet
+	Direction       string   `yaml:"direction"` // ingress (default) or egress
+	Priority        int      `yaml:"priority"`
+	IPSets          []string `yaml:"ip_sets"`      // IP sets to allow, defaults to the detected IP
+	StaticCIDRs     []string `yaml:"static_cidrs"` // entries that must always be present
 }
 
 // RDS represents RDS whitelist configuration
 type RDS struct {
-	Enabled        bool         `yaml:"enabled"`
-	InstanceWhitelists []InstanceWhitelist `yaml:"instance_whitelists"`
+	Enabled            bool                        `yaml:"enabled"`
+	InstanceWhitelists []InstanceWhitelist         `yaml:"instance_whitelists"`
+	Selectors          []InstanceWhitelistSelector `yaml:"selectors"` // instances discovered by tags
 }
 
 // InstanceWhitelist represents a single RDS instance whitelist configuration
 type InstanceWhitelist struct {
-	InstanceID    string `yaml:"instance_id"`
-	WhitelistName string `yaml:"whitelist_name"`
+	InstanceID    string   `yaml:"instance_id"`
+	WhitelistName string   `yaml:"whitelist_name"`
+	RegionID      string   `yaml:"region_id"`    // defaults to the region of the account
+	Dedicated     bool     `yaml:"dedicated"`    // use a group owned by this agent instead of whitelist_name
+	IPSets        []string `yaml:"ip_sets"`      // IP sets to allow, defaults to the detected IP
+	StaticCIDRs   []string `yaml:"static_cidrs"` // entries that must always be present
 }
 
 // Redis represents Redis whitelist configuration
 type Redis struct {
-	Enabled             bool                `yaml:"enabled"`
-	InstanceWhitelists  []InstanceWhitelist `yaml:"instance_whitelists"`
+	Enabled            bool                        `yaml:"enabled"`
+	InstanceWhitelists []InstanceWhitelist         `yaml:"instance_whitelists"`
+	Selectors          []InstanceWhitelistSelector `yaml:"selectors"` // instances discovered by tags
 }
 
 // CLB represents CLB whitelist configuration
 type CLB struct {
-	Enabled              bool                `yaml:"enabled"`
-	LoadBalancerWhitelists []LoadBalancerWhitelist `yaml:"load_balancer_whitelists"`
+	Enabled                bool                            `yaml:"enabled"`
+	LoadBalancerWhitelists []LoadBalancerWhitelist         `yaml:"load_balancer_whitelists"`
+	Selectors              []LoadBalancerWhitelistSelector `yaml:"selectors"` // ACLs discovered by tags
 }
 
 // LoadBalancerWhitelist represents a single CLB whitelist configuration
 type LoadBalancerWhitelist struct {
-	AclID string `yaml:"acl_id"`  // ACL ID
+	AclID       string   `yaml:"acl_id"`       // ACL ID
+	RegionID    string   `yaml:"region_id"`    // defaults to the region of the account
+	IPSets      []string `yaml:"ip_sets"`      // IP sets to allow, defaults to the detected IP
+	StaticCIDRs []string `yaml:"static_cidrs"` // entries that must always be present
 }
 
-// LoadConfig loads configuration from file
+// Selector selects resources by tags and resource group. Resources must
+// carry every tag and belong to the resource group if one is given.
+type Selector struct {
+	Tags            map[string]string `yaml:"tags"`
+	ResourceGroupID string            `yaml:"resource_group_id"`
+}
+
+// SecurityGroupSelector applies a security group rule to every security
+// group matching the selector
+type SecurityGroupSelector struct {
+	Selector      `yaml:",inline"`
+	SecurityGroup `yaml:",inline"` // security_group_id is filled in for each match
+}
+
+// InstanceWhitelistSelector applies an instance whitelist to every RDS or
+// Redis instance matching the selector
+type InstanceWhitelistSelector struct {
+	Selector          `yaml:",inline"`
+	InstanceWhitelist `yaml:",inline"` // instance_id is filled in for each match
+}
+
+// LoadBalancerWhitelistSelector applies a CLB whitelist to every access
+// control list matching the selector
+type LoadBalancerWhitelistSelector struct {
+	Selector              `yaml:",inline"`
+	LoadBalancerWhitelist `yaml:",inline"` // acl_id is filled in for each match
+}
+
+// PrefixList represents ECS prefix list configuration
+type PrefixList struct {
+	Enabled     bool                `yaml:"enabled"`
+	PrefixLists []ManagedPrefixList `yaml:"prefix_lists"`
+}
+
+// ManagedPrefixList represents a single VPC prefix list referenced by
+// security groups
+type ManagedPrefixList struct {
+	PrefixListID string   `yaml:"prefix_list_id"`
+	RegionID     string   `yaml:"region_id"`    // defaults to the region of the account
+	MaxEntries   int      `yaml:"max_entries"`  // optional limit below the maximum of the prefix list
+	IPSets       []string `yaml:"ip_sets"`      // IP sets to allow, defaults to the detected IP
+	StaticCIDRs  []string `yaml:"static_cidrs"` // entries that must always be present
+}
+
+// LoadConfig loads configuration from a file, or from the YAML files of a
+// directory, along with the files they include. CWM_ environment variables
+// override the values of the files, or make up the whole configuration when
+// the path does not exist.
 func LoadConfig(path string) (*Config, error) {
-	data, err := ioutil.ReadFile(path)
+	doc, err := loadDocument(path, os.Environ())
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %v", err)
+		return nil, err
+	}
+	if len(doc.conflicts) > 0 {
+		var messages []string
+		for _, conflict := range doc.conflicts {
+			messages = append(messages, conflict.Error())
+		}
+		return nil, fmt.Errorf("conflicting config files: %s", strings.Join(messages, "; "))
+	}
+
+	// Merge the templates into the mappings referencing them
+	data, err := expandTemplates(doc.data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand templates: %v", err)
 	}
 
 	var config Config
@@ -104,190 +291,298 @@ func LoadConfig(path string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %v", err)
 	}
+	config.secrets = doc.secrets
+	config.origins = doc.origins
+	config.normalize()
 
 	return &config, nil
 }
 
-// Validate validates the configuration
-func (c *Config) Validate() error {
-	if c.Interval <= 0 {
-		return fmt.Errorf("interval must be greater than 0")
+// document is a configuration document merged from files and environment
+// variables, before templates are expanded
+type document struct {
+	fragments []fragment        // files in load order, none without a file
+	data      []byte            // merged document
+	origins   map[string]origin // files defining the top-level values, when merged from several
+	conflicts []Issue           // values defined differently in several files
+	secrets   []string          // values read from file references or decrypted
+}
+
+// loadDocument reads the files of a configuration, expanding ${ENV} and
+// ${file:PATH} references and decrypting ENC[...] values, merges them and
+// applies the configuration variables of the environment
+func loadDocument(path string, environ []string) (*document, error) {
+	env, envSecrets, err := envDocument(environ)
+	if err != nil {
+		return nil, err
 	}
 
-	// Validate IP source
-	switch c.IPSource.Type {
-	case "http":
-		if c.IPSource.URL == "" {
-			return fmt.Errorf("IP source (http): URL is required")
-		}
-	case "command":
-		if c.IPSource.Cmd == "" {
-			return fmt.Errorf("IP source (command): command is required")
+	// Without a file the environment is the whole configuration
+	if _, err := os.Stat(path); os.IsNotExist(err) && env != nil {
+		data, err := applyEnv(nil, env)
+		if err != nil {
+			return nil, err
 		}
-	case "interface":
-		if c.IPSource.Interface == "" {
-			return fmt.Errorf("IP source (interface): interface is required")
+		origins := make(map[string]origin)
+		for key := range env {
+			origins[key] = origin{file: envSource, path: key}
 		}
-	case "":
-		return fmt.Errorf("IP source type is required")
-	default:
-		return fmt.Errorf("unknown IP source type '%s'", c.IPSource.Type)
+		return &document{data: data, origins: origins, secrets: envSecrets}, nil
 	}
 
-	// Validate accounts if provided
-	if len(c.Accounts) > 0 {
-		for i, account := range c.Accounts {
-			if account.Name == "" {
-				return fmt.Errorf("account %d: name is required", i)
-			}
-			if account.AccessKeyID == "" {
-				return fmt.Errorf("account %d: access_key_id is required", i)
-			}
-			if account.AccessKeySecret == "" {
-				return fmt.Errorf("account %d: access_key_secret is required", i)
-			}
-			if account.RegionID == "" {
-				return fmt.Errorf("account %d: region_id is required", i)
-			}
+	fragments, err := loadFragments(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: fragments}
+	for _, f := range fragments {
+		doc.secrets = append(doc.secrets, f.secrets...)
+	}
+	doc.data, doc.origins, doc.conflicts, err = mergeFragments(fragments)
+	if err != nil {
+		return nil, err
+	}
 
-			// Validate ECS configuration if enabled
-			if account.ECS.Enabled {
-				if len(account.ECS.SecurityGroupIDs) == 0 {
-					return fmt.Errorf("account %d: At least one ECS security group must be configured when ECS is enabled", i)
-				}
-				for j, sg := range account.ECS.SecurityGroupIDs {
-					if sg.SecurityGroupID == "" {
-						return fmt.Errorf("account %d: ECS security group %d security_group_id is required", i, j)
-					}
-					if sg.Port == "" {
-						return fmt.Errorf("account %d: ECS security group %d port is required", i, j)
-					}
-					if sg.Priority <= 0 {
-						return fmt.Errorf("account %d: ECS security group %d priority must be greater than 0", i, j)
-					}
-				}
-			}
+	if env != nil {
+		doc.data, err = applyEnv(doc.data, env)
+		if err != nil {
+			return nil, err
+		}
+		doc.secrets = append(doc.secrets, envSecrets...)
+	}
+	return doc, nil
+}
 
-			// Validate RDS configuration if enabled
-			if account.RDS.Enabled {
-				if len(account.RDS.InstanceWhitelists) == 0 {
-					return fmt.Errorf("account %d: At least one RDS instance whitelist must be configured when RDS is enabled", i)
-				}
-				for j, iw := range account.RDS.InstanceWhitelists {
-					if iw.InstanceID == "" {
-						return fmt.Errorf("account %d: RDS instance whitelist %d instance_id is required", i, j)
-					}
-					if iw.WhitelistName == "" {
-						return fmt.Errorf("account %d: RDS instance whitelist %d whitelist_name is required", i, j)
-					}
-				}
-			}
+// normalize turns the legacy aliyun block into a single account, so that the
+// rest of the tool only deals with accounts. Both being configured is left
+// for Check to report.
+func (c *Config) normalize() {
+	if c.Aliyun == nil || len(c.Accounts) > 0 {
+		return
+	}
+	c.Accounts = []Account{c.Aliyun.Account(LegacyAccountName)}
+	c.Aliyun = nil
+	c.legacy = true
+}
 
-			// Validate Redis configuration if enabled
-			if account.Redis.Enabled {
-				if len(account.Redis.InstanceWhitelists) == 0 {
-					return fmt.Errorf("account %d: At least one Redis instance whitelist must be configured when Redis is enabled", i)
-				}
-				for j, iw := range account.Redis.InstanceWhitelists {
-					if iw.InstanceID == "" {
-						return fmt.Errorf("account %d: Redis instance whitelist %d instance_id is required", i, j)
-					}
-					if iw.WhitelistName == "" {
-						return fmt.Errorf("account %d: Redis instance whitelist %d whitelist_name is required", i, j)
-					}
-				}
-			}
+// Validate validates the configuration, returning the first issue found
+func (c *Config) Validate() error {
+	if issues := c.Check(); len(issues) > 0 {
+		return issues[0]
+	}
+	return nil
+}
 
-			// Validate CLB configuration if enabled
-			if account.CLB.Enabled {
-				if len(account.CLB.LoadBalancerWhitelists) == 0 {
-					return fmt.Errorf("account %d: At least one CLB whitelist must be configured when CLB is enabled", i)
-				}
-				for j, lbw := range account.CLB.LoadBalancerWhitelists {
-					if lbw.AclID == "" {
-						return fmt.Errorf("account %d: CLB whitelist %d acl_id is required", i, j)
-					}
-				}
-			}
-		}
-	} else {
-		// Validate single Aliyun configuration for backward compatibility
-		if c.Aliyun.AccessKeyID == "" {
-			return fmt.Errorf("aliyun.access_key_id is required")
+// validateTargetIPs checks that every referenced IP set is defined and every
+// static entry is a valid IP or CIDR
+func validateTargetIPs(refs, static []string, sets map[string]bool) error {
+	for _, ref := range refs {
+		if !sets[ref] {
+			return fmt.Errorf("ip_sets references unknown IP set '%s'", ref)
 		}
+	}
+	if err := validateCIDRs(static); err != nil {
+		return fmt.Errorf("static_cidrs: %v", err)
+	}
+	return nil
+}
 
-		if c.Aliyun.AccessKeySecret == "" {
-			return fmt.Errorf("aliyun.access_key_secret is required")
+// validateSelector checks that a selector narrows down the resources
+func validateSelector(selector Selector) error {
+	if len(selector.Tags) == 0 && selector.ResourceGroupID == "" {
+		return fmt.Errorf("tags or resource_group_id is required")
+	}
+	for key := range selector.Tags {
+		if key == "" {
+			return fmt.Errorf("tag keys must not be empty")
 		}
+	}
+	return nil
+}
 
-		if c.Aliyun.RegionID == "" {
-			return fmt.Errorf("aliyun.region_id is required")
-		}
+// validateSecurityGroupSelector checks a security group selector and the
+// rule applied to the matching security groups
+func validateSecurityGroupSelector(selector SecurityGroupSelector, sets map[string]bool) error {
+	if err := validateSelector(selector.Selector); err != nil {
+		return err
+	}
+	if selector.SecurityGroupID != "" {
+		return fmt.Errorf("security_group_id must be empty")
+	}
+	if err := validateSecurityGroupRule(selector.SecurityGroup); err != nil {
+		return err
+	}
+	if selector.Priority <= 0 {
+		return fmt.Errorf("priority must be greater than 0")
+	}
+	return validateTargetIPs(selector.IPSets, selector.StaticCIDRs, sets)
+}
+
+// validateInstanceWhitelistSelector checks an RDS or Redis selector and the
+// whitelist applied to the matching instances
+func validateInstanceWhitelistSelector(selector InstanceWhitelistSelector, sets map[string]bool) error {
+	if err := validateSelector(selector.Selector); err != nil {
+		return err
+	}
+	if selector.InstanceID != "" {
+		return fmt.Errorf("instance_id must be empty")
+	}
+	if err := validateWhitelistName(selector.InstanceWhitelist); err != nil {
+		return err
+	}
+	return validateTargetIPs(selector.IPSets, selector.StaticCIDRs, sets)
+}
+
+// validateLoadBalancerWhitelistSelector checks a CLB selector and the
+// whitelist applied to the matching access control lists
+func validateLoadBalancerWhitelistSelector(selector LoadBalancerWhitelistSelector, sets map[string]bool) error {
+	if err := validateSelector(selector.Selector); err != nil {
+		return err
+	}
+	if selector.AclID != "" {
+		return fmt.Errorf("acl_id must be empty")
+	}
+	return validateTargetIPs(selector.IPSets, selector.StaticCIDRs, sets)
+}
 
-		// Validate ECS configuration if enabled
-		if c.Aliyun.ECS.Enabled {
-			if len(c.Aliyun.ECS.SecurityGroupIDs) == 0 {
-				return fmt.Errorf("aliyun: At least one ECS security group must be configured when ECS is enabled")
+// validateSecurityGroupRule checks the protocol, ports and options of a
+// security group rule
+func validateSecurityGroupRule(sg SecurityGroup) error {
+	protocol := sg.GetProtocol()
+	switch protocol {
+	case "tcp", "udp":
+		if len(sg.GetPorts()) == 0 {
+			return fmt.Errorf("port or ports is required for protocol %s", protocol)
+		}
+		for _, port := range sg.GetPorts() {
+			if !portPattern.MatchString(port) {
+				return fmt.Errorf("invalid port or port range '%s'", port)
 			}
-			for i, sg := range c.Aliyun.ECS.SecurityGroupIDs {
-				if sg.SecurityGroupID == "" {
-					return fmt.Errorf("aliyun: ECS security group %d security_group_id is required", i)
-				}
-				if sg.Port == "" {
-					return fmt.Errorf("aliyun: ECS security group %d port is required", i)
-				}
-				if sg.Priority <= 0 {
-					return fmt.Errorf("aliyun: ECS security group %d priority must be greater than 0", i)
-				}
+		}
+	case "icmp", "icmpv6", "gre", "all":
+		for _, port := range sg.GetPorts() {
+			if port != "-1/-1" {
+				return fmt.Errorf("protocol %s does not support port '%s'", protocol, port)
 			}
 		}
+	default:
+		return fmt.Errorf("unknown protocol '%s'", sg.Protocol)
+	}
+	if sg.Port != "" && len(sg.Ports) > 0 {
+		return fmt.Errorf("only one of port and ports can be set")
+	}
 
-		// Validate RDS configuration if enabled
-		if c.Aliyun.RDS.Enabled {
-			if len(c.Aliyun.RDS.InstanceWhitelists) == 0 {
-				return fmt.Errorf("aliyun: At least one RDS instance whitelist must be configured when RDS is enabled")
-			}
-			for i, iw := range c.Aliyun.RDS.InstanceWhitelists {
-				if iw.InstanceID == "" {
-					return fmt.Errorf("aliyun: RDS instance whitelist %d instance_id is required", i)
-				}
-				if iw.WhitelistName == "" {
-					return fmt.Errorf("aliyun: RDS instance whitelist %d whitelist_name is required", i)
-				}
-			}
+	switch sg.GetPolicy() {
+	case "accept", "drop":
+	default:
+		return fmt.Errorf("unknown policy '%s'", sg.Policy)
+	}
+	switch sg.GetNicType() {
+	case "intranet", "internet":
+	default:
+		return fmt.Errorf("unknown nic_type '%s'", sg.NicType)
+	}
+	switch sg.GetDirection() {
+	case "ingress", "egress":
+	default:
+		return fmt.Errorf("unknown direction '%s'", sg.Direction)
+	}
+	return nil
+}
+
+// portPattern matches a port like "22" or a port range like "1/65535"
+var portPattern = regexp.MustCompile(`^\d+(/\d+)?$`)
+
+// validateWhitelistName checks that an instance whitelist names exactly one
+// whitelist group
+func validateWhitelistName(iw InstanceWhitelist) error {
+	if iw.Dedicated {
+		if iw.WhitelistName != "" {
+			return fmt.Errorf("whitelist_name must be empty when dedicated is enabled")
 		}
+		return nil
+	}
+	if iw.WhitelistName == "" {
+		return fmt.Errorf("whitelist_name is required")
+	}
+	return nil
+}
 
-		// Validate Redis configuration if enabled
-		if c.Aliyun.Redis.Enabled {
-			if len(c.Aliyun.Redis.InstanceWhitelists) == 0 {
-				return fmt.Errorf("aliyun: At least one Redis instance whitelist must be configured when Redis is enabled")
-			}
-			for i, iw := range c.Aliyun.Redis.InstanceWhitelists {
-				if iw.InstanceID == "" {
-					return fmt.Errorf("aliyun: Redis instance whitelist %d instance_id is required", i)
-				}
-				if iw.WhitelistName == "" {
-					return fmt.Errorf("aliyun: Redis instance whitelist %d whitelist_name is required", i)
-				}
-			}
+// mixedFamilies reports whether the entries contain both IPv4 and IPv6
+// addresses, which a single prefix list cannot hold
+func mixedFamilies(entries []string) bool {
+	var v4, v6 bool
+	for _, entry := range entries {
+		if strings.Contains(entry, ":") {
+			v6 = true
+		} else {
+			v4 = true
 		}
+	}
+	return v4 && v6
+}
 
-		// Validate CLB configuration if enabled
-		if c.Aliyun.CLB.Enabled {
-			if len(c.Aliyun.CLB.LoadBalancerWhitelists) == 0 {
-				return fmt.Errorf("aliyun: At least one CLB whitelist must be configured when CLB is enabled")
-			}
-			for i, lbw := range c.Aliyun.CLB.LoadBalancerWhitelists {
-				if lbw.AclID == "" {
-					return fmt.Errorf("aliyun: CLB whitelist %d acl_id is required", i)
-				}
+// validateCIDRs checks that every entry is a valid IP or CIDR
+func validateCIDRs(entries []string) error {
+	for _, entry := range entries {
+		if net.ParseIP(entry) == nil {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return fmt.Errorf("invalid IP or CIDR '%s'", entry)
 			}
 		}
 	}
-
 	return nil
 }
 
+// DefaultStateFile is the default path of the persistent state file
+const DefaultStateFile = "state.json"
+
+// GetStateFile returns the path of the persistent state file
+func (c *Config) GetStateFile() string {
+	if c.StateFile != "" {
+		return c.StateFile
+	}
+	return DefaultStateFile
+}
+
+// GetAgentName returns the name identifying this instance as the owner of
+// the entries it adds
+func (c *Config) GetAgentName() string {
+	if c.AgentName != "" {
+		return c.AgentName
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "cloud-whitelist-manager"
+	}
+	return hostname
+}
+
+// GetMinAge returns the minimum age of a rule before it is collected
+func (g *GC) GetMinAge() time.Duration {
+	if g.MinAge > 0 {
+		return time.Duration(g.MinAge) * time.Second
+	}
+	return DefaultGCMinAge * time.Second
+}
+
+// GetMaxEntries returns the sanity limit for the remote list
+func (l *RemoteList) GetMaxEntries() int {
+	if l.MaxEntries > 0 {
+		return l.MaxEntries
+	}
+	return DefaultRemoteListMaxEntries
+}
+
+// GetTimeout returns the timeout of remote list requests. Requests always
+// time out, so that a stalled endpoint cannot block the checks.
+func (l *RemoteList) GetTimeout() time.Duration {
+	if l.Timeout > 0 {
+		return time.Duration(l.Timeout) * time.Second
+	}
+	return DefaultRemoteListTimeout * time.Second
+}
+
 // GetInterval returns the check interval as time.Duration
 func (c *Config) GetInterval() time.Duration {
 	return time.Duration(c.Interval) * time.Second
@@ -298,10 +593,140 @@ func (a *Account) GetAliyun() *Aliyun {
 	return &Aliyun{
 		AccessKeyID:     a.AccessKeyID,
 		AccessKeySecret: a.AccessKeySecret,
+		Credentials:     a.Credentials,
 		RegionID:        a.RegionID,
 		ECS:             a.ECS,
 		RDS:             a.RDS,
 		Redis:           a.Redis,
 		CLB:             a.CLB,
+		PrefixList:      a.PrefixList,
 	}
-}
\ No newline at end of file
+}
+
+// Account returns the Aliyun configuration as an account with the given name
+func (a *Aliyun) Account(name string) Account {
+	return Account{
+		Name:            name,
+		AccessKeyID:     a.AccessKeyID,
+		AccessKeySecret: a.AccessKeySecret,
+		Credentials:     a.Credentials,
+		RegionID:        a.RegionID,
+		ECS:             a.ECS,
+		RDS:             a.RDS,
+		Redis:           a.Redis,
+		CLB:             a.CLB,
+		PrefixList:      a.PrefixList,
+	}
+}
+
+// validateCredentials validates a credentials block. Values that may come
+// from the environment at runtime, like the OIDC token file, are not required.
+func validateCredentials(c *Credentials) error {
+	switch c.Type {
+	case CredentialAccessKey:
+		if c.AccessKeyID == "" || c.AccessKeySecret == "" {
+			return fmt.Errorf("access_key_id and access_key_secret are required")
+		}
+	case CredentialEnv, CredentialProfile, CredentialECSRamRole, CredentialOIDC:
+	case CredentialAssumeRole:
+		if c.RoleArn == "" {
+			return fmt.Errorf("role_arn is required")
+		}
+		if c.Source != nil {
+			if err := validateCredentials(c.Source); err != nil {
+				return fmt.Errorf("source: %v", err)
+			}
+		}
+	case "":
+		return fmt.Errorf("type is required")
+	default:
+		return fmt.Errorf("unsupported type %q", c.Type)
+	}
+	if c.DurationSeconds != 0 && (c.DurationSeconds < 900 || c.DurationSeconds > 43200) {
+		return fmt.Errorf("duration_seconds must be between 900 and 43200")
+	}
+	return nil
+}
+
+// maxGroupNameLen is the maximum length of an RDS whitelist group name, which
+// is also accepted by Redis
+const maxGroupNameLen = 32
+
+// DedicatedGroupName returns the name of the whitelist group owned by an
+// agent, e.g. "cwm_office_gw" for agent "office-gw". Group names may only
+// contain lowercase letters, digits and underscores.
+func DedicatedGroupName(agent string) string {
+	var b strings.Builder
+	b.WriteString("cwm_")
+	for _, r := range strings.ToLower(agent) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	name := strings.TrimRight(b.String(), "_")
+	if len(name) > maxGroupNameLen {
+		name = strings.TrimRight(name[:maxGroupNameLen], "_")
+	}
+	return name
+}
+
+// GetWhitelistName returns the whitelist group managed for the given agent
+func (iw *InstanceWhitelist) GetWhitelistName(agent string) string {
+	if iw.Dedicated {
+		return DedicatedGroupName(agent)
+	}
+	return iw.WhitelistName
+}
+
+// GetPorts returns the ports or port ranges of a security group rule
+func (sg *SecurityGroup) GetPorts() []string {
+	if len(sg.Ports) > 0 {
+		return sg.Ports
+	}
+	if sg.Port != "" {
+		return []string{sg.Port}
+	}
+	if protocol := sg.GetProtocol(); protocol != "tcp" && protocol != "udp" {
+		// Protocols without ports always use the full range
+		return []string{"-1/-1"}
+	}
+	return nil
+}
+
+// GetProtocol returns the IP protocol of a security group rule. Without an
+// explicit protocol, port "-1/-1" means all protocols as in earlier versions.
+func (sg *SecurityGroup) GetProtocol() string {
+	if sg.Protocol != "" {
+		return strings.ToLower(sg.Protocol)
+	}
+	if sg.Port == "-1/-1" {
+		return "all"
+	}
+	return "tcp"
+}
+
+// GetPolicy returns the access policy of a security group rule
+func (sg *SecurityGroup) GetPolicy() string {
+	if sg.Policy != "" {
+		return strings.ToLower(sg.Policy)
+	}
+	return "accept"
+}
+
+// GetNicType returns the network interface type of a security group rule
+func (sg *SecurityGroup) GetNicType() string {
+	if sg.NicType != "" {
+		return strings.ToLower(sg.NicType)
+	}
+	return "intranet"
+}
+
+// GetDirection returns the direction of a security group rule
+func (sg *SecurityGroup) GetDirection() string {
+	if sg.Direction != "" {
+		return strings.ToLower(sg.Direction)
+	}
+	return "ingress"
+}
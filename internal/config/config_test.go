@@ -4,6 +4,9 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -158,4 +161,288 @@ func TestConfigValidation(t *testing.T) {
 	if err == nil {
 		t.Error("Invalid HTTP source should return error")
 	}
-}
\ No newline at end of file
+}
+
+func TestIPSetValidation(t *testing.T) {
+	cfg := &Config{
+		Interval: 300,
+		IPSource: IPSource{Type: "http", URL: "http://ipinfo.io/ip", Timeout: 10},
+		IPSets: []IPSet{
+			{Name: "office", Detected: true, CIDRs: []string{"10.0.0.0/8", "192.168.1.1"}},
+		},
+		Accounts: []Account{
+			{
+				Name:            "test_account",
+				AccessKeyID:     "test_key",
+				AccessKeySecret: "test_secret",
+				RegionID:        "cn-hangzhou",
+				ECS: ECS{
+					Enabled: true,
+					SecurityGroupIDs: []SecurityGroup{
+						{SecurityGroupID: "sg-test", Port: "22", Priority: 100, IPSets: []string{"office"}},
+					},
+				},
+			},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid config should not return error, got: %v", err)
+	}
+
+	// Test unknown IP set reference
+	cfg.Accounts[0].ECS.SecurityGroupIDs[0].IPSets = []string{"unknown"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Unknown IP set reference should return error")
+	}
+	cfg.Accounts[0].ECS.SecurityGroupIDs[0].IPSets = []string{"office"}
+
+	// Test invalid static entry
+	cfg.Accounts[0].ECS.SecurityGroupIDs[0].StaticCIDRs = []string{"office-vpn"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Invalid static entry should return error")
+	}
+	cfg.Accounts[0].ECS.SecurityGroupIDs[0].StaticCIDRs = []string{"10.8.0.0/16"}
+
+	// Test invalid CIDR
+	cfg.IPSets[0].CIDRs = []string{"10.0.0.0/33"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Invalid CIDR should return error")
+	}
+
+	// Test empty IP set
+	cfg.IPSets[0] = IPSet{Name: "office"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("IP set without inputs should return error")
+	}
+}
+
+func TestDedicatedGroup(t *testing.T) {
+	cfg := &Config{
+		Interval: 300,
+		IPSource: IPSource{Type: "http", URL: "http://ipinfo.io/ip", Timeout: 10},
+		Accounts: []Account{
+			{
+				Name:            "test_account",
+				AccessKeyID:     "test_key",
+				AccessKeySecret: "test_secret",
+				RegionID:        "cn-hangzhou",
+				RDS: RDS{
+					Enabled:            true,
+					InstanceWhitelists: []InstanceWhitelist{{InstanceID: "rm-test", Dedicated: true}},
+				},
+			},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Dedicated group without whitelist_name should be valid, got: %v", err)
+	}
+
+	cfg.Accounts[0].RDS.InstanceWhitelists[0].WhitelistName = "default"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Dedicated group with whitelist_name should return error")
+	}
+
+	tests := map[string]string{
+		"office-gw":                          "cwm_office_gw",
+		"Build.Host_01":                      "cwm_build_host_01",
+		"a-very-long-agent-name-for-testing": "cwm_a_very_long_agent_name_for_t",
+	}
+	for agent, expected := range tests {
+		if name := DedicatedGroupName(agent); name != expected {
+			t.Errorf("DedicatedGroupName(%q) = %q, expected %q", agent, name, expected)
+		}
+	}
+}
+
+func TestSecurityGroupRuleValidation(t *testing.T) {
+	valid := []SecurityGroup{
+		{Port: "22"},
+		{Port: "-1/-1"},
+		{Ports: []string{"80", "443", "8000/8100"}, Protocol: "tcp"},
+		{Port: "53", Protocol: "udp", Direction: "egress", Policy: "drop", NicType: "internet"},
+		{Protocol: "icmp"},
+	}
+	for _, sg := range valid {
+		if err := validateSecurityGroupRule(sg); err != nil {
+			t.Errorf("Expected %+v to be valid, got: %v", sg, err)
+		}
+	}
+
+	invalid := []SecurityGroup{
+		{},
+		{Port: "ssh"},
+		{Port: "22", Ports: []string{"80"}},
+		{Port: "22", Protocol: "sctp"},
+		{Port: "22", Protocol: "icmp"},
+		{Port: "22", Policy: "reject"},
+		{Port: "22", NicType: "wan"},
+		{Port: "22", Direction: "both"},
+	}
+	for _, sg := range invalid {
+		if err := validateSecurityGroupRule(sg); err == nil {
+			t.Errorf("Expected %+v to be invalid", sg)
+		}
+	}
+
+	sg := SecurityGroup{Protocol: "gre"}
+	if ports := sg.GetPorts(); len(ports) != 1 || ports[0] != "-1/-1" {
+		t.Errorf("Expected protocol without ports to use -1/-1, got %v", ports)
+	}
+}
+
+func TestPrefixListValidation(t *testing.T) {
+	cfg := &Config{
+		Interval: 300,
+		IPSource: IPSource{Type: "http", URL: "http://ipinfo.io/ip", Timeout: 10},
+		Accounts: []Account{
+			{
+				Name:            "test_account",
+				AccessKeyID:     "test_key",
+				AccessKeySecret: "test_secret",
+				RegionID:        "cn-hangzhou",
+				PrefixList: PrefixList{
+					Enabled: true,
+					PrefixLists: []ManagedPrefixList{
+						{PrefixListID: "pl-test", MaxEntries: 2, StaticCIDRs: []string{"10.8.0.0/16"}},
+					},
+				},
+			},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid config should not return error, got: %v", err)
+	}
+
+	pl := &cfg.Accounts[0].PrefixList.PrefixLists[0]
+	pl.StaticCIDRs = []string{"10.8.0.0/16", "2001:db8::/32"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Mixed address families should return error")
+	}
+
+	pl.StaticCIDRs = []string{"10.8.0.0/16", "10.9.0.0/16", "10.10.0.0/16"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("More static entries than max_entries should return error")
+	}
+
+	pl.StaticCIDRs = nil
+	pl.PrefixListID = ""
+	if err := cfg.Validate(); err == nil {
+		t.Error("Missing prefix_list_id should return error")
+	}
+}
+
+func TestSelectorValidation(t *testing.T) {
+	data := `
+interval: 300
+ip_source:
+  type: http
+  url: "http://ipinfo.io/ip"
+accounts:
+  - name: test_account
+    access_key_id: test_key
+    access_key_secret: test_secret
+    region_id: cn-hangzhou
+    ecs:
+      enabled: true
+      selectors:
+        - tags:
+            env: prod
+          resource_group_id: rg-test
+          ports: ["22", "443"]
+          priority: 100
+    rds:
+      enabled: true
+      selectors:
+        - tags:
+            team: data
+          dedicated: true
+`
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid config should not return error, got: %v", err)
+	}
+
+	selector := cfg.Accounts[0].ECS.Selectors[0]
+	if selector.Tags["env"] != "prod" || selector.ResourceGroupID != "rg-test" || len(selector.Ports) != 2 {
+		t.Errorf("Expected inline selector fields to be parsed, got %+v", selector)
+	}
+
+	// Test selector without tags or resource group
+	cfg.Accounts[0].ECS.Selectors[0].Selector = Selector{}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Selector without tags or resource group should return error")
+	}
+	cfg.Accounts[0].ECS.Selectors[0].Tags = map[string]string{"env": "prod"}
+
+	// Test selector with a fixed ID
+	cfg.Accounts[0].RDS.Selectors[0].InstanceID = "rm-test"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Selector with instance_id should return error")
+	}
+}
+
+func TestCredentialsValidation(t *testing.T) {
+	cfg := &Config{
+		Interval: 300,
+		IPSource: IPSource{Type: "http", URL: "http://ipinfo.io/ip", Timeout: 10},
+		Accounts: []Account{
+			{
+				Name:        "test_account",
+				Credentials: &Credentials{Type: CredentialEnv},
+				RegionID:    "cn-hangzhou",
+			},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Credentials should replace the access key, got: %v", err)
+	}
+
+	creds := cfg.Accounts[0].Credentials
+	creds.Type = CredentialAssumeRole
+	if err := cfg.Validate(); err == nil {
+		t.Error("Missing role_arn should return error")
+	}
+
+	creds.RoleArn = "acs:ram::123456789012:role/whitelist"
+	creds.Source = &Credentials{Type: CredentialAccessKey}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Invalid source credentials should return error")
+	}
+
+	creds.Source = &Credentials{Type: CredentialProfile, Profile: "ops"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid assume_role credentials should not return error, got: %v", err)
+	}
+
+	creds.DurationSeconds = 60
+	if err := cfg.Validate(); err == nil {
+		t.Error("Too short duration_seconds should return error")
+	}
+
+	creds.DurationSeconds = 0
+	creds.Type = "password"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Unsupported type should return error")
+	}
+
+	if creds.GetRoleSessionName() != DefaultRoleSessionName {
+		t.Errorf("Expected default session name, got %s", creds.GetRoleSessionName())
+	}
+}
+
+func TestRemoteListTimeout(t *testing.T) {
+	// Requests without a timeout could block the checks forever
+	if timeout := (&RemoteList{}).GetTimeout(); timeout != DefaultRemoteListTimeout*time.Second {
+		t.Errorf("Expected default timeout, got %v", timeout)
+	}
+	if timeout := (&RemoteList{Timeout: 5}).GetTimeout(); timeout != 5*time.Second {
+		t.Errorf("Expected 5s, got %v", timeout)
+	}
+}
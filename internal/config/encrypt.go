@@ -0,0 +1,170 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// KeyProvider encrypts and decrypts configuration values
+type KeyProvider interface {
+	Encrypt(plaintext string) (string, error)
+	Decrypt(ciphertext string) (string, error)
+}
+
+// Environment variables holding the key of the local provider
+const (
+	EnvEncryptionKey     = "CWM_ENCRYPTION_KEY"
+	EnvEncryptionKeyFile = "CWM_ENCRYPTION_KEY_FILE"
+)
+
+// LocalKeyProvider is the scheme of the built-in AES-256-GCM provider
+const LocalKeyProvider = "local"
+
+// keyProviders are the providers by envelope scheme
+var keyProviders = map[string]KeyProvider{
+	LocalKeyProvider: localKeyProvider{},
+}
+
+// envelopePattern matches encrypted values like "ENC[local:...]"
+var envelopePattern = regexp.MustCompile(`ENC\[([a-z0-9_]+):([A-Za-z0-9+/=]+)\]`)
+
+// RegisterKeyProvider makes a key provider available for an envelope scheme
+func RegisterKeyProvider(scheme string, provider KeyProvider) {
+	keyProviders[scheme] = provider
+}
+
+// EncryptValue encrypts a value with the provider of the given scheme and
+// returns the envelope to put in the configuration
+func EncryptValue(scheme, plaintext string) (string, error) {
+	provider, ok := keyProviders[scheme]
+	if !ok {
+		return "", fmt.Errorf("unknown key provider %q", scheme)
+	}
+	ciphertext, err := provider.Encrypt(plaintext)
+	if err != nil {
+		return "", err
+	}
+	return "ENC[" + scheme + ":" + ciphertext + "]", nil
+}
+
+// decryptValues decrypts the encrypted values in the raw YAML. Comment lines
+// are left untouched. It returns the decrypted document and the decrypted
+// values, which are treated as secrets, and fails listing every value that
+// could not be decrypted.
+func decryptValues(data []byte) ([]byte, []string, error) {
+	var secrets []string
+	var failures []string
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	for i, line := range lines {
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("#")) {
+			continue
+		}
+		lines[i] = envelopePattern.ReplaceAllFunc(line, func(match []byte) []byte {
+			groups := envelopePattern.FindSubmatch(match)
+			scheme, ciphertext := string(groups[1]), string(groups[2])
+
+			provider, ok := keyProviders[scheme]
+			if !ok {
+				failures = append(failures, fmt.Sprintf("line %d: unknown key provider %q", i+1, scheme))
+				return match
+			}
+			plaintext, err := provider.Decrypt(ciphertext)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("line %d: %v", i+1, err))
+				return match
+			}
+			secrets = append(secrets, plaintext)
+			return []byte(plaintext)
+		})
+	}
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return nil, nil, fmt.Errorf("failed to decrypt values: %s", strings.Join(failures, "; "))
+	}
+	return bytes.Join(lines, nil), secrets, nil
+}
+
+// GenerateLocalKey returns a new random key for the local provider, encoded
+// as base64
+func GenerateLocalKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// localKeyProvider encrypts values with AES-256-GCM using a key from the
+// environment, the ciphertext being the base64 of the nonce and sealed value
+type localKeyProvider struct{}
+
+// Encrypt encrypts a value with the local key
+func (localKeyProvider) Encrypt(plaintext string) (string, error) {
+	gcm, err := localCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value with the local key
+func (localKeyProvider) Decrypt(ciphertext string) (string, error) {
+	gcm, err := localCipher()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("invalid ciphertext: %v", err)
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("invalid ciphertext: too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// localCipher creates the AES-256-GCM cipher of the local key
+func localCipher() (cipher.AEAD, error) {
+	encoded := os.Getenv(EnvEncryptionKey)
+	if encoded == "" {
+		if path := os.Getenv(EnvEncryptionKeyFile); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read encryption key: %v", err)
+			}
+			encoded = strings.TrimSpace(string(data))
+		}
+	}
+	if encoded == "" {
+		return nil, fmt.Errorf("%s or %s must be set", EnvEncryptionKey, EnvEncryptionKeyFile)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes encoded as base64")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncryptValue(t *testing.T) {
+	key, err := GenerateLocalKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	t.Setenv(EnvEncryptionKey, key)
+
+	envelope, err := EncryptValue(LocalKeyProvider, "test_secret")
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if !envelopePattern.MatchString(envelope) {
+		t.Fatalf("Expected an envelope, got %s", envelope)
+	}
+
+	data := "# access_key_secret: \"" + envelope + "\"\naccess_key_secret: \"" + envelope + "\"\n"
+	decrypted, secrets, err := decryptValues([]byte(data))
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	expected := "# access_key_secret: \"" + envelope + "\"\naccess_key_secret: \"test_secret\"\n"
+	if string(decrypted) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, decrypted)
+	}
+	if !reflect.DeepEqual(secrets, []string{"test_secret"}) {
+		t.Errorf("Expected decrypted value to be a secret, got %v", secrets)
+	}
+
+	// Another key cannot decrypt the value
+	other, _ := GenerateLocalKey()
+	t.Setenv(EnvEncryptionKey, other)
+	if _, _, err := decryptValues([]byte(data)); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected decryption error on line 2, got: %v", err)
+	}
+}
+
+func TestDecryptUnknownProvider(t *testing.T) {
+	if _, _, err := decryptValues([]byte("a: ENC[vault:YWJj]\n")); err == nil {
+		t.Error("Expected error for unknown key provider")
+	}
+	if _, err := EncryptValue("vault", "abc"); err == nil {
+		t.Error("Expected error for unknown key provider")
+	}
+}
@@ -0,0 +1,399 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the environment variables holding configuration values
+const EnvPrefix = "CWM_"
+
+// envSource is the file reported for issues of a configuration built from
+// the environment only
+const envSource = "environment"
+
+// envProducts are the account sections enabled by setting any of their
+// values from the environment
+var envProducts = []string{"ecs", "rds", "redis", "clb", "prefix_list"}
+
+// envList is a list built from variables. Lists set in the compact form
+// replace the list of the configuration file, lists of indexed variables are
+// merged into it item by item.
+type envList struct {
+	items   map[int]interface{}
+	replace bool
+}
+
+// list returns the list of a key of a mapping, creating it
+func list(mapping map[string]interface{}, key string) *envList {
+	l, _ := mapping[key].(*envList)
+	if l == nil {
+		l = &envList{items: make(map[int]interface{})}
+		mapping[key] = l
+	}
+	return l
+}
+
+// envDocument builds a configuration document from environment variables
+// named after the YAML paths, e.g. CWM_INTERVAL, CWM_IP_SOURCE_URL or
+// CWM_ACCOUNTS_0_REGION_ID. Lists and maps can also be set with a single
+// variable in a compact form, e.g.
+// CWM_ACCOUNTS_0_ECS_SECURITY_GROUPS=sg-1:22:100,sg-2:443:100 or
+// CWM_IP_SOURCE_HEADERS=User-Agent=cwm. Variables not starting with a
+// top-level key, like CWM_ENCRYPTION_KEY, are ignored. Encrypted values are
+// decrypted and returned as secrets. It returns nil without configuration
+// variables.
+func envDocument(environ []string) (map[string]interface{}, []string, error) {
+	root := make(map[string]interface{})
+	var secrets []string
+	var failures []string
+
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		path, ok := strings.CutPrefix(name, EnvPrefix)
+		if !ok || !isConfigVariable(path) {
+			continue
+		}
+
+		if envelopePattern.MatchString(value) {
+			decrypted, plaintexts, err := decryptValues([]byte(value))
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			value = string(decrypted)
+			secrets = append(secrets, plaintexts...)
+		}
+		if err := setEnv(root, reflect.TypeOf(Config{}), path, value); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return nil, nil, fmt.Errorf("invalid configuration variables: %s", strings.Join(failures, "; "))
+	}
+	if len(root) == 0 {
+		return nil, nil, nil
+	}
+	enableProducts(root)
+	return root, secrets, nil
+}
+
+// isConfigVariable reports whether a variable name without the prefix
+// starts with a top-level configuration key
+func isConfigVariable(path string) bool {
+	_, _, ok := matchField(yamlFields(reflect.TypeOf(Config{})), path)
+	return ok
+}
+
+// matchField returns the field whose upper case key is the longest prefix
+// of a variable path, and the rest of the path
+func matchField(fields map[string]reflect.Type, path string) (string, string, bool) {
+	best, rest := "", ""
+	for key := range fields {
+		upper := strings.ToUpper(key)
+		if len(key) <= len(best) {
+			continue
+		}
+		if path == upper {
+			best, rest = key, ""
+		} else if after, ok := strings.CutPrefix(path, upper+"_"); ok {
+			best, rest = key, after
+		}
+	}
+	return best, rest, best != ""
+}
+
+// setEnv sets the value of a variable path in a mapping of the given type
+func setEnv(mapping map[string]interface{}, t reflect.Type, path, value string) error {
+	fields := yamlFields(t)
+	key, rest, ok := matchField(fields, path)
+	if !ok {
+		return fmt.Errorf("unknown configuration key %s", path)
+	}
+	ft := fields[key]
+	for ft.Kind() == reflect.Pointer {
+		ft = ft.Elem()
+	}
+
+	switch ft.Kind() {
+	case reflect.Struct:
+		if rest == "" {
+			return fmt.Errorf("%s is a section, set its keys instead", key)
+		}
+		child, _ := mapping[key].(map[string]interface{})
+		if child == nil {
+			child = make(map[string]interface{})
+			mapping[key] = child
+		}
+		return setEnv(child, ft, rest, value)
+
+	case reflect.Slice:
+		if rest == "" {
+			items, err := compactList(ft.Elem(), value)
+			if err != nil {
+				return err
+			}
+			// Indexed variables of the same list apply to the compact items
+			l := list(mapping, key)
+			l.replace = true
+			for i, item := range items {
+				if child, ok := l.items[i].(map[string]interface{}); ok {
+					for k, v := range child {
+						item.(map[string]interface{})[k] = v
+					}
+				}
+				l.items[i] = item
+			}
+			return nil
+		}
+		indexText, after, _ := strings.Cut(rest, "_")
+		index, err := strconv.Atoi(indexText)
+		if err != nil || index < 0 {
+			return fmt.Errorf("expected an index after %s", strings.ToUpper(key))
+		}
+		l := list(mapping, key)
+		elem := ft.Elem()
+		if elem.Kind() != reflect.Struct {
+			if after != "" {
+				return fmt.Errorf("unknown configuration key %s", after)
+			}
+			l.items[index], err = scalarValue(elem, value)
+			return err
+		}
+		child, _ := l.items[index].(map[string]interface{})
+		if child == nil {
+			child = make(map[string]interface{})
+			l.items[index] = child
+		}
+		return setEnv(child, elem, after, value)
+
+	case reflect.Map:
+		if rest != "" {
+			return fmt.Errorf("%s is set as a list of key=value pairs", key)
+		}
+		entries := make(map[string]interface{})
+		for _, pair := range splitList(value) {
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", pair)
+			}
+			entries[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+		mapping[key] = entries
+		return nil
+
+	default:
+		if rest != "" {
+			return fmt.Errorf("unknown configuration key %s", rest)
+		}
+		scalar, err := scalarValue(ft, value)
+		if err != nil {
+			return err
+		}
+		mapping[key] = scalar
+		return nil
+	}
+}
+
+// scalarValue converts a variable value to the kind of its field
+func scalarValue(t reflect.Type, value string) (interface{}, error) {
+	switch t.Kind() {
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("expected an integer, got %q", value)
+		}
+		return n, nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("expected true or false, got %q", value)
+		}
+		return b, nil
+	default:
+		return value, nil
+	}
+}
+
+// compactList parses a list set with a single variable: comma separated
+// values, or colon separated fields for the targets of an account
+func compactList(elem reflect.Type, value string) ([]interface{}, error) {
+	var items []interface{}
+	for _, item := range splitList(value) {
+		fields := strings.Split(item, ":")
+		var entry interface{}
+		var err error
+		switch elem {
+		case reflect.TypeOf(SecurityGroup{}):
+			// security_group_id[:port[:priority]]
+			entry, err = compactEntry(fields, "security_group_id", "port", "priority")
+		case reflect.TypeOf(InstanceWhitelist{}):
+			// instance_id[:whitelist_name]
+			entry, err = compactEntry(fields, "instance_id", "whitelist_name")
+		case reflect.TypeOf(LoadBalancerWhitelist{}):
+			entry, err = compactEntry(fields, "acl_id")
+		case reflect.TypeOf(ManagedPrefixList{}):
+			// prefix_list_id[:max_entries]
+			entry, err = compactEntry(fields, "prefix_list_id", "max_entries")
+		default:
+			if elem.Kind() == reflect.Struct {
+				return nil, fmt.Errorf("has no compact form, set the keys of each item with an index")
+			}
+			entry, err = scalarValue(elem, item)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, entry)
+	}
+	return items, nil
+}
+
+// compactEntry maps the colon separated fields of a compact list item to
+// keys, converting numeric keys
+func compactEntry(fields []string, keys ...string) (map[string]interface{}, error) {
+	if len(fields) > len(keys) {
+		return nil, fmt.Errorf("expected %s, got %q", strings.Join(keys, ":"), strings.Join(fields, ":"))
+	}
+	entry := make(map[string]interface{})
+	for i, field := range fields {
+		if field == "" {
+			continue
+		}
+		if keys[i] == "priority" || keys[i] == "max_entries" {
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("expected an integer %s, got %q", keys[i], field)
+			}
+			entry[keys[i]] = n
+			continue
+		}
+		entry[keys[i]] = field
+	}
+	return entry, nil
+}
+
+// splitList splits a comma separated value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// enableProducts enables the account sections set from the environment
+// unless enabled is set explicitly
+func enableProducts(root map[string]interface{}) {
+	var accounts []map[string]interface{}
+	if l, ok := root["accounts"].(*envList); ok {
+		for _, account := range l.items {
+			if mapping, ok := account.(map[string]interface{}); ok {
+				accounts = append(accounts, mapping)
+			}
+		}
+	}
+	if legacy, ok := root["aliyun"].(map[string]interface{}); ok {
+		accounts = append(accounts, legacy)
+	}
+
+	for _, account := range accounts {
+		for _, product := range envProducts {
+			section, ok := account[product].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, ok := section["enabled"]; !ok {
+				section["enabled"] = true
+			}
+		}
+	}
+}
+
+// applyEnv merges a document built from the environment over a
+// configuration document. Environment values take precedence, indexed list
+// items are merged into the items of the file with the same index and
+// compact lists replace the lists of the file.
+func applyEnv(data []byte, env map[string]interface{}) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	merged, err := mergeEnv(doc, env, "")
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(merged)
+}
+
+// mergeEnv merges a value built from the environment over a value of the
+// configuration file
+func mergeEnv(base, override interface{}, path string) (interface{}, error) {
+	switch o := override.(type) {
+	case map[string]interface{}:
+		mapping, _ := base.(yaml.MapSlice)
+		merged := make(yaml.MapSlice, len(mapping))
+		copy(merged, mapping)
+
+		keys := make([]string, 0, len(o))
+		for key := range o {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			i := 0
+			for i < len(merged) && fmt.Sprint(merged[i].Key) != key {
+				i++
+			}
+			if i == len(merged) {
+				merged = append(merged, yaml.MapItem{Key: key})
+			}
+			value, err := mergeEnv(merged[i].Value, o[key], joinPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			merged[i].Value = value
+		}
+		return merged, nil
+
+	case *envList:
+		var merged []interface{}
+		if list, ok := base.([]interface{}); ok && !o.replace {
+			merged = append(merged, list...)
+		}
+
+		indexes := make([]int, 0, len(o.items))
+		for index := range o.items {
+			indexes = append(indexes, index)
+		}
+		sort.Ints(indexes)
+		for _, index := range indexes {
+			itemPath := fmt.Sprintf("%s[%d]", path, index)
+			switch {
+			case index < len(merged):
+			case index == len(merged):
+				merged = append(merged, nil)
+			default:
+				return nil, fmt.Errorf("%s: items must be numbered without gaps, %s[%d] is missing", itemPath, path, len(merged))
+			}
+			value, err := mergeEnv(merged[index], o.items[index], itemPath)
+			if err != nil {
+				return nil, err
+			}
+			merged[index] = value
+		}
+		return merged, nil
+
+	default:
+		return override, nil
+	}
+}
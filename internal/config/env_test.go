@@ -0,0 +1,138 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("CWM_INTERVAL", "60")
+	t.Setenv("CWM_IP_SOURCE_TYPE", "http")
+	t.Setenv("CWM_IP_SOURCE_URL", "http://ipinfo.io/ip")
+	t.Setenv("CWM_IP_SOURCE_HEADERS", "User-Agent=cwm, X-Team=ops")
+	t.Setenv("CWM_ACCOUNTS_0_NAME", "prod")
+	t.Setenv("CWM_ACCOUNTS_0_REGION_ID", "cn-hangzhou")
+	t.Setenv("CWM_ACCOUNTS_0_CREDENTIALS_TYPE", "env")
+	t.Setenv("CWM_ACCOUNTS_0_ECS_SECURITY_GROUPS", "sg-1:22:100,sg-2:443:90")
+	t.Setenv("CWM_ACCOUNTS_0_RDS_INSTANCE_WHITELISTS", "rm-1:default")
+	t.Setenv("CWM_ACCOUNTS_0_RDS_INSTANCE_WHITELISTS_0_STATIC_CIDRS", "10.0.0.0/8")
+	t.Setenv("CWM_ACCOUNTS_1_NAME", "staging")
+	t.Setenv("CWM_ACCOUNTS_1_REGION_ID", "cn-shanghai")
+	t.Setenv("CWM_ACCOUNTS_1_CREDENTIALS_TYPE", "env")
+	t.Setenv("CWM_ACCOUNTS_1_CLB_ENABLED", "false")
+	t.Setenv("CWM_ACCOUNTS_1_CLB_LOAD_BALANCER_WHITELISTS", "acl-1")
+	// Variables of other settings are not configuration values
+	t.Setenv(EnvEncryptionKey, "not-a-config-value")
+
+	cfg, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to load config from the environment: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected config from the environment to be valid, got %v", err)
+	}
+
+	if cfg.Interval != 60 || cfg.IPSource.URL != "http://ipinfo.io/ip" {
+		t.Errorf("Expected interval and IP source, got %d %+v", cfg.Interval, cfg.IPSource)
+	}
+	if !reflect.DeepEqual(cfg.IPSource.Headers, map[string]string{"User-Agent": "cwm", "X-Team": "ops"}) {
+		t.Errorf("Expected headers, got %v", cfg.IPSource.Headers)
+	}
+
+	prod := cfg.Accounts[0]
+	expectedGroups := []SecurityGroup{
+		{SecurityGroupID: "sg-1", Port: "22", Priority: 100},
+		{SecurityGroupID: "sg-2", Port: "443", Priority: 90},
+	}
+	if !prod.ECS.Enabled || !reflect.DeepEqual(prod.ECS.SecurityGroupIDs, expectedGroups) {
+		t.Errorf("Expected %+v enabled, got %+v", expectedGroups, prod.ECS)
+	}
+	// Indexed variables are merged into the items of compact lists
+	expectedWhitelists := []InstanceWhitelist{{InstanceID: "rm-1", WhitelistName: "default", StaticCIDRs: []string{"10.0.0.0/8"}}}
+	if !prod.RDS.Enabled || !reflect.DeepEqual(prod.RDS.InstanceWhitelists, expectedWhitelists) {
+		t.Errorf("Expected %+v enabled, got %+v", expectedWhitelists, prod.RDS)
+	}
+
+	// enabled set explicitly is kept
+	staging := cfg.Accounts[1]
+	if staging.Name != "staging" || staging.CLB.Enabled || staging.CLB.LoadBalancerWhitelists[0].AclID != "acl-1" {
+		t.Errorf("Expected CLB disabled for staging, got %+v", staging)
+	}
+
+	// Issues are reported on the environment
+	cfg.Accounts[1].RegionID = ""
+	if err := cfg.Validate(); err == nil || err.Error() != "environment: accounts[1].region_id: is required" {
+		t.Errorf("Expected issue on the environment, got %v", err)
+	}
+}
+
+func TestLoadConfigEnvOverride(t *testing.T) {
+	data := `interval: 300
+ip_source:
+  type: http
+  url: "http://ipinfo.io/ip"
+accounts:
+  - name: prod
+    access_key_id: "test_key"
+    access_key_secret: "test_secret"
+    region_id: "cn-hangzhou"
+    ecs:
+      enabled: true
+      security_groups:
+        - security_group_id: "sg-file"
+          port: "22"
+          priority: 100
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CWM_INTERVAL", "60")
+	t.Setenv("CWM_ACCOUNTS_0_ACCESS_KEY_SECRET", "env_secret")
+	t.Setenv("CWM_ACCOUNTS_0_ECS_SECURITY_GROUPS_0_PRIORITY", "90")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	// The environment takes precedence, other values come from the file
+	account := cfg.Accounts[0]
+	if cfg.Interval != 60 || account.AccessKeyID != "test_key" || account.AccessKeySecret != "env_secret" {
+		t.Errorf("Expected values of the environment over the file, got %d %+v", cfg.Interval, account)
+	}
+	expected := []SecurityGroup{{SecurityGroupID: "sg-file", Port: "22", Priority: 90}}
+	if !reflect.DeepEqual(account.ECS.SecurityGroupIDs, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, account.ECS.SecurityGroupIDs)
+	}
+}
+
+func TestEnvDocumentErrors(t *testing.T) {
+	tests := map[string]string{
+		"CWM_INTERVAL=fast":                                  "CWM_INTERVAL: expected an integer",
+		"CWM_ACCOUNTS_0_REGOIN_ID=cn-hangzhou":               "CWM_ACCOUNTS_0_REGOIN_ID: unknown configuration key REGOIN_ID",
+		"CWM_ACCOUNTS_PROD_NAME=prod":                        "CWM_ACCOUNTS_PROD_NAME: expected an index after ACCOUNTS",
+		"CWM_IP_SETS=office":                                 "CWM_IP_SETS: has no compact form",
+		"CWM_ACCOUNTS_0_ECS_SECURITY_GROUPS=sg-1:22:100:tcp": "expected security_group_id:port:priority",
+	}
+	for variable, expected := range tests {
+		_, _, err := envDocument([]string{variable})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error %q, got %v", variable, expected, err)
+		}
+	}
+
+	if doc, _, err := envDocument([]string{"CWM_KMS_KEY_ID=key", "PATH=/bin"}); doc != nil || err != nil {
+		t.Errorf("Expected no configuration without configuration variables, got %v %v", doc, err)
+	}
+
+	env, _, err := envDocument([]string{"CWM_ACCOUNTS_1_NAME=staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyEnv(nil, env); err == nil || !strings.Contains(err.Error(), "accounts[0] is missing") {
+		t.Errorf("Expected error for a gap in the accounts, got %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// includeKey is the top-level key listing the glob patterns of the files
+// merged into a configuration
+const includeKey = "include"
+
+// Top-level lists that fragments append to instead of conflicting
+var listKeys = map[string]bool{
+	"accounts": true,
+	"ip_sets":  true,
+}
+
+// fragment is a configuration file, interpolated and decrypted
+type fragment struct {
+	path    string
+	data    []byte
+	source  []byte // file with references masked, on their original lines
+	secrets []string
+}
+
+// origin is where a top-level value of a merged configuration is defined
+type origin struct {
+	file string
+	path string // path in that file
+}
+
+// Files returns the configuration files read for a path in load order: the
+// YAML files of a directory, or the file itself, followed by the files
+// matching its include patterns, recursively. Patterns are relative to the
+// including file and files are read once.
+func Files(path string) ([]string, error) {
+	var files []string
+	if err := collectFiles(path, make(map[string]bool), &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// collectFiles appends the files read for a path
+func collectFiles(path string, seen map[string]bool, files *[]string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("failed to read config directory: %v", err)
+		}
+		found := false
+		for _, entry := range entries {
+			name := entry.Name()
+			ext := filepath.Ext(name)
+			if entry.IsDir() || strings.HasPrefix(name, ".") || (ext != ".yaml" && ext != ".yml") {
+				continue
+			}
+			found = true
+			if err := collectFiles(filepath.Join(path, name), seen, files); err != nil {
+				return err
+			}
+		}
+		if !found {
+			return fmt.Errorf("no YAML files in config directory %s", path)
+		}
+		return nil
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if seen[abs] {
+		return nil
+	}
+	seen[abs] = true
+	*files = append(*files, path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	for _, pattern := range includePatterns(data) {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid include pattern %q: %v", path, pattern, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("%s: include pattern %q matches no files", path, pattern)
+		}
+		for _, match := range matches {
+			if err := collectFiles(match, seen, files); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// includePatterns returns the include patterns of a raw configuration file,
+// a pattern or a list of patterns. Files that cannot be parsed before
+// interpolation include nothing, their errors being reported when loaded.
+func includePatterns(data []byte) []string {
+	var doc struct {
+		Include interface{} `yaml:"include"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	switch include := doc.Include.(type) {
+	case string:
+		return []string{include}
+	case []interface{}:
+		var patterns []string
+		for _, pattern := range include {
+			patterns = append(patterns, fmt.Sprint(pattern))
+		}
+		return patterns
+	}
+	return nil
+}
+
+// loadFragments reads, interpolates and decrypts the files of a
+// configuration
+func loadFragments(path string) ([]fragment, error) {
+	files, err := Files(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fragments []fragment
+	for _, file := range files {
+		f, err := loadFragment(file)
+		if err != nil {
+			if len(files) > 1 {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			return nil, err
+		}
+		fragments = append(fragments, f)
+	}
+	return fragments, nil
+}
+
+// loadFragment reads a configuration file, expanding ${ENV} and
+// ${file:PATH} references and decrypting ENC[...] values
+func loadFragment(path string) (fragment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fragment{}, fmt.Errorf("failed to read config file: %v", err)
+	}
+	data, source, secrets, err := interpolate(data)
+	if err != nil {
+		return fragment{}, fmt.Errorf("failed to interpolate config file: %v", err)
+	}
+	data, decrypted, err := decryptValues(data)
+	if err != nil {
+		return fragment{}, fmt.Errorf("failed to decrypt config file: %v", err)
+	}
+	return fragment{path: path, data: data, source: source, secrets: append(secrets, decrypted...)}, nil
+}
+
+// mergeFragments merges configuration files into one document. Accounts and
+// IP sets are appended and templates are merged by name. Any other top-level
+// key may only be defined once, or with the same value everywhere. It
+// returns the document, the origin of its top-level values and the
+// conflicts found. A single file is returned as is, without origins.
+func mergeFragments(fragments []fragment) ([]byte, map[string]origin, []Issue, error) {
+	if len(fragments) == 1 {
+		return fragments[0].data, nil, nil, nil
+	}
+
+	var doc yaml.MapSlice
+	positions := make(map[string]int)
+	origins := make(map[string]origin)
+	var conflicts []Issue
+	set := func(key string, value interface{}) {
+		if i, ok := positions[key]; ok {
+			doc[i].Value = value
+			return
+		}
+		positions[key] = len(doc)
+		doc = append(doc, yaml.MapItem{Key: key, Value: value})
+	}
+
+	for _, f := range fragments {
+		var part yaml.MapSlice
+		if err := yaml.Unmarshal(f.data, &part); err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: failed to parse config file: %v", f.path, err)
+		}
+
+		for _, item := range part {
+			key := fmt.Sprint(item.Key)
+			switch {
+			case key == includeKey:
+			case listKeys[key]:
+				items, ok := item.Value.([]interface{})
+				if !ok && item.Value != nil {
+					return nil, nil, nil, fmt.Errorf("%s: %s must be a list", f.path, key)
+				}
+				var existing []interface{}
+				if i, ok := positions[key]; ok {
+					existing = doc[i].Value.([]interface{})
+				}
+				for j := range items {
+					origins[fmt.Sprintf("%s[%d]", key, len(existing)+j)] = origin{file: f.path, path: fmt.Sprintf("%s[%d]", key, j)}
+				}
+				set(key, append(existing, items...))
+			case key == templatesKey:
+				templates, ok := item.Value.(yaml.MapSlice)
+				if !ok && item.Value != nil {
+					return nil, nil, nil, fmt.Errorf("%s: %s must be a mapping of template names", f.path, key)
+				}
+				var existing yaml.MapSlice
+				if i, ok := positions[key]; ok {
+					existing = doc[i].Value.(yaml.MapSlice)
+				}
+				for _, template := range templates {
+					path := templatesKey + "." + fmt.Sprint(template.Key)
+					if previous, ok := origins[path]; ok {
+						conflicts = append(conflicts, Issue{File: f.path, Path: path, Message: fmt.Sprintf("template is also defined in %s", previous.file)})
+						continue
+					}
+					origins[path] = origin{file: f.path, path: path}
+					existing = append(existing, template)
+				}
+				set(key, existing)
+			default:
+				if previous, ok := origins[key]; ok {
+					if !reflect.DeepEqual(doc[positions[key]].Value, item.Value) {
+						conflicts = append(conflicts, Issue{File: f.path, Path: key, Message: fmt.Sprintf("conflicts with the value defined in %s", previous.file)})
+					}
+					continue
+				}
+				origins[key] = origin{file: f.path, path: key}
+				set(key, item.Value)
+			}
+		}
+	}
+
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return data, origins, conflicts, nil
+}
+
+// referencePathPattern matches the paths of accounts and IP sets in issue
+// messages
+var referencePathPattern = regexp.MustCompile(`\b(accounts|ip_sets)\[\d+\]`)
+
+// locate rewrites an issue found in a merged configuration to the file and
+// path where the value is defined, including the paths its message refers to
+func locate(origins map[string]origin, issue Issue) Issue {
+	if origins == nil || issue.File != "" {
+		return issue
+	}
+	o, ok := findOrigin(origins, issue.Path)
+	if !ok {
+		return issue
+	}
+	issue.File = o.file
+	issue.Path = o.path
+	issue.Message = referencePathPattern.ReplaceAllStringFunc(issue.Message, func(path string) string {
+		ref, ok := findOrigin(origins, path)
+		if !ok {
+			return path
+		}
+		if ref.file != o.file {
+			return ref.path + " in " + ref.file
+		}
+		return ref.path
+	})
+	return issue
+}
+
+// findOrigin returns the origin of a path in a merged configuration, from
+// the origin of its top-level value
+func findOrigin(origins map[string]origin, path string) (origin, bool) {
+	for prefix := path; prefix != ""; {
+		if o, ok := origins[prefix]; ok {
+			o.path += path[len(prefix):]
+			return o, true
+		}
+		cut := strings.LastIndexAny(prefix, ".[")
+		if cut < 0 {
+			break
+		}
+		prefix = prefix[:cut]
+	}
+	return origin{}, false
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes configuration files into a temporary directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const baseFragment = `interval: 300
+ip_source:
+  type: http
+  url: "http://ipinfo.io/ip"
+templates:
+  ssh:
+    port: "22"
+    priority: 100
+`
+
+func TestLoadConfigInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yaml": baseFragment + `include: ["teams/*.yaml"]
+ip_sets:
+  - name: office
+    cidrs: ["10.0.0.0/8"]
+`,
+		"teams/a.yaml": `accounts:
+  - name: team-a
+    access_key_id: "a_key"
+    access_key_secret: "a_secret"
+    region_id: "cn-hangzhou"
+    ecs:
+      enabled: true
+      security_groups:
+        - template: ssh
+          security_group_id: "sg-a"
+          ip_sets: ["office"]
+`,
+		"teams/b.yaml": `interval: 300
+accounts:
+  - name: team-b
+    access_key_id: "b_key"
+    access_key_secret: "b_secret"
+    region_id: "cn-shanghai"
+`,
+	})
+
+	path := filepath.Join(dir, "config.yaml")
+	files, err := Files(path)
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	expectedFiles := []string{path, filepath.Join(dir, "teams/a.yaml"), filepath.Join(dir, "teams/b.yaml")}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("Expected %v, got %v", expectedFiles, files)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected merged config to be valid, got %v", err)
+	}
+	if len(cfg.Accounts) != 2 || cfg.Accounts[0].Name != "team-a" || cfg.Accounts[1].Name != "team-b" {
+		t.Fatalf("Expected the accounts of both teams, got %+v", cfg.Accounts)
+	}
+	// Templates and IP sets of the main file are available to the teams
+	if sg := cfg.Accounts[0].ECS.SecurityGroupIDs[0]; sg.Port != "22" || sg.IPSets[0] != "office" {
+		t.Errorf("Expected the ssh template, got %+v", sg)
+	}
+
+	// Issues are reported in the file defining the account
+	cfg.Accounts[1].RegionID = ""
+	expected := "teams/b.yaml: accounts[0].region_id: is required"
+	if err := cfg.Validate(); err == nil || !strings.HasSuffix(err.Error(), expected) {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+}
+
+func TestLoadConfigDirectory(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"00-base.yaml": baseFragment,
+		"prod.yml": `accounts:
+  - name: prod
+    access_key_id: "prod_key"
+    access_key_secret: "prod_secret"
+    region_id: "cn-hangzhou"
+`,
+		"README.md": "not a configuration file",
+	})
+
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Interval != 300 || len(cfg.Accounts) != 1 || cfg.Accounts[0].Name != "prod" {
+		t.Errorf("Expected the files of the directory merged, got %+v", cfg)
+	}
+
+	if _, err := LoadConfig(t.TempDir()); err == nil {
+		t.Error("Expected error for a directory without YAML files")
+	}
+}
+
+func TestLoadConfigConflicts(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.yaml": baseFragment,
+		"b.yaml": `interval: 600
+templates:
+  ssh:
+    port: "2222"
+`,
+	})
+
+	_, err := LoadConfig(dir)
+	if err == nil {
+		t.Fatal("Expected error for conflicting files")
+	}
+	for _, expected := range []string{"b.yaml: interval: conflicts with the value defined in", "b.yaml: templates.ssh: template is also defined in"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in %v", expected, err)
+		}
+	}
+}
+
+func TestValidateFileDirectory(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.yaml": baseFragment + `accounts:
+  - name: prod
+    access_key_id: "test_key"
+    access_key_secret: "test_secret"
+    region_id: "cn-hangzhou"
+`,
+		"b.yaml": `accounts:
+  - name: staging
+    access_key_id: "test_key"
+    access_key_secret: "test_secret"
+    regoin_id: "cn-hangzhou"
+  - name: prod
+    access_key_id: "test_key"
+    access_key_secret: "test_secret"
+    region_id: "cn-hangzhou"
+`,
+	})
+
+	issues, err := ValidateFile(dir)
+	if err != nil {
+		t.Fatalf("Failed to validate: %v", err)
+	}
+	a, b := filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")
+	expected := []Issue{
+		{File: b, Path: "accounts[0].region_id", Line: 2, Message: "is required"},
+		{File: b, Path: "accounts[0].regoin_id", Line: 5, Message: "unknown key"},
+		{File: b, Path: "accounts[1].name", Line: 6, Message: "duplicate account name 'prod', also used by accounts[0] in " + a},
+	}
+	if !reflect.DeepEqual(issues, expected) {
+		t.Errorf("Expected %v, got %v", expected, issues)
+	}
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// referencePattern matches "${NAME}" environment references, "${file:PATH}"
+// file references and the "$${" escape for a literal "${"
+var referencePattern = regexp.MustCompile(`\$\$\{|\$\{(file:[^}]+|[A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// placeholderPattern matches the placeholders references are masked with
+var placeholderPattern = regexp.MustCompile(`__cwm_reference_(\d+)__`)
+
+// interpolate expands environment and file references in the scalar values
+// of the raw YAML, so that expanded values never change the structure of the
+// document, whatever characters they contain. Comments are left untouched.
+// It returns the expanded document, the document with references masked by
+// placeholders, which keeps the lines of the original file, and the values
+// read from files, which are treated as secrets. Environment values are
+// not, as they are often plain settings such as ports or regions. It fails listing every reference that
+// could not be resolved. A document without references is returned as is.
+func interpolate(data []byte) ([]byte, []byte, []string, error) {
+	masked, references := maskReferences(data)
+	if len(references) == 0 {
+		return data, data, nil, nil
+	}
+
+	// References are masked as they are not valid everywhere in YAML, e.g. in
+	// flow sequences
+	var root yaml.Node
+	if err := yaml.Unmarshal(masked, &root); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	var secrets []string
+	unresolved := make(map[string]bool)
+	expand := func(text string) string {
+		return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+			match := references[placeholderIndex(placeholder)]
+			if match == "$${" {
+				return "${"
+			}
+			reference := match[2 : len(match)-1]
+
+			if path, ok := strings.CutPrefix(reference, "file:"); ok {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					unresolved[reference] = true
+					return match
+				}
+				value := strings.TrimRight(string(content), "\r\n")
+				secrets = append(secrets, value)
+				return value
+			}
+
+			value, ok := os.LookupEnv(reference)
+			if !ok {
+				unresolved[reference] = true
+				return match
+			}
+			return value
+		})
+	}
+	restore := func(text string) string {
+		return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+			return references[placeholderIndex(placeholder)]
+		})
+	}
+	expandNode(&root, expand, restore)
+
+	if len(unresolved) > 0 {
+		var names []string
+		for reference := range unresolved {
+			names = append(names, "${"+reference+"}")
+		}
+		sort.Strings(names)
+		return nil, nil, nil, fmt.Errorf("unresolved references: %s", strings.Join(names, ", "))
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, nil, err
+	}
+	return buf.Bytes(), masked, secrets, nil
+}
+
+// maskReferences replaces the references outside of comment lines by
+// placeholders that are valid plain scalars and returns them by index
+func maskReferences(data []byte) ([]byte, []string) {
+	var references []string
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	for i, line := range lines {
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("#")) {
+			continue
+		}
+		lines[i] = referencePattern.ReplaceAllFunc(line, func(match []byte) []byte {
+			references = append(references, string(match))
+			return []byte(fmt.Sprintf("__cwm_reference_%d__", len(references)-1))
+		})
+	}
+	return bytes.Join(lines, nil), references
+}
+
+// placeholderIndex returns the index of the reference masked by a placeholder
+func placeholderIndex(placeholder string) int {
+	i, _ := strconv.Atoi(placeholderPattern.FindStringSubmatch(placeholder)[1])
+	return i
+}
+
+// expandNode expands the references of every scalar in a node tree and
+// restores them in comments. Plain scalars are resolved again once expanded,
+// so that "${INTERVAL}" can still be a number.
+func expandNode(node *yaml.Node, expand, restore func(string) string) {
+	node.HeadComment = restore(node.HeadComment)
+	node.LineComment = restore(node.LineComment)
+	node.FootComment = restore(node.FootComment)
+
+	if node.Kind == yaml.ScalarNode && placeholderPattern.MatchString(node.Value) {
+		node.Value = expand(node.Value)
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+	}
+	for _, child := range node.Content {
+		expandNode(child, expand, restore)
+	}
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestInterpolate(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("file_secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CWM_TEST_KEY", "env_key")
+	t.Setenv("CWM_TEST_INTERVAL", "60")
+	t.Setenv("CWM_TEST_CIDR", "10.0.0.0/8")
+	// Values that would change the structure of the document if inserted as
+	// text
+	t.Setenv("CWM_TEST_TRICKY", "line: one\n# two\n'three\"")
+
+	data := "# ${NOT_EXPANDED} in a comment\n" +
+		"interval: ${CWM_TEST_INTERVAL}\n" +
+		"access_key_id: \"${CWM_TEST_KEY}\"\n" +
+		"access_key_secret: \"${file:" + secretFile + "}\"\n" +
+		"cidrs: [${CWM_TEST_CIDR}, 192.168.0.0/16] # ${NOT_EXPANDED} either\n" +
+		"plain: ${CWM_TEST_TRICKY}\n" +
+		"quoted: 'prefix ${CWM_TEST_TRICKY}'\n" +
+		"cmd: \"echo $${HOME}\"\n"
+
+	expanded, source, secrets, err := interpolate([]byte(data))
+	if err != nil {
+		t.Fatalf("Failed to interpolate: %v", err)
+	}
+
+	var doc struct {
+		Interval        int      `yaml:"interval"`
+		AccessKeyID     string   `yaml:"access_key_id"`
+		AccessKeySecret string   `yaml:"access_key_secret"`
+		CIDRs           []string `yaml:"cidrs"`
+		Plain           string   `yaml:"plain"`
+		Quoted          string   `yaml:"quoted"`
+		Cmd             string   `yaml:"cmd"`
+	}
+	if err := yaml.Unmarshal(expanded, &doc); err != nil {
+		t.Fatalf("Failed to parse expanded document: %v\n%s", err, expanded)
+	}
+	tricky := "line: one\n# two\n'three\""
+	if doc.Interval != 60 || doc.AccessKeyID != "env_key" || doc.AccessKeySecret != "file_secret" ||
+		!reflect.DeepEqual(doc.CIDRs, []string{"10.0.0.0/8", "192.168.0.0/16"}) ||
+		doc.Plain != tricky || doc.Quoted != "prefix "+tricky || doc.Cmd != "echo ${HOME}" {
+		t.Errorf("Unexpected expanded document:\n%s", expanded)
+	}
+	for _, comment := range []string{"# ${NOT_EXPANDED} in a comment", "# ${NOT_EXPANDED} either"} {
+		if !strings.Contains(string(expanded), comment) {
+			t.Errorf("Expected comment %q to be kept, got:\n%s", comment, expanded)
+		}
+	}
+
+	// The masked source keeps the lines of the file
+	if strings.Count(string(source), "\n") != strings.Count(data, "\n") || strings.Contains(string(source), "${CWM_TEST") {
+		t.Errorf("Expected references masked on their lines, got:\n%s", source)
+	}
+
+	// Only values read from files are secrets
+	expected := []string{"file_secret"}
+	if !reflect.DeepEqual(secrets, expected) {
+		t.Errorf("Expected secrets %q, got %q", expected, secrets)
+	}
+
+	// Documents without references are returned as is
+	plain := []byte("interval: 300 # seconds\n")
+	if expanded, _, _, err := interpolate(plain); err != nil || string(expanded) != string(plain) {
+		t.Errorf("Expected document without references unchanged, got %q %v", expanded, err)
+	}
+}
+
+func TestInterpolateUnresolved(t *testing.T) {
+	data := "a: ${CWM_TEST_MISSING_B}\nb: ${CWM_TEST_MISSING_A}\nc: ${file:/nonexistent/secret}\n"
+
+	_, _, _, err := interpolate([]byte(data))
+	if err == nil {
+		t.Fatal("Expected error for unresolved references")
+	}
+	for _, reference := range []string{"${CWM_TEST_MISSING_A}", "${CWM_TEST_MISSING_B}", "${file:/nonexistent/secret}"} {
+		if !strings.Contains(err.Error(), reference) {
+			t.Errorf("Expected %s in error, got: %v", reference, err)
+		}
+	}
+}
+
+func TestLoadConfigRedactsFileReferences(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretFile, []byte("file_secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CWM_TEST_REGION", "cn-hangzhou")
+	t.Setenv("CWM_TEST_PORT", "22")
+
+	data := "accounts:\n" +
+		"  - name: prod\n" +
+		"    access_key_id: \"${file:" + secretFile + "}\"\n" +
+		"    access_key_secret: \"${file:" + secretFile + "}\"\n" +
+		"    region_id: ${CWM_TEST_REGION}\n" +
+		"    ecs:\n" +
+		"      security_groups:\n" +
+		"        - security_group_id: sg-test\n" +
+		"          port: \"${CWM_TEST_PORT}\"\n" +
+		"          region_id: cn-hangzhou\n"
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	// Plain settings from the environment are printed, including where
+	// the same text appears without a reference
+	account := cfg.Redacted().Accounts[0]
+	if account.AccessKeyID != redacted || account.RegionID != "cn-hangzhou" ||
+		account.ECS.SecurityGroupIDs[0].Port != "22" || account.ECS.SecurityGroupIDs[0].RegionID != "cn-hangzhou" {
+		t.Errorf("Expected only the value read from a file to be redacted, got %+v", account)
+	}
+}
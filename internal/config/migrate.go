@@ -0,0 +1,119 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+// legacyKeyPattern matches the line of the legacy aliyun key, which must
+// start a block mapping
+var legacyKeyPattern = regexp.MustCompile(`^aliyun:\s*(#.*)?$`)
+
+// Migrate rewrites a configuration file using the legacy aliyun block into
+// the accounts format, as a single account with the given name. The block is
+// moved textually so that comments, references and encrypted values are
+// kept, and the result is checked to load into the same accounts.
+func Migrate(data []byte, name string) ([]byte, error) {
+	var before Config
+	if err := yaml.Unmarshal(data, &before); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	if before.Aliyun == nil {
+		return nil, fmt.Errorf("no legacy aliyun block to migrate")
+	}
+	if len(before.Accounts) > 0 {
+		return nil, fmt.Errorf("both aliyun and accounts are configured, move the aliyun block into accounts manually")
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	start := -1
+	for i, line := range lines {
+		if legacyKeyPattern.Match(bytes.TrimRight(line, "\r\n")) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("the aliyun block must be a block mapping starting with a line \"aliyun:\"")
+	}
+
+	// The block ends before the next top-level key, comments and blank lines
+	// in between belonging to what follows
+	end := len(lines)
+	for i := start + 1; i < len(lines); i++ {
+		if !isContinuation(lines[i]) {
+			end = i
+			break
+		}
+	}
+	for end > start+1 && isTopLevelComment(lines[end-1]) {
+		end--
+	}
+
+	// Keys of the block are reindented to line up with the account name
+	indent := -1
+	for _, line := range lines[start+1 : end] {
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] != '#' {
+			if n := len(line) - len(bytes.TrimLeft(line, " ")); indent < 0 || n < indent {
+				indent = n
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	for _, line := range lines[:start] {
+		out.Write(line)
+	}
+	out.WriteString("accounts:\n")
+	out.WriteString("- name: " + strconv.Quote(name) + "\n")
+	for _, line := range lines[start+1 : end] {
+		if len(bytes.TrimSpace(line)) > 0 {
+			line = reindent(line, indent, 2)
+		}
+		out.Write(line)
+	}
+	for _, line := range lines[end:] {
+		out.Write(line)
+	}
+
+	// Make sure the rewritten file describes the same account
+	var after Config
+	if err := yaml.Unmarshal(out.Bytes(), &after); err != nil {
+		return nil, fmt.Errorf("failed to migrate config file: %v", err)
+	}
+	before.normalize()
+	before.Accounts[0].Name = name
+	if after.Aliyun != nil || !reflect.DeepEqual(before.Accounts, after.Accounts) {
+		return nil, fmt.Errorf("failed to migrate config file: the aliyun block could not be moved, move it into accounts manually")
+	}
+	return out.Bytes(), nil
+}
+
+// reindent moves a line from one indentation to another, lines indented
+// less than expected, like comments, being moved as far as possible
+func reindent(line []byte, from, to int) []byte {
+	if to >= from {
+		return append(bytes.Repeat([]byte(" "), to-from), line...)
+	}
+	n := min(from-to, len(line)-len(bytes.TrimLeft(line, " ")))
+	return line[n:]
+}
+
+// isContinuation reports whether a line belongs to the block of the previous
+// top-level key: blank, indented or a comment
+func isContinuation(line []byte) bool {
+	trimmed := bytes.TrimSpace(line)
+	return len(trimmed) == 0 || trimmed[0] == '#' || line[0] == ' ' || line[0] == '\t'
+}
+
+// isTopLevelComment reports whether a line is blank or a comment starting in
+// the first column
+func isTopLevelComment(line []byte) bool {
+	trimmed := bytes.TrimSpace(line)
+	return len(trimmed) == 0 || line[0] == '#'
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const legacyConfig = `interval: 300
+ip_source:
+  type: http
+  url: "http://ipinfo.io/ip"
+
+# Legacy single account
+aliyun:
+ access_key_id: "${ALIYUN_AK_ID}"
+ access_key_secret: "ENC[local:c2VjcmV0]"
+ region_id: "cn-hangzhou"
+
+ # ECS
+ ecs:
+   enabled: true
+   security_groups:
+     - security_group_id: "sg-test"
+       port: "22"
+       priority: 100
+
+# State
+state_file: "state.json"
+`
+
+func TestMigrate(t *testing.T) {
+	migrated, err := Migrate([]byte(legacyConfig), LegacyAccountName)
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	// Comments, references and encrypted values are kept
+	expected := `interval: 300
+ip_source:
+  type: http
+  url: "http://ipinfo.io/ip"
+
+# Legacy single account
+accounts:
+- name: "account"
+  access_key_id: "${ALIYUN_AK_ID}"
+  access_key_secret: "ENC[local:c2VjcmV0]"
+  region_id: "cn-hangzhou"
+
+  # ECS
+  ecs:
+    enabled: true
+    security_groups:
+      - security_group_id: "sg-test"
+        port: "22"
+        priority: 100
+
+# State
+state_file: "state.json"
+`
+	if string(migrated) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, migrated)
+	}
+
+	if _, err := Migrate(migrated, LegacyAccountName); err == nil {
+		t.Error("Expected error for a file without the legacy block")
+	}
+	if _, err := Migrate([]byte("aliyun: {region_id: cn-hangzhou}\n"), LegacyAccountName); err == nil {
+		t.Error("Expected error for a flow mapping")
+	}
+}
+
+func TestLoadLegacyConfig(t *testing.T) {
+	t.Setenv("ALIYUN_AK_ID", "test_key")
+	data := `interval: 300
+ip_source:
+  type: http
+  url: "http://ipinfo.io/ip"
+aliyun:
+  access_key_id: "${ALIYUN_AK_ID}"
+  access_key_secret: "test_secret"
+  ecs:
+    enabled: true
+    security_groups:
+      - security_group_id: "sg-test"
+        port: "22"
+        priority: 100
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Aliyun != nil || len(cfg.Accounts) != 1 || cfg.Accounts[0].Name != LegacyAccountName || cfg.Accounts[0].AccessKeyID != "test_key" {
+		t.Fatalf("Expected the aliyun block as a single account, got %+v", cfg.Accounts)
+	}
+
+	// Issues are still reported on the aliyun block
+	if err := cfg.Validate(); err == nil || err.Error() != "aliyun.region_id: is required" {
+		t.Errorf("Expected missing region on the aliyun block, got %v", err)
+	}
+
+	cfg.Aliyun = &Aliyun{RegionID: "cn-hangzhou"}
+	cfg.legacy = false
+	if err := cfg.Validate(); err == nil || err.Error() != "aliyun: must not be used together with accounts, move it into accounts" {
+		t.Errorf("Expected error for both aliyun and accounts, got %v", err)
+	}
+}
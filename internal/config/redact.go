@@ -0,0 +1,119 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// redacted replaces secret values in printed configurations
+const redacted = "******"
+
+// secretKeys are the YAML keys whose values are always redacted
+var secretKeys = map[string]bool{
+	"access_key_secret": true,
+	"external_id":       true,
+}
+
+// secretHeaderWords mark request headers whose values are redacted
+var secretHeaderWords = []string{"authorization", "cookie", "token", "secret", "key"}
+
+// Redacted returns a copy of the configuration with secret values replaced:
+// access key secrets, external IDs, credential headers and every value read
+// from a file reference or decrypted
+func (c *Config) Redacted() *Config {
+	copied := c.clone()
+	redactValue(reflect.ValueOf(copied).Elem(), c.secrets)
+	return copied
+}
+
+// clone returns a deep copy of the configuration
+func (c *Config) clone() *Config {
+	// A YAML round trip gives a deep copy
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return &Config{}
+	}
+	var copied Config
+	if err := yaml.Unmarshal(data, &copied); err != nil {
+		return &Config{}
+	}
+	copied.legacy = c.legacy
+	copied.secrets = c.secrets
+	copied.origins = c.origins
+	return &copied
+}
+
+// String returns the redacted configuration as YAML, so that logging a
+// configuration never prints secrets
+func (c *Config) String() string {
+	data, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// redactValue walks a configuration value and replaces secret strings
+func redactValue(v reflect.Value, secrets []string) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			redactValue(v.Elem(), secrets)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if field.Kind() == reflect.String && secretKeys[key] && field.String() != "" {
+				field.SetString(redacted)
+				continue
+			}
+			redactValue(field, secrets)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			redactValue(v.Index(i), secrets)
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() != reflect.String {
+			return
+		}
+		for _, key := range v.MapKeys() {
+			if isSecretHeader(key.String()) || isSecret(v.MapIndex(key).String(), secrets) {
+				v.SetMapIndex(key, reflect.ValueOf(redacted))
+			}
+		}
+	case reflect.String:
+		if isSecret(v.String(), secrets) {
+			v.SetString(redacted)
+		}
+	}
+}
+
+// isSecretHeader reports whether a request header carries credentials
+func isSecretHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range secretHeaderWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecret reports whether a value contains a secret read from a file
+// reference or decrypted
+func isSecret(value string, secrets []string) bool {
+	for _, secret := range secrets {
+		if secret != "" && strings.Contains(value, secret) {
+			return true
+		}
+	}
+	return false
+}
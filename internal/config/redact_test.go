@@ -0,0 +1,57 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedacted(t *testing.T) {
+	cfg := &Config{
+		Interval: 300,
+		IPSource: IPSource{
+			Type:    "http",
+			URL:     "https://example.com/ip?token=file_token",
+			Headers: map[string]string{"Authorization": "Bearer abc", "User-Agent": "IP-Update-Tool"},
+		},
+		Accounts: []Account{
+			{
+				Name:            "test_account",
+				AccessKeyID:     "test_key",
+				AccessKeySecret: "test_secret",
+				Credentials: &Credentials{
+					Type:       CredentialAssumeRole,
+					ExternalID: "external_value",
+					Source:     &Credentials{Type: CredentialAccessKey, AccessKeyID: "source_key", AccessKeySecret: "source_secret"},
+				},
+			},
+		},
+		secrets: []string{"file_token"},
+	}
+
+	redactedCfg := cfg.Redacted()
+	account := redactedCfg.Accounts[0]
+	if account.AccessKeyID != "test_key" || account.AccessKeySecret != redacted {
+		t.Errorf("Expected only the secret to be redacted, got %s %s", account.AccessKeyID, account.AccessKeySecret)
+	}
+	if account.Credentials.ExternalID != redacted || account.Credentials.Source.AccessKeySecret != redacted {
+		t.Error("Expected nested credential secrets to be redacted")
+	}
+	if redactedCfg.IPSource.Headers["Authorization"] != redacted || redactedCfg.IPSource.Headers["User-Agent"] != "IP-Update-Tool" {
+		t.Errorf("Expected only the credential header to be redacted, got %v", redactedCfg.IPSource.Headers)
+	}
+	if redactedCfg.IPSource.URL != redacted {
+		t.Errorf("Expected value containing a file secret to be redacted, got %s", redactedCfg.IPSource.URL)
+	}
+
+	// The original configuration is not modified
+	if cfg.Accounts[0].AccessKeySecret != "test_secret" || cfg.Accounts[0].Credentials.ExternalID != "external_value" {
+		t.Error("Expected the original configuration to be unchanged")
+	}
+
+	printed := cfg.String()
+	for _, secret := range []string{"test_secret", "source_secret", "external_value", "Bearer abc", "file_token"} {
+		if strings.Contains(printed, secret) {
+			t.Errorf("Expected %s to be redacted from:\n%s", secret, printed)
+		}
+	}
+}
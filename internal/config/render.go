@@ -0,0 +1,98 @@
+package config
+
+// Effective returns a copy of the configuration with every default filled
+// in: the state file, agent name and GC age, the limits and timeouts of
+// remote lists, the
+// region of every target, the attributes of security group rules and the
+// session settings of assumed roles. Values are rendered the way the engine
+// reads them, so that the result is a valid configuration managing the same
+// targets.
+func (c *Config) Effective() *Config {
+	e := c.clone()
+	e.StateFile = c.GetStateFile()
+	e.AgentName = c.GetAgentName()
+	e.GC.MinAge = int(c.GC.GetMinAge().Seconds())
+
+	for i := range e.IPSets {
+		for j := range e.IPSets[i].RemoteLists {
+			list := &e.IPSets[i].RemoteLists[j]
+			list.MaxEntries = list.GetMaxEntries()
+			list.Timeout = int(list.GetTimeout().Seconds())
+		}
+	}
+
+	for i := range e.Accounts {
+		account := &e.Accounts[i]
+		effectiveCredentials(account.Credentials)
+
+		for j := range account.ECS.SecurityGroupIDs {
+			effectiveSecurityGroup(&account.ECS.SecurityGroupIDs[j], account.RegionID)
+		}
+		for j := range account.ECS.Selectors {
+			effectiveSecurityGroup(&account.ECS.Selectors[j].SecurityGroup, account.RegionID)
+		}
+		for _, whitelists := range [][]InstanceWhitelist{account.RDS.InstanceWhitelists, account.Redis.InstanceWhitelists} {
+			for j := range whitelists {
+				effectiveInstanceWhitelist(&whitelists[j], account.RegionID)
+			}
+		}
+		for _, selectors := range [][]InstanceWhitelistSelector{account.RDS.Selectors, account.Redis.Selectors} {
+			for j := range selectors {
+				effectiveInstanceWhitelist(&selectors[j].InstanceWhitelist, account.RegionID)
+			}
+		}
+		for j := range account.CLB.LoadBalancerWhitelists {
+			account.CLB.LoadBalancerWhitelists[j].RegionID = regionOrDefault(account.CLB.LoadBalancerWhitelists[j].RegionID, account.RegionID)
+		}
+		for j := range account.CLB.Selectors {
+			account.CLB.Selectors[j].RegionID = regionOrDefault(account.CLB.Selectors[j].RegionID, account.RegionID)
+		}
+		for j := range account.PrefixList.PrefixLists {
+			account.PrefixList.PrefixLists[j].RegionID = regionOrDefault(account.PrefixList.PrefixLists[j].RegionID, account.RegionID)
+		}
+	}
+	return e
+}
+
+// effectiveCredentials fills in the session settings of assumed roles
+func effectiveCredentials(c *Credentials) {
+	if c == nil {
+		return
+	}
+	if c.Type == CredentialAssumeRole || c.Type == CredentialOIDC {
+		c.RoleSessionName = c.GetRoleSessionName()
+		c.DurationSeconds = c.GetDurationSeconds()
+	}
+	effectiveCredentials(c.Source)
+}
+
+// effectiveSecurityGroup fills in the region and attributes of a security
+// group rule. A single port is kept as is, as the rule key of port "-1/-1"
+// depends on it.
+func effectiveSecurityGroup(sg *SecurityGroup, regionID string) {
+	// The protocol depends on the port, so it is filled in first
+	sg.RegionID = regionOrDefault(sg.RegionID, regionID)
+	sg.Protocol = sg.GetProtocol()
+	if sg.Port == "" {
+		sg.Ports = sg.GetPorts()
+	}
+	sg.Policy = sg.GetPolicy()
+	sg.NicType = sg.GetNicType()
+	sg.Direction = sg.GetDirection()
+}
+
+// effectiveInstanceWhitelist fills in the region of an RDS or Redis
+// whitelist. Dedicated whitelists keep an empty group name, which is derived
+// from the agent name.
+func effectiveInstanceWhitelist(iw *InstanceWhitelist, regionID string) {
+	iw.RegionID = regionOrDefault(iw.RegionID, regionID)
+}
+
+// regionOrDefault returns the region of a target, defaulting to the region
+// of its account
+func regionOrDefault(regionID, accountRegionID string) string {
+	if regionID != "" {
+		return regionID
+	}
+	return accountRegionID
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEffective(t *testing.T) {
+	cfg := &Config{
+		AgentName: "gw-1",
+		Accounts: []Account{
+			{
+				Name:        "prod",
+				RegionID:    "cn-hangzhou",
+				Credentials: &Credentials{Type: CredentialAssumeRole, RoleArn: "acs:ram::123:role/test"},
+				ECS: ECS{
+					SecurityGroupIDs: []SecurityGroup{
+						{SecurityGroupID: "sg-1", Port: "-1/-1"},
+						{SecurityGroupID: "sg-2", Port: "22", RegionID: "cn-shanghai"},
+						{SecurityGroupID: "sg-3", Protocol: "ICMP"},
+					},
+				},
+				Redis: Redis{
+					InstanceWhitelists: []InstanceWhitelist{{InstanceID: "r-1", Dedicated: true}},
+				},
+			},
+		},
+	}
+
+	effective := cfg.Effective()
+	if effective.StateFile != DefaultStateFile || effective.GC.MinAge != DefaultGCMinAge {
+		t.Errorf("Expected default state file and GC age, got %s %d", effective.StateFile, effective.GC.MinAge)
+	}
+
+	// Lists come back empty rather than nil from the copy
+	account := effective.Accounts[0]
+	expectedGroups := []SecurityGroup{
+		{SecurityGroupID: "sg-1", RegionID: "cn-hangzhou", Port: "-1/-1", Ports: []string{}, Protocol: "all", Policy: "accept", NicType: "intranet", Direction: "ingress", IPSets: []string{}, StaticCIDRs: []string{}},
+		{SecurityGroupID: "sg-2", RegionID: "cn-shanghai", Port: "22", Ports: []string{}, Protocol: "tcp", Policy: "accept", NicType: "intranet", Direction: "ingress", IPSets: []string{}, StaticCIDRs: []string{}},
+		{SecurityGroupID: "sg-3", RegionID: "cn-hangzhou", Ports: []string{"-1/-1"}, Protocol: "icmp", Policy: "accept", NicType: "intranet", Direction: "ingress", IPSets: []string{}, StaticCIDRs: []string{}},
+	}
+	if !reflect.DeepEqual(account.ECS.SecurityGroupIDs, expectedGroups) {
+		t.Errorf("Expected %+v, got %+v", expectedGroups, account.ECS.SecurityGroupIDs)
+	}
+	if iw := account.Redis.InstanceWhitelists[0]; iw.RegionID != "cn-hangzhou" || iw.WhitelistName != "" {
+		t.Errorf("Expected the region and no group name for a dedicated whitelist, got %+v", iw)
+	}
+	if account.Credentials.RoleSessionName != DefaultRoleSessionName || account.Credentials.DurationSeconds != DefaultRoleSessionDuration {
+		t.Errorf("Expected default session settings, got %+v", account.Credentials)
+	}
+
+	// The configuration itself is unchanged
+	if cfg.StateFile != "" || cfg.Accounts[0].ECS.SecurityGroupIDs[0].Protocol != "" {
+		t.Error("Expected Effective to return a copy")
+	}
+}
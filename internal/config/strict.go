@@ -0,0 +1,221 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	yamlv2 "gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidateFile loads a configuration file or directory and returns every
+// issue found, with the file and line it was found on. Besides the checks of
+// Validate, unknown and duplicate keys and conflicts between merged files are
+// reported. Errors are returned for files that cannot be read, interpolated,
+// decrypted or parsed at all.
+func ValidateFile(path string) ([]Issue, error) {
+	doc, err := loadDocument(path, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	fragments := doc.fragments
+
+	// Positions are only available from the yaml.v3 node tree of each file,
+	// taken before interpolation reformats it
+	structures := make(map[string]*structure)
+	var issues []Issue
+	for _, f := range fragments {
+		var root yaml.Node
+		if err := yaml.Unmarshal(f.source, &root); err != nil {
+			return nil, fmt.Errorf("%s: failed to parse config file: %v", f.path, err)
+		}
+		s := &structure{lines: make(map[string]int)}
+		s.walk(&root, reflect.TypeOf(Config{}), "")
+		if len(fragments) > 1 {
+			for i := range s.issues {
+				s.issues[i].File = f.path
+			}
+		}
+		structures[f.path] = s
+		issues = append(issues, s.issues...)
+	}
+
+	data, origins := doc.data, doc.origins
+	issues = append(issues, doc.conflicts...)
+
+	// Keys merged from templates are checked once expanded, on the line of
+	// the mapping referencing the template
+	expanded, err := expandTemplates(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand templates: %v", err)
+	}
+	if !bytes.Equal(expanded, data) {
+		var expandedRoot yaml.Node
+		if err := yaml.Unmarshal(expanded, &expandedRoot); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %v", err)
+		}
+		e := &structure{lines: make(map[string]int)}
+		e.walk(&expandedRoot, reflect.TypeOf(Config{}), "")
+		for _, issue := range e.issues {
+			issue = locate(origins, issue)
+			issue.Line = 0
+			if !slices.ContainsFunc(issues, func(i Issue) bool { return i.File == issue.File && i.Path == issue.Path && i.Message == issue.Message }) {
+				issues = append(issues, issue)
+			}
+		}
+	}
+
+	var config Config
+	if err := yamlv2.Unmarshal(expanded, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	config.origins = origins
+	config.normalize()
+	issues = append(issues, config.Check()...)
+
+	// Files are reported in load order, issues of a file by line
+	order := make(map[string]int)
+	for i, f := range fragments {
+		order[f.path] = i
+	}
+	for i := range issues {
+		file := issues[i].File
+		if file == "" && len(fragments) > 0 {
+			file = fragments[0].path
+		}
+		if s, ok := structures[file]; ok && issues[i].Line == 0 {
+			issues[i].Line = s.line(issues[i].Path)
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].File != issues[j].File {
+			return order[issues[i].File] < order[issues[j].File]
+		}
+		return issues[i].Line < issues[j].Line
+	})
+	return issues, nil
+}
+
+// structure checks the keys of a YAML document against the configuration
+// types and records the line of every path
+type structure struct {
+	lines  map[string]int
+	issues []Issue
+}
+
+// line returns the line of a path, or of its closest parent when the path
+// itself is missing from the document
+func (s *structure) line(path string) int {
+	for path != "" {
+		if line, ok := s.lines[path]; ok {
+			return line
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			break
+		}
+		path = path[:cut]
+	}
+	return 0
+}
+
+// walk checks a node against the type it is decoded into
+func (s *structure) walk(node *yaml.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			s.walk(child, t, path)
+		}
+	case yaml.AliasNode:
+		s.walk(node.Alias, t, path)
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice {
+			return
+		}
+		for i, child := range node.Content {
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			s.lines[childPath] = child.Line
+			s.walk(child, t.Elem(), childPath)
+		}
+	case yaml.MappingNode:
+		var fields map[string]reflect.Type
+		switch t.Kind() {
+		case reflect.Struct:
+			fields = yamlFields(t)
+		case reflect.Map:
+		default:
+			return
+		}
+
+		seen := make(map[string]bool)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Tag == "!!merge" {
+				// Keys merged from an anchor belong to this mapping
+				s.walk(value, t, path)
+				continue
+			}
+
+			childPath := joinPath(path, key.Value)
+			if seen[key.Value] {
+				s.issues = append(s.issues, Issue{Path: childPath, Line: key.Line, Message: "duplicate key"})
+				continue
+			}
+			seen[key.Value] = true
+			s.lines[childPath] = key.Line
+
+			// Keys of maps are data, "template" included
+			if t.Kind() == reflect.Map {
+				s.walk(value, t.Elem(), childPath)
+				continue
+			}
+			// Templates are checked where they are used, included files on
+			// their own
+			if key.Value == templateKey || (path == "" && (key.Value == templatesKey || key.Value == includeKey)) {
+				continue
+			}
+			field, ok := fields[key.Value]
+			if !ok {
+				s.issues = append(s.issues, Issue{Path: childPath, Line: key.Line, Message: "unknown key"})
+				continue
+			}
+			s.walk(value, field, childPath)
+		}
+	}
+}
+
+// yamlFields returns the types of the YAML keys of a struct, including the
+// keys of inlined structs
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		if len(tag) > 1 && tag[1] == "inline" {
+			for name, ft := range yamlFields(field.Type) {
+				fields[name] = ft
+			}
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		if name != "-" {
+			fields[name] = field.Type
+		}
+	}
+	return fields
+}
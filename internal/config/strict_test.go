@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateFile(t *testing.T) {
+	data := `interval: 300
+ip_source:
+  type: http
+  url: "${CWM_TEST_URL}"
+  tiemout: 10
+defaults: &defaults
+  region_id: "cn-hangzhou"
+accounts:
+  - name: prod
+    <<: *defaults
+    access_key_id: "test_key"
+    access_key_secret: "test_secret"
+    ecs:
+      enabled: true
+      security_groups:
+        - security_group_id: "sg-test"
+          port: "22"
+  - name: test
+    access_key_id: "test_key"
+    access_key_id: "test_key"
+    access_key_secret: "test_secret"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Lines are those of the file even though interpolation reformats it
+	t.Setenv("CWM_TEST_URL", "http://ipinfo.io/ip")
+
+	issues, err := ValidateFile(path)
+	if err != nil {
+		t.Fatalf("Failed to validate: %v", err)
+	}
+	// Issues are sorted by line, missing keys are reported on their parent
+	expected := []Issue{
+		{Path: "ip_source.tiemout", Line: 5, Message: "unknown key"},
+		{Path: "defaults", Line: 6, Message: "unknown key"},
+		{Path: "accounts[0].ecs.security_groups[0].priority", Line: 16, Message: "must be greater than 0"},
+		{Path: "accounts[1].region_id", Line: 18, Message: "is required"},
+		{Path: "accounts[1].access_key_id", Line: 20, Message: "duplicate key"},
+	}
+	if len(issues) != len(expected) {
+		t.Fatalf("Expected %d issues, got %v", len(expected), issues)
+	}
+	for i := range expected {
+		if issues[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], issues[i])
+		}
+	}
+}
+
+func TestValidateFileSyntaxError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("interval: [300\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateFile(path); err == nil {
+		t.Error("Expected error for invalid YAML")
+	}
+}
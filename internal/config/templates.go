@@ -0,0 +1,240 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Keys of the template definitions and of the references to them
+const (
+	templatesKey = "templates"
+	templateKey  = "template"
+)
+
+// expandTemplates merges the named templates defined under "templates" into
+// every mapping referencing them with "template", a name or a list of names
+// applied in order. Only mappings of configuration sections can reference
+// templates, "template" is a plain key in maps such as tags and headers. Keys
+// of the mapping override those of its templates, nested mappings are merged
+// and lists are replaced. Templates may reference other templates. The
+// document is returned unchanged when it uses no templates.
+func expandTemplates(data []byte) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	e := &expander{
+		definitions: make(map[string]yaml.MapSlice),
+		expanded:    make(map[templateUse]yaml.MapSlice),
+		expanding:   make(map[string]bool),
+	}
+	var rest yaml.MapSlice
+	for _, item := range doc {
+		if item.Key != templatesKey {
+			rest = append(rest, item)
+			continue
+		}
+		e.used = true
+		definitions, ok := item.Value.(yaml.MapSlice)
+		if !ok && item.Value != nil {
+			return nil, fmt.Errorf("%s: must be a mapping of template names", templatesKey)
+		}
+		for _, definition := range definitions {
+			name := fmt.Sprint(definition.Key)
+			body, ok := definition.Value.(yaml.MapSlice)
+			if !ok {
+				return nil, fmt.Errorf("%s.%s: must be a mapping", templatesKey, name)
+			}
+			e.definitions[name] = body
+		}
+	}
+
+	expanded, err := e.expand(rest, reflect.TypeOf(Config{}), "")
+	if err != nil {
+		return nil, err
+	}
+	if !e.used {
+		return data, nil
+	}
+	return yaml.Marshal(expanded)
+}
+
+// expander expands the template references of a document
+type expander struct {
+	definitions map[string]yaml.MapSlice      // templates as defined
+	expanded    map[templateUse]yaml.MapSlice // templates with their own references expanded
+	expanding   map[string]bool               // templates being expanded, to detect cycles
+	used        bool                          // whether the document uses templates
+}
+
+// templateUse is a template expanded for the section type it is used in
+type templateUse struct {
+	name string
+	t    reflect.Type
+}
+
+// expand expands the template references in a value decoded into type t.
+// Values of unknown keys are left as is.
+func (e *expander) expand(value interface{}, t reflect.Type, path string) (interface{}, error) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		return value, nil
+	}
+
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		switch t.Kind() {
+		case reflect.Struct:
+			return e.expandMapping(v, t, path)
+		case reflect.Map:
+			// Keys of maps are data, "template" included
+			mapping := make(yaml.MapSlice, len(v))
+			for i, item := range v {
+				expanded, err := e.expand(item.Value, t.Elem(), joinPath(path, fmt.Sprint(item.Key)))
+				if err != nil {
+					return nil, err
+				}
+				mapping[i] = yaml.MapItem{Key: item.Key, Value: expanded}
+			}
+			return mapping, nil
+		}
+		return value, nil
+	case []interface{}:
+		if t.Kind() != reflect.Slice {
+			return value, nil
+		}
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			expanded, err := e.expand(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = expanded
+		}
+		return items, nil
+	default:
+		return value, nil
+	}
+}
+
+// expandMapping merges the templates referenced by a mapping of a section of
+// type t under its own keys
+func (e *expander) expandMapping(mapping yaml.MapSlice, t reflect.Type, path string) (yaml.MapSlice, error) {
+	fields := yamlFields(t)
+	var names []string
+	var own yaml.MapSlice
+	for _, item := range mapping {
+		key := fmt.Sprint(item.Key)
+		if key != templateKey {
+			expanded, err := e.expand(item.Value, fields[key], joinPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			own = append(own, yaml.MapItem{Key: item.Key, Value: expanded})
+			continue
+		}
+
+		e.used = true
+		switch ref := item.Value.(type) {
+		case string:
+			names = append(names, ref)
+		case []interface{}:
+			for _, name := range ref {
+				names = append(names, fmt.Sprint(name))
+			}
+		default:
+			return nil, fmt.Errorf("%s: must be a template name or a list of names", joinPath(path, key))
+		}
+	}
+
+	var result yaml.MapSlice
+	for _, name := range names {
+		template, err := e.template(name, t, joinPath(path, templateKey))
+		if err != nil {
+			return nil, err
+		}
+		result = mergeMappings(result, template)
+	}
+	return mergeMappings(result, own), nil
+}
+
+// template returns a template used in a section of type t with its own
+// references expanded
+func (e *expander) template(name string, t reflect.Type, path string) (yaml.MapSlice, error) {
+	use := templateUse{name: name, t: t}
+	if template, ok := e.expanded[use]; ok {
+		return template, nil
+	}
+	definition, ok := e.definitions[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown template '%s'%s", path, name, e.known())
+	}
+	if e.expanding[name] {
+		return nil, fmt.Errorf("%s: template '%s' is part of a reference cycle", path, name)
+	}
+
+	e.expanding[name] = true
+	template, err := e.expandMapping(definition, t, templatesKey+"."+name)
+	delete(e.expanding, name)
+	if err != nil {
+		return nil, err
+	}
+	e.expanded[use] = template
+	return template, nil
+}
+
+// known lists the defined templates for error messages
+func (e *expander) known() string {
+	if len(e.definitions) == 0 {
+		return ", no templates are defined"
+	}
+	names := make([]string, 0, len(e.definitions))
+	for name := range e.definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return ", defined templates: " + strings.Join(names, ", ")
+}
+
+// mergeMappings returns the base mapping with the keys of the override
+// mapping applied, merging nested mappings and replacing everything else
+func mergeMappings(base, override yaml.MapSlice) yaml.MapSlice {
+	merged := make(yaml.MapSlice, len(base), len(base)+len(override))
+	copy(merged, base)
+	for _, item := range override {
+		found := false
+		for i := range merged {
+			if merged[i].Key != item.Key {
+				continue
+			}
+			baseMapping, baseOK := merged[i].Value.(yaml.MapSlice)
+			overrideMapping, overrideOK := item.Value.(yaml.MapSlice)
+			if baseOK && overrideOK {
+				merged[i].Value = mergeMappings(baseMapping, overrideMapping)
+			} else {
+				merged[i].Value = item.Value
+			}
+			found = true
+			break
+		}
+		if !found {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+// joinPath appends a key to a YAML path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigTemplates(t *testing.T) {
+	data := `interval: 300
+ip_source:
+  type: http
+  url: "http://ipinfo.io/ip"
+templates:
+  base:
+    region_id: "cn-hangzhou"
+    rds:
+      enabled: true
+      instance_whitelists:
+        - instance_id: "rm-shared"
+          whitelist_name: "app"
+  shanghai:
+    template: base
+    region_id: "cn-shanghai"
+  ssh:
+    port: "22"
+    priority: 100
+accounts:
+  - name: prod
+    template: base
+    access_key_id: "prod_key"
+    access_key_secret: "prod_secret"
+    ecs:
+      enabled: true
+      security_groups:
+        - template: ssh
+          security_group_id: "sg-prod"
+        - template: ssh
+          security_group_id: "sg-bastion"
+          priority: 90
+  - name: staging
+    template: [shanghai]
+    access_key_id: "staging_key"
+    access_key_secret: "staging_secret"
+    rds:
+      instance_whitelists:
+        - instance_id: "rm-staging"
+          whitelist_name: "app"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected expanded config to be valid, got %v", err)
+	}
+
+	prod, staging := cfg.Accounts[0], cfg.Accounts[1]
+	if prod.RegionID != "cn-hangzhou" || !prod.RDS.Enabled || prod.RDS.InstanceWhitelists[0].InstanceID != "rm-shared" {
+		t.Errorf("Expected the base template in prod, got %+v", prod)
+	}
+	expectedGroups := []SecurityGroup{
+		{SecurityGroupID: "sg-prod", Port: "22", Priority: 100},
+		{SecurityGroupID: "sg-bastion", Port: "22", Priority: 90},
+	}
+	if !reflect.DeepEqual(prod.ECS.SecurityGroupIDs, expectedGroups) {
+		t.Errorf("Expected %+v, got %+v", expectedGroups, prod.ECS.SecurityGroupIDs)
+	}
+
+	// Nested mappings are merged, lists are replaced
+	if staging.RegionID != "cn-shanghai" || !staging.RDS.Enabled {
+		t.Errorf("Expected the shanghai template in staging, got %+v", staging)
+	}
+	if len(staging.RDS.InstanceWhitelists) != 1 || staging.RDS.InstanceWhitelists[0].InstanceID != "rm-staging" {
+		t.Errorf("Expected the whitelists of staging to replace those of the template, got %+v", staging.RDS.InstanceWhitelists)
+	}
+}
+
+func TestLoadConfigTemplateKeyInMaps(t *testing.T) {
+	data := `interval: 300
+ip_source:
+  type: http
+  url: "http://ipinfo.io/ip"
+  headers:
+    template: "X-Template"
+templates:
+  ssh:
+    port: "22"
+    priority: 100
+accounts:
+  - name: prod
+    access_key_id: "test_key"
+    access_key_secret: "test_secret"
+    region_id: "cn-hangzhou"
+    ecs:
+      enabled: true
+      selectors:
+        - template: ssh
+          tags:
+            template: web
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	// "template" is a plain key in maps, a reference in sections
+	if cfg.IPSource.Headers["template"] != "X-Template" {
+		t.Errorf("Expected the template header to be kept, got %v", cfg.IPSource.Headers)
+	}
+	selector := cfg.Accounts[0].ECS.Selectors[0]
+	if selector.Tags["template"] != "web" || selector.Port != "22" || selector.Priority != 100 {
+		t.Errorf("Expected the template tag to be kept and the ssh template applied, got %+v", selector)
+	}
+
+	issues, err := ValidateFile(path)
+	if err != nil {
+		t.Fatalf("Failed to validate: %v", err)
+	}
+	if len(issues) != 0 {
+		t.Errorf("Expected no issues, got %v", issues)
+	}
+}
+
+func TestExpandTemplatesErrors(t *testing.T) {
+	tests := map[string]string{
+		"accounts[0].template: unknown template 'missing', defined templates: base": `
+templates:
+  base:
+    region_id: "cn-hangzhou"
+accounts:
+  - name: prod
+    template: missing
+`,
+		"templates.b.template: template 'a' is part of a reference cycle": `
+templates:
+  a:
+    template: b
+  b:
+    template: a
+accounts:
+  - name: prod
+    template: a
+`,
+		"accounts[0].template: unknown template 'base', no templates are defined": `
+accounts:
+  - name: prod
+    template: base
+`,
+	}
+
+	for expected, data := range tests {
+		_, err := expandTemplates([]byte(data))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestExpandTemplatesUnchanged(t *testing.T) {
+	data := []byte("interval: 300 # seconds\n")
+	expanded, err := expandTemplates(data)
+	if err != nil {
+		t.Fatalf("Failed to expand: %v", err)
+	}
+	if string(expanded) != string(data) {
+		t.Errorf("Expected documents without templates to be unchanged, got %q", expanded)
+	}
+}
+
+func TestValidateFileTemplates(t *testing.T) {
+	data := `interval: 300
+ip_source:
+  type: http
+  url: "http://ipinfo.io/ip"
+templates:
+  ssh:
+    port: "22"
+    priorty: 100
+accounts:
+  - name: prod
+    access_key_id: "test_key"
+    access_key_secret: "test_secret"
+    region_id: "cn-hangzhou"
+    ecs:
+      enabled: true
+      security_groups:
+        - template: ssh
+          security_group_id: "sg-test"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	issues, err := ValidateFile(path)
+	if err != nil {
+		t.Fatalf("Failed to validate: %v", err)
+	}
+	// Keys merged from a template are reported where the template is used
+	expected := []Issue{
+		{Path: "accounts[0].ecs.security_groups[0].priorty", Line: 17, Message: "unknown key"},
+		{Path: "accounts[0].ecs.security_groups[0].priority", Line: 17, Message: "must be greater than 0"},
+	}
+	if !reflect.DeepEqual(issues, expected) {
+		t.Errorf("Expected %v, got %v", expected, issues)
+	}
+}
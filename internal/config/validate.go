@@ -0,0 +1,341 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Issue is a problem found in the configuration
+type Issue struct {
+	File    string `json:"file,omitempty"` // configuration file, when merged from several
+	Path    string `json:"path"`           // YAML path, e.g. "accounts[0].ecs.security_groups[1].priority"
+	Line    int    `json:"line,omitempty"` // line in the configuration file, when known
+	Message string `json:"message"`
+}
+
+// Error formats the issue with its file, path and line
+func (i Issue) Error() string {
+	var b strings.Builder
+	switch {
+	case i.File != "" && i.Line > 0:
+		fmt.Fprintf(&b, "%s:%d: ", i.File, i.Line)
+	case i.File != "":
+		fmt.Fprintf(&b, "%s: ", i.File)
+	case i.Line > 0:
+		fmt.Fprintf(&b, "line %d: ", i.Line)
+	}
+	if i.Path != "" {
+		b.WriteString(i.Path)
+		b.WriteString(": ")
+	}
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+// checker collects the issues of a configuration
+type checker struct {
+	issues []Issue
+}
+
+// add records an issue at a path
+func (k *checker) add(path, format string, args ...interface{}) {
+	k.issues = append(k.issues, Issue{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Check validates the configuration and returns every issue found
+func (c *Config) Check() []Issue {
+	k := &checker{}
+
+	if c.Interval <= 0 {
+		k.add("interval", "must be greater than 0")
+	}
+
+	// Validate IP source
+	switch c.IPSource.Type {
+	case "http":
+		if c.IPSource.URL == "" {
+			k.add("ip_source.url", "is required for type http")
+		}
+	case "command":
+		if c.IPSource.Cmd == "" {
+			k.add("ip_source.cmd", "is required for type command")
+		}
+	case "interface":
+		if c.IPSource.Interface == "" {
+			k.add("ip_source.interface", "is required for type interface")
+		}
+	case "":
+		k.add("ip_source.type", "is required")
+	default:
+		k.add("ip_source.type", "unknown IP source type '%s'", c.IPSource.Type)
+	}
+
+	if strings.ContainsAny(c.AgentName, " /") {
+		k.add("agent_name", "must not contain spaces or slashes")
+	}
+
+	if c.GC.MinAge < 0 {
+		k.add("gc.min_age", "must not be negative")
+	}
+
+	sets := k.checkIPSets(c.IPSets)
+
+	// The legacy aliyun block is normalized into a single account when loaded
+	if c.Aliyun != nil && len(c.Accounts) > 0 {
+		k.add("aliyun", "must not be used together with accounts, move it into accounts")
+	}
+	if c.Aliyun == nil && len(c.Accounts) == 0 {
+		k.add("accounts", "at least one account is required")
+	}
+	if c.Aliyun != nil && len(c.Accounts) == 0 {
+		k.checkAccount("aliyun", c.Aliyun, sets)
+	}
+
+	names := make(map[string]int)
+	for i, account := range c.Accounts {
+		path := fmt.Sprintf("accounts[%d]", i)
+		if c.legacy {
+			path = "aliyun"
+		} else if account.Name == "" {
+			k.add(path+".name", "is required")
+		} else if first, ok := names[account.Name]; ok {
+			k.add(path+".name", "duplicate account name '%s', also used by accounts[%d]", account.Name, first)
+		} else {
+			names[account.Name] = i
+		}
+		k.checkAccount(path, account.GetAliyun(), sets)
+	}
+
+	// Issues of merged files are reported in the file defining the value
+	for i := range k.issues {
+		k.issues[i] = locate(c.origins, k.issues[i])
+	}
+	return k.issues
+}
+
+// checkIPSets validates the named IP sets and returns the defined names
+func (k *checker) checkIPSets(ipSets []IPSet) map[string]bool {
+	sets := make(map[string]bool)
+	for i, set := range ipSets {
+		path := fmt.Sprintf("ip_sets[%d]", i)
+		if set.Name == "" {
+			k.add(path+".name", "is required")
+		} else if sets[set.Name] {
+			k.add(path+".name", "duplicate IP set name '%s'", set.Name)
+		}
+		sets[set.Name] = true
+
+		if !set.Detected && len(set.CIDRs) == 0 && len(set.DNSNames) == 0 && len(set.RemoteLists) == 0 {
+			k.add(path, "at least one of detected, cidrs, dns_names or remote_lists is required")
+		}
+		if err := validateCIDRs(set.CIDRs); err != nil {
+			k.add(path+".cidrs", "%v", err)
+		}
+		for j, list := range set.RemoteLists {
+			listPath := fmt.Sprintf("%s.remote_lists[%d]", path, j)
+			if list.URL == "" {
+				k.add(listPath+".url", "is required")
+			}
+			switch list.Format {
+			case "", "lines":
+				if list.LinePattern != "" {
+					if _, err := regexp.Compile(list.LinePattern); err != nil {
+						k.add(listPath+".line_pattern", "is invalid: %v", err)
+					}
+				}
+			case "json":
+				if list.JSONPath == "" {
+					k.add(listPath+".json_path", "is required for json format")
+				}
+			default:
+				k.add(listPath+".format", "unknown format '%s'", list.Format)
+			}
+			if list.Refresh < 0 {
+				k.add(listPath+".refresh", "must not be negative")
+			}
+			if list.MaxEntries < 0 {
+				k.add(listPath+".max_entries", "must not be negative")
+			}
+		}
+	}
+	return sets
+}
+
+// checkAccount validates the credentials and targets of an account
+func (k *checker) checkAccount(path string, a *Aliyun, sets map[string]bool) {
+	if a.Credentials != nil {
+		if err := validateCredentials(a.Credentials); err != nil {
+			k.add(path+".credentials", "%v", err)
+		}
+	} else {
+		if a.AccessKeyID == "" {
+			k.add(path+".access_key_id", "is required")
+		}
+		if a.AccessKeySecret == "" {
+			k.add(path+".access_key_secret", "is required")
+		}
+	}
+	if a.RegionID == "" {
+		k.add(path+".region_id", "is required")
+	}
+
+	k.checkECS(path+".ecs", a.ECS, sets)
+	k.checkInstanceWhitelists(path+".rds", "RDS", a.RDS.Enabled, a.RDS.InstanceWhitelists, a.RDS.Selectors, sets)
+	k.checkInstanceWhitelists(path+".redis", "Redis", a.Redis.Enabled, a.Redis.InstanceWhitelists, a.Redis.Selectors, sets)
+	k.checkCLB(path+".clb", a.CLB, sets)
+	k.checkPrefixList(path+".prefix_list", a.PrefixList, sets)
+}
+
+// checkECS validates the ECS security groups and selectors
+func (k *checker) checkECS(path string, ecs ECS, sets map[string]bool) {
+	if !ecs.Enabled {
+		return
+	}
+	if len(ecs.SecurityGroupIDs) == 0 && len(ecs.Selectors) == 0 {
+		k.add(path, "at least one security group or selector must be configured when ECS is enabled")
+	}
+	for i, selector := range ecs.Selectors {
+		if err := validateSecurityGroupSelector(selector, sets); err != nil {
+			k.add(fmt.Sprintf("%s.selectors[%d]", path, i), "%v", err)
+		}
+	}
+
+	rules := make(map[string]int)
+	for i, sg := range ecs.SecurityGroupIDs {
+		sgPath := fmt.Sprintf("%s.security_groups[%d]", path, i)
+		if sg.SecurityGroupID == "" {
+			k.add(sgPath+".security_group_id", "is required")
+		}
+		if err := validateSecurityGroupRule(sg); err != nil {
+			k.add(sgPath, "%v", err)
+		}
+		if sg.Priority <= 0 {
+			k.add(sgPath+".priority", "must be greater than 0")
+		}
+		if err := validateTargetIPs(sg.IPSets, sg.StaticCIDRs, sets); err != nil {
+			k.add(sgPath, "%v", err)
+		}
+
+		// The same rule configured twice would fight over the same entries
+		for _, port := range sg.GetPorts() {
+			rule := strings.Join([]string{sg.RegionID, sg.SecurityGroupID, sg.GetDirection(), sg.GetProtocol(), port}, "|")
+			if first, ok := rules[rule]; ok && first != i {
+				k.add(sgPath, "duplicates %s.security_groups[%d]: security group %s port %s", path, first, sg.SecurityGroupID, port)
+				continue
+			}
+			rules[rule] = i
+		}
+	}
+}
+
+// checkInstanceWhitelists validates the RDS or Redis whitelists and
+// selectors
+func (k *checker) checkInstanceWhitelists(path, product string, enabled bool, whitelists []InstanceWhitelist, selectors []InstanceWhitelistSelector, sets map[string]bool) {
+	if !enabled {
+		return
+	}
+	if len(whitelists) == 0 && len(selectors) == 0 {
+		k.add(path, "at least one instance whitelist or selector must be configured when %s is enabled", product)
+	}
+	for i, selector := range selectors {
+		if err := validateInstanceWhitelistSelector(selector, sets); err != nil {
+			k.add(fmt.Sprintf("%s.selectors[%d]", path, i), "%v", err)
+		}
+	}
+
+	groups := make(map[string]int)
+	for i, iw := range whitelists {
+		iwPath := fmt.Sprintf("%s.instance_whitelists[%d]", path, i)
+		if iw.InstanceID == "" {
+			k.add(iwPath+".instance_id", "is required")
+		}
+		if err := validateWhitelistName(iw); err != nil {
+			k.add(iwPath, "%v", err)
+		}
+		if err := validateTargetIPs(iw.IPSets, iw.StaticCIDRs, sets); err != nil {
+			k.add(iwPath, "%v", err)
+		}
+
+		name := iw.WhitelistName
+		if iw.Dedicated {
+			name = "dedicated group"
+		}
+		group := strings.Join([]string{iw.RegionID, iw.InstanceID, name}, "|")
+		if first, ok := groups[group]; ok {
+			k.add(iwPath, "duplicates %s.instance_whitelists[%d]: instance %s %s", path, first, iw.InstanceID, name)
+		} else {
+			groups[group] = i
+		}
+	}
+}
+
+// checkCLB validates the CLB access control lists and selectors
+func (k *checker) checkCLB(path string, clb CLB, sets map[string]bool) {
+	if !clb.Enabled {
+		return
+	}
+	if len(clb.LoadBalancerWhitelists) == 0 && len(clb.Selectors) == 0 {
+		k.add(path, "at least one whitelist or selector must be configured when CLB is enabled")
+	}
+	for i, selector := range clb.Selectors {
+		if err := validateLoadBalancerWhitelistSelector(selector, sets); err != nil {
+			k.add(fmt.Sprintf("%s.selectors[%d]", path, i), "%v", err)
+		}
+	}
+
+	acls := make(map[string]int)
+	for i, lbw := range clb.LoadBalancerWhitelists {
+		lbwPath := fmt.Sprintf("%s.load_balancer_whitelists[%d]", path, i)
+		if lbw.AclID == "" {
+			k.add(lbwPath+".acl_id", "is required")
+		}
+		if err := validateTargetIPs(lbw.IPSets, lbw.StaticCIDRs, sets); err != nil {
+			k.add(lbwPath, "%v", err)
+		}
+
+		acl := lbw.RegionID + "|" + lbw.AclID
+		if first, ok := acls[acl]; ok {
+			k.add(lbwPath, "duplicates %s.load_balancer_whitelists[%d]: ACL %s", path, first, lbw.AclID)
+		} else {
+			acls[acl] = i
+		}
+	}
+}
+
+// checkPrefixList validates the prefix lists
+func (k *checker) checkPrefixList(path string, prefixList PrefixList, sets map[string]bool) {
+	if !prefixList.Enabled {
+		return
+	}
+	if len(prefixList.PrefixLists) == 0 {
+		k.add(path, "at least one prefix list must be configured when prefix_list is enabled")
+	}
+
+	ids := make(map[string]int)
+	for i, pl := range prefixList.PrefixLists {
+		plPath := fmt.Sprintf("%s.prefix_lists[%d]", path, i)
+		if pl.PrefixListID == "" {
+			k.add(plPath+".prefix_list_id", "is required")
+		}
+		if pl.MaxEntries < 0 {
+			k.add(plPath+".max_entries", "must not be negative")
+		}
+		if err := validateTargetIPs(pl.IPSets, pl.StaticCIDRs, sets); err != nil {
+			k.add(plPath, "%v", err)
+		}
+		if len(pl.StaticCIDRs) > pl.MaxEntries && pl.MaxEntries > 0 {
+			k.add(plPath+".static_cidrs", "has more entries than max_entries")
+		}
+		if mixedFamilies(pl.StaticCIDRs) {
+			k.add(plPath+".static_cidrs", "must not mix IPv4 and IPv6")
+		}
+
+		id := pl.RegionID + "|" + pl.PrefixListID
+		if first, ok := ids[id]; ok {
+			k.add(plPath, "duplicates %s.prefix_lists[%d]: prefix list %s", path, first, pl.PrefixListID)
+		} else {
+			ids[id] = i
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	cfg := &Config{
+		Interval: 0,
+		IPSource: IPSource{Type: "http", URL: "http://ipinfo.io/ip", Timeout: 10},
+		Accounts: []Account{
+			{
+				Name:            "prod",
+				AccessKeyID:     "test_key",
+				AccessKeySecret: "test_secret",
+				RegionID:        "cn-hangzhou",
+				ECS: ECS{
+					Enabled: true,
+					SecurityGroupIDs: []SecurityGroup{
+						{SecurityGroupID: "sg-test", Port: "22", Priority: 100},
+						{SecurityGroupID: "sg-test", Ports: []string{"80", "22"}, Priority: 0},
+					},
+				},
+				RDS: RDS{
+					Enabled: true,
+					InstanceWhitelists: []InstanceWhitelist{
+						{InstanceID: "rm-test", WhitelistName: "default"},
+						{InstanceID: "rm-test", WhitelistName: "default"},
+					},
+				},
+			},
+			{Name: "prod", RegionID: "cn-hangzhou"},
+		},
+	}
+
+	issues := cfg.Check()
+	expected := []string{
+		"interval",
+		"accounts[0].ecs.security_groups[1].priority",
+		"accounts[0].ecs.security_groups[1]",
+		"accounts[0].rds.instance_whitelists[1]",
+		"accounts[1].name",
+		"accounts[1].access_key_id",
+		"accounts[1].access_key_secret",
+	}
+	if len(issues) != len(expected) {
+		t.Fatalf("Expected %d issues, got %v", len(expected), issues)
+	}
+	for i, path := range expected {
+		if issues[i].Path != path {
+			t.Errorf("Expected issue %d at %s, got %v", i, path, issues[i])
+		}
+	}
+
+	if err := cfg.Validate(); err == nil || err.Error() != "interval: must be greater than 0" {
+		t.Errorf("Expected Validate to return the first issue, got: %v", err)
+	}
+}
+
+func TestCheckDifferentRules(t *testing.T) {
+	cfg := &Config{
+		Interval: 300,
+		IPSource: IPSource{Type: "http", URL: "http://ipinfo.io/ip", Timeout: 10},
+		Aliyun: &Aliyun{
+			AccessKeyID:     "test_key",
+			AccessKeySecret: "test_secret",
+			RegionID:        "cn-hangzhou",
+			ECS: ECS{
+				Enabled: true,
+				SecurityGroupIDs: []SecurityGroup{
+					{SecurityGroupID: "sg-test", Port: "22", Priority: 100},
+					{SecurityGroupID: "sg-test", Port: "22", Protocol: "udp", Priority: 100},
+					{SecurityGroupID: "sg-test", Port: "22", Direction: "egress", Priority: 100},
+					{SecurityGroupID: "sg-test", Port: "22", RegionID: "cn-shanghai", Priority: 100},
+				},
+			},
+		},
+	}
+
+	if issues := cfg.Check(); len(issues) != 0 {
+		t.Errorf("Rules differing in protocol, direction or region are not duplicates, got %v", issues)
+	}
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+// CleanupResult describes the entries removed from a target
+type CleanupResult struct {
+	Key     string
+	Entries []string
+}
+
+// Cleanup removes every entry owned by the given agent from the matching
+// targets. ECS and CLB entries are identified by the owner ID in their
+// description, RDS and Redis entries by the owners recorded in the state.
+// Dedicated groups of the agent are deleted with all their entries. Entries
+// added by hand to shared whitelists are never touched. With dryRun nothing is removed.
+func (e *Engine) Cleanup(agent string, patterns []string, dryRun bool) ([]CleanupResult, error) {
+	st, err := e.store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %v", err)
+	}
+
+	var results []CleanupResult
+	handled := make(map[string]bool) // ECS rule IDs already seen through another target
+	failed := 0
+	for _, target := range e.match(patterns) {
+		var owned []aliyun.Entry
+		if target.Dedicated {
+			// Removing every entry of a group deletes it
+			target = target.ForAgent(agent)
+			entries, err := target.List()
+			if err != nil {
+				e.logger.Errorf("Failed to list entries of %s: %v. %s", target.Key, err, target.Hint)
+				failed++
+				continue
+			}
+			owned = entries
+		} else if target.Tagged {
+			entries, err := target.List()
+			if err != nil {
+				e.logger.Errorf("Failed to list entries of %s: %v. %s", target.Key, err, target.Hint)
+				failed++
+				continue
+			}
+			for _, entry := range entries {
+				owner, managed := aliyun.ParseOwner(entry.Description)
+				if !managed || owner == "" || !aliyun.OwnedBy(owner, agent) {
+					continue
+				}
+				if entry.RuleID != "" {
+					if handled[entry.RuleID] {
+						continue
+					}
+					handled[entry.RuleID] = true
+				}
+				owned = append(owned, entry)
+			}
+		} else if aliyun.OwnedBy(st.Owners[target.Key], agent) {
+			for _, cidr := range st.Applied[target.Key] {
+				owned = append(owned, aliyun.Entry{CIDR: cidr})
+			}
+		}
+
+		if len(owned) == 0 {
+			continue
+		}
+		results = append(results, CleanupResult{Key: target.Key, Entries: aliyun.CIDRs(owned)})
+		if dryRun {
+			continue
+		}
+
+		err := target.Delete(owned)
+		if err != nil {
+			e.logger.Errorf("Failed to clean up %s: %v. %s", target.Key, err, target.Hint)
+			failed++
+			continue
+		}
+
+		err = e.store.Update(func(st *state.State) error {
+			if target.Dedicated || aliyun.OwnedBy(st.Owners[target.Key], agent) {
+				delete(st.Applied, target.Key)
+				delete(st.Owners, target.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			e.logger.Errorf("Failed to record state for %s: %v", target.Key, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return results, fmt.Errorf("%d targets failed to clean up", failed)
+	}
+	return results, nil
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+func TestCleanup(t *testing.T) {
+	store := newStore(t)
+	err := store.Update(func(st *state.State) error {
+		st.Applied["prod/rds/rm-test:default"] = []string{"192.168.1.1/32"}
+		st.Owners["prod/rds/rm-test:default"] = "agent-1/prod/rds/rm-test:default"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecsEntries := []aliyun.Entry{
+		{CIDR: "192.168.1.1/32", RuleID: "sgr-1", Description: aliyun.Description("agent-1/prod/ecs/sg-test:22", 512)},
+		{CIDR: "192.168.2.1/32", RuleID: "sgr-2", Description: aliyun.Description("agent-2/prod/ecs/sg-test:22", 512)},
+		{CIDR: "10.8.0.0/16", RuleID: "sgr-3", Description: "office VPN"},
+		{CIDR: "192.168.3.1/32", RuleID: "sgr-4", Description: aliyun.ManagedDescription},
+	}
+	var deleted []aliyun.Entry
+	var rdsRemoved []aliyun.Entry
+
+	eng := New(logrus.New(), &config.Config{}, nil, store)
+	eng.targets = []Target{
+		{
+			Key:    "prod/ecs/sg-test:22",
+			Tagged: true,
+			List:   func() ([]aliyun.Entry, error) { return ecsEntries, nil },
+			Delete: func(entries []aliyun.Entry) error {
+				deleted = append(deleted, entries...)
+				return nil
+			},
+		},
+		{
+			// Another port of the same security group lists the same rules
+			Key:    "prod/ecs/sg-test:443",
+			Tagged: true,
+			List:   func() ([]aliyun.Entry, error) { return ecsEntries, nil },
+			Delete: func(entries []aliyun.Entry) error {
+				deleted = append(deleted, entries...)
+				return nil
+			},
+		},
+		{
+			Key: "prod/rds/rm-test:default",
+			Delete: func(entries []aliyun.Entry) error {
+				rdsRemoved = append(rdsRemoved, entries...)
+				return nil
+			},
+		},
+	}
+
+	// Dry run reports without removing anything
+	results, err := eng.Cleanup("agent-1", nil, true)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(results) != 2 || len(deleted) != 0 {
+		t.Fatalf("Expected 2 results and nothing deleted, got %v and %v", results, deleted)
+	}
+
+	results, err = eng.Cleanup("agent-1", nil, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].RuleID != "sgr-1" {
+		t.Errorf("Expected only rule sgr-1 to be deleted, got %v", deleted)
+	}
+	if !reflect.DeepEqual(aliyun.CIDRs(rdsRemoved), []string{"192.168.1.1/32"}) {
+		t.Errorf("Expected RDS entry from state to be removed, got %v", rdsRemoved)
+	}
+
+	st, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.Applied["prod/rds/rm-test:default"]; ok {
+		t.Error("Expected cleaned up target to be removed from state")
+	}
+}
+
+func TestCleanupDedicatedGroup(t *testing.T) {
+	groups := map[string][]aliyun.Entry{
+		"cwm_agent_1": {{CIDR: "192.168.1.1/32"}, {CIDR: "10.8.0.0/16"}},
+	}
+	var deleted []string
+
+	// group returns a target of the dedicated group of an agent
+	var group func(agent string) Target
+	group = func(agent string) Target {
+		name := config.DedicatedGroupName(agent)
+		return Target{
+			Key:       "prod/rds/rm-test:" + name,
+			Dedicated: true,
+			List:      func() ([]aliyun.Entry, error) { return groups[name], nil },
+			Delete: func(entries []aliyun.Entry) error {
+				deleted = append(deleted, aliyun.CIDRs(entries)...)
+				return nil
+			},
+			ForAgent: group,
+		}
+	}
+
+	eng := New(logrus.New(), &config.Config{AgentName: "agent-2"}, nil, newStore(t))
+	eng.targets = []Target{group("agent-2")}
+
+	// Cleaning up another agent removes its group, not the agent's own one
+	results, err := eng.Cleanup("agent-1", nil, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(results) != 1 || results[0].Key != "prod/rds/rm-test:cwm_agent_1" {
+		t.Errorf("Expected group cwm_agent_1 to be cleaned up, got %v", results)
+	}
+	if !reflect.DeepEqual(deleted, []string{"192.168.1.1/32", "10.8.0.0/16"}) {
+		t.Errorf("Expected every entry of the group to be removed, got %v", deleted)
+	}
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+)
+
+// Check is the result of a preflight check
+type Check struct {
+	Subject string `json:"subject"` // account name or target key
+	Name    string `json:"name"`    // credentials, discovery or access
+	OK      bool   `json:"ok"`
+	Detail  string `json:"detail"`
+
+	// Unverified is set for checks that read-only calls cannot perform
+	Unverified bool `json:"unverified,omitempty"`
+}
+
+// Doctor verifies with read-only calls that the credentials of every account
+// work, that selectors can be resolved, and that every target exists in its
+// region and can be read. Write access cannot be verified without changing
+// the targets, so it is reported as unverified for every readable target.
+// Targets of accounts whose credentials fail are not probed.
+func (e *Engine) Doctor() []Check {
+	var checks []Check
+	failed := make(map[string]bool)
+	for _, account := range e.accounts {
+		arn, err := account.Client.Identity()
+		if err != nil {
+			checks = append(checks, failedCheck(account.Name, "credentials", err))
+			failed[account.Name] = true
+			continue
+		}
+		checks = append(checks, Check{Subject: account.Name, Name: "credentials", OK: true, Detail: arn})
+
+		if !hasSelectors(account.Client.GetConfig()) {
+			continue
+		}
+		discovered, err := Discover(account, e.agent)
+		if err != nil {
+			checks = append(checks, failedCheck(account.Name, "discovery", err))
+			continue
+		}
+		checks = append(checks, Check{Subject: account.Name, Name: "discovery", OK: true, Detail: fmt.Sprintf("%d resources match the selectors", len(discovered))})
+	}
+
+	// Entries added before ownership tagging are owned when recorded as applied
+	applied := make(map[string][]string)
+	if st, err := e.store.Load(); err != nil {
+		e.logger.Errorf("Failed to load state: %v", err)
+	} else {
+		applied = st.Applied
+	}
+
+	for _, target := range e.targets {
+		if failed[target.Account] {
+			checks = append(checks, Check{Subject: target.Key, Name: "access", Detail: "skipped, credentials of the account do not work"})
+			continue
+		}
+		checks = append(checks, e.probe(target, applied[target.Key])...)
+	}
+	return checks
+}
+
+// probe reads the entries of a target and lists the write actions it needs
+func (e *Engine) probe(target Target, applied []string) []Check {
+	entries, err := target.List()
+	if err != nil {
+		check := failedCheck(target.Key, "access", err)
+		if target.Hint != "" {
+			check.Detail += ". " + target.Hint
+		}
+		return []Check{check}
+	}
+
+	owned := 0
+	for _, entry := range entries {
+		if ownsEntry(target, entry, applied) {
+			owned++
+		}
+	}
+	detail := fmt.Sprintf("%d entries", len(entries))
+	if target.Tagged {
+		detail += fmt.Sprintf(", %d owned by this agent", owned)
+	}
+	if target.Dedicated && len(entries) == 0 {
+		detail = "dedicated group is created on the first update"
+	}
+	write := Check{
+		Subject:    target.Key,
+		Name:       "write",
+		Unverified: true,
+		Detail:     fmt.Sprintf("requires %s, not verifiable with read-only calls, see the policy command", strings.Join(aliyun.WriteActions(target.Kind, target.Direction), ", ")),
+	}
+	return []Check{{Subject: target.Key, Name: "access", OK: true, Detail: detail}, write}
+}
+
+// failedCheck describes a failed API call
+func failedCheck(subject, name string, err error) Check {
+	kind, summary := aliyun.Diagnose(err)
+	return Check{Subject: subject, Name: name, Detail: kind + ": " + summary}
+}
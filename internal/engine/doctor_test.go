@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+func TestDoctor(t *testing.T) {
+	store := newStore(t)
+	store.Update(func(st *state.State) error {
+		st.Applied["test/clb/acl-test"] = []string{"10.0.0.1/32"}
+		return nil
+	})
+	clbOwner := "gw-1/test/clb/acl-" + strings.Repeat("x", 100)
+
+	eng := New(logrus.New(), &config.Config{AgentName: "gw-1"}, nil, store)
+	eng.targets = []Target{
+		{
+			Key:       "test/ecs/sg-test:22",
+			Kind:      "ecs",
+			Direction: "ingress",
+			Owner:     "gw-1/test/ecs/sg-test:22",
+			Tagged:    true,
+			List: func() ([]aliyun.Entry, error) {
+				return []aliyun.Entry{
+					{CIDR: "192.168.1.1/32", Description: aliyun.Description("gw-1/test/ecs/sg-test:22", 512)},
+					{CIDR: "10.0.0.0/8", Description: "office"},
+				}, nil
+			},
+		},
+		{
+			Key:    "test/clb/acl-test",
+			Kind:   "clb",
+			Owner:  clbOwner,
+			Tagged: true,
+			List: func() ([]aliyun.Entry, error) {
+				return []aliyun.Entry{
+					// Comments are truncated to 100 characters
+					{CIDR: "192.168.1.1/32", Description: aliyun.Description(clbOwner, 100)},
+					// Added before ownership tagging
+					{CIDR: "10.0.0.1/32", Description: aliyun.ManagedDescription},
+				}, nil
+			},
+		},
+		{
+			Key:  "test/rds/rm-test:default",
+			Hint: "Please check the instance.",
+			List: func() ([]aliyun.Entry, error) {
+				return nil, errors.New("whitelist group default not found for RDS instance rm-test")
+			},
+		},
+		{
+			Key:       "test/redis/r-test:cwm_gw_1",
+			Kind:      "redis",
+			Dedicated: true,
+			List: func() ([]aliyun.Entry, error) {
+				return nil, nil
+			},
+		},
+	}
+
+	checks := eng.Doctor()
+	expected := []Check{
+		{Subject: "test/ecs/sg-test:22", Name: "access", OK: true, Detail: "2 entries, 1 owned by this agent"},
+		{Subject: "test/ecs/sg-test:22", Name: "write", Unverified: true, Detail: "requires ecs:AuthorizeSecurityGroup, ecs:RevokeSecurityGroup, not verifiable with read-only calls, see the policy command"},
+		{Subject: "test/clb/acl-test", Name: "access", OK: true, Detail: "2 entries, 2 owned by this agent"},
+		{Subject: "test/clb/acl-test", Name: "write", Unverified: true, Detail: "requires slb:AddAccessControlListEntry, slb:RemoveAccessControlListEntry, not verifiable with read-only calls, see the policy command"},
+		{Subject: "test/rds/rm-test:default", Name: "access", Detail: "not found: whitelist group default not found for RDS instance rm-test. Please check the instance."},
+		{Subject: "test/redis/r-test:cwm_gw_1", Name: "access", OK: true, Detail: "dedicated group is created on the first update"},
+		{Subject: "test/redis/r-test:cwm_gw_1", Name: "write", Unverified: true, Detail: "requires kvstore:ModifySecurityIps, not verifiable with read-only calls, see the policy command"},
+	}
+	if len(checks) != len(expected) {
+		t.Fatalf("Expected %d checks, got %v", len(expected), checks)
+	}
+	for i := range expected {
+		if checks[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], checks[i])
+		}
+	}
+}
@@ -0,0 +1,630 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/ipset"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+// Account pairs an account name with its Aliyun client
+type Account struct {
+	Name   string
+	Client *aliyun.Client
+}
+
+// Target represents a single whitelist managed by the engine
+type Target struct {
+	Key       string   // unique key, e.g. "prod/ecs/sg-123:22"
+	Account   string   // account name
+	Kind      string   // ecs, rds, redis, clb or prefix_list
+	Resource  string   // security group, instance, ACL or prefix list ID
+	Direction string   // ingress or egress for ECS rules
+	IPSets    []string // referenced IP sets, empty for the detected IP
+	Static    []string // static entries that must always be present
+	Owner     string   // owner ID of the entries added by this agent
+	Tagged    bool     // whether listed entries carry their owner ID
+	Dedicated bool     // whether the whole whitelist group belongs to this agent
+	Hint      string   // hint logged when an update fails
+	Apply     func(add, remove []string) error
+	List      func() ([]aliyun.Entry, error)
+	Delete    func(entries []aliyun.Entry) error
+
+	// ForAgent returns the target of the dedicated group of another agent
+	ForAgent func(agent string) Target
+}
+
+// Engine reconciles the desired IP sets against every configured target
+type Engine struct {
+	logger     *logrus.Logger
+	agent      string
+	resolver   *ipset.Resolver
+	store      *state.Store
+	accounts   []Account
+	targets    []Target            // targets configured by ID
+	discovered map[string][]Target // targets discovered by tags, per account
+	last       *ipset.Snapshot     // last resolved IP sets, reused when leases expire
+}
+
+// New creates a new engine for the given accounts
+func New(logger *logrus.Logger, cfg *config.Config, accounts []Account, store *state.Store) *Engine {
+	var targets []Target
+	for _, account := range accounts {
+		targets = append(targets, Targets(account, cfg.GetAgentName())...)
+	}
+
+	return &Engine{
+		logger:   logger,
+		agent:    cfg.GetAgentName(),
+		resolver: ipset.NewResolver(cfg),
+		store:    store,
+		accounts: accounts,
+		targets:  targets,
+	}
+}
+
+// Targets returns the targets configured for an account, owned by agent
+func Targets(account Account, agent string) []Target {
+	return configuredTargets(account, account.Client.GetConfig(), agent)
+}
+
+// configuredTargets returns the targets of an account configuration, owned
+// by agent. Targets of an account without a client only describe the
+// whitelists and must not be applied or listed.
+func configuredTargets(account Account, cfg *config.Aliyun, agent string) []Target {
+	var targets []Target
+	client := account.Client
+
+	if cfg.ECS.Enabled {
+		for _, sg := range cfg.ECS.SecurityGroupIDs {
+			targets = append(targets, ecsTarget(account, sg, agent))
+		}
+	}
+
+	if cfg.RDS.Enabled {
+		for _, iw := range cfg.RDS.InstanceWhitelists {
+			targets = append(targets, rdsTarget(account, iw, agent))
+		}
+	}
+
+	if cfg.Redis.Enabled {
+		for _, iw := range cfg.Redis.InstanceWhitelists {
+			targets = append(targets, redisTarget(account, iw, agent))
+		}
+	}
+
+	if cfg.CLB.Enabled {
+		for _, lbw := range cfg.CLB.LoadBalancerWhitelists {
+			targets = append(targets, clbTarget(account, lbw, agent))
+		}
+	}
+
+	if cfg.PrefixList.Enabled {
+		for _, pl := range cfg.PrefixList.PrefixLists {
+			key := fmt.Sprintf("%s/prefix_list/%s", account.Name, pl.PrefixListID)
+			owner := agent + "/" + key
+			targets = append(targets, Target{
+				Key:      key,
+				Account:  account.Name,
+				Kind:     "prefix_list",
+				Resource: pl.PrefixListID,
+				IPSets:   pl.IPSets,
+				Static:   pl.StaticCIDRs,
+				Owner:    owner,
+				Tagged:   true,
+				Hint:     "Please check if the prefix list ID is correct and the AccessKey has proper permissions.",
+				Apply: func(add, remove []string) error {
+					return client.SyncPrefixList(pl, owner, add, remove)
+				},
+				List: func() ([]aliyun.Entry, error) {
+					return client.ListPrefixListEntries(pl)
+				},
+				Delete: func(entries []aliyun.Entry) error {
+					return client.SyncPrefixList(pl, owner, nil, aliyun.CIDRs(entries))
+				},
+			})
+		}
+	}
+
+	return targets
+}
+
+// Discover returns the targets of the resources matching the selectors of an
+// account, owned by agent
+func Discover(account Account, agent string) ([]Target, error) {
+	var targets []Target
+	client := account.Client
+	cfg := client.GetConfig()
+
+	if cfg.ECS.Enabled {
+		for _, selector := range cfg.ECS.Selectors {
+			regional, err := client.Region(selector.RegionID)
+			if err != nil {
+				return nil, err
+			}
+			ids, err := regional.DiscoverSecurityGroups(selector.Selector)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				sg := selector.SecurityGroup
+				sg.SecurityGroupID = id
+				targets = append(targets, ecsTarget(account, sg, agent))
+			}
+		}
+	}
+
+	if cfg.RDS.Enabled {
+		for _, selector := range cfg.RDS.Selectors {
+			regional, err := client.Region(selector.RegionID)
+			if err != nil {
+				return nil, err
+			}
+			ids, err := regional.DiscoverRDSInstances(selector.Selector)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				iw := selector.InstanceWhitelist
+				iw.InstanceID = id
+				targets = append(targets, rdsTarget(account, iw, agent))
+			}
+		}
+	}
+
+	if cfg.Redis.Enabled {
+		for _, selector := range cfg.Redis.Selectors {
+			regional, err := client.Region(selector.RegionID)
+			if err != nil {
+				return nil, err
+			}
+			ids, err := regional.DiscoverRedisInstances(selector.Selector)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				iw := selector.InstanceWhitelist
+				iw.InstanceID = id
+				targets = append(targets, redisTarget(account, iw, agent))
+			}
+		}
+	}
+
+	if cfg.CLB.Enabled {
+		for _, selector := range cfg.CLB.Selectors {
+			regional, err := client.Region(selector.RegionID)
+			if err != nil {
+				return nil, err
+			}
+			ids, err := regional.DiscoverCLBAcls(selector.Selector)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				lbw := selector.LoadBalancerWhitelist
+				lbw.AclID = id
+				targets = append(targets, clbTarget(account, lbw, agent))
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+// hasSelectors reports whether an account discovers any resources by tags
+func hasSelectors(cfg *config.Aliyun) bool {
+	return (cfg.ECS.Enabled && len(cfg.ECS.Selectors) > 0) ||
+		(cfg.RDS.Enabled && len(cfg.RDS.Selectors) > 0) ||
+		(cfg.Redis.Enabled && len(cfg.Redis.Selectors) > 0) ||
+		(cfg.CLB.Enabled && len(cfg.CLB.Selectors) > 0)
+}
+
+// ecsTarget returns the target of the security group rules managed by agent
+func ecsTarget(account Account, sg config.SecurityGroup, agent string) Target {
+	client := account.Client
+	key := fmt.Sprintf("%s/ecs/%s:%s", account.Name, sg.SecurityGroupID, ecsRuleKey(sg))
+	owner := agent + "/" + key
+	return Target{
+		Key:       key,
+		Account:   account.Name,
+		Kind:      "ecs",
+		Resource:  sg.SecurityGroupID,
+		Direction: sg.GetDirection(),
+		IPSets:    sg.IPSets,
+		Static:    sg.StaticCIDRs,
+		Owner:     owner,
+		Tagged:    true,
+		Hint:      "Please check if the security group ID is correct and the AccessKey has proper permissions.",
+		Apply: func(add, remove []string) error {
+			return client.SyncECSSecurityGroup(sg, owner, add, remove)
+		},
+		List: func() ([]aliyun.Entry, error) {
+			return client.ListECSEntries(sg)
+		},
+		Delete: func(entries []aliyun.Entry) error {
+			return client.RevokeECSEntries(sg, entries)
+		},
+	}
+}
+
+// ecsRuleKey identifies security group rules by their ports, followed by the
+// options that differ from their defaults, e.g. "80+443" or "53,udp,egress".
+// Rules of a single port with only default options keep the plain port used
+// by earlier versions, where port "-1/-1" implies all protocols.
+func ecsRuleKey(sg config.SecurityGroup) string {
+	parts := []string{strings.Join(sg.GetPorts(), "+")}
+	implied := sg
+	implied.Protocol = ""
+	if protocol := sg.GetProtocol(); protocol != implied.GetProtocol() {
+		parts = append(parts, protocol)
+	}
+	if policy := sg.GetPolicy(); policy != "accept" {
+		parts = append(parts, policy)
+	}
+	if nicType := sg.GetNicType(); nicType != "intranet" {
+		parts = append(parts, nicType)
+	}
+	if direction := sg.GetDirection(); direction != "ingress" {
+		parts = append(parts, direction)
+	}
+	return strings.Join(parts, ",")
+}
+
+// rdsTarget returns the target of an RDS instance whitelist managed by agent
+func rdsTarget(account Account, iw config.InstanceWhitelist, agent string) Target {
+	client := account.Client
+	iw.WhitelistName = iw.GetWhitelistName(agent)
+	key := fmt.Sprintf("%s/rds/%s:%s", account.Name, iw.InstanceID, iw.WhitelistName)
+	return Target{
+		Key:       key,
+		Account:   account.Name,
+		Kind:      "rds",
+		Resource:  iw.InstanceID,
+		IPSets:    iw.IPSets,
+		Static:    iw.StaticCIDRs,
+		Owner:     agent + "/" + key,
+		Dedicated: iw.Dedicated,
+		Hint:      "Please check if the RDS instance ID is correct and the AccessKey has proper permissions.",
+		Apply: func(add, remove []string) error {
+			return client.SyncRDSWhitelist(iw, add, remove)
+		},
+		List: func() ([]aliyun.Entry, error) {
+			return client.ListRDSEntries(iw)
+		},
+		Delete: func(entries []aliyun.Entry) error {
+			return client.SyncRDSWhitelist(iw, nil, aliyun.CIDRs(entries))
+		},
+		ForAgent: func(other string) Target {
+			return rdsTarget(account, iw, other)
+		},
+	}
+}
+
+// redisTarget returns the target of a Redis instance whitelist managed by agent
+func redisTarget(account Account, iw config.InstanceWhitelist, agent string) Target {
+	client := account.Client
+	iw.WhitelistName = iw.GetWhitelistName(agent)
+	key := fmt.Sprintf("%s/redis/%s:%s", account.Name, iw.InstanceID, iw.WhitelistName)
+	return Target{
+		Key:       key,
+		Account:   account.Name,
+		Kind:      "redis",
+		Resource:  iw.InstanceID,
+		IPSets:    iw.IPSets,
+		Static:    iw.StaticCIDRs,
+		Owner:     agent + "/" + key,
+		Dedicated: iw.Dedicated,
+		Hint:      "Please check if the Redis instance ID is correct and the AccessKey has proper permissions.",
+		Apply: func(add, remove []string) error {
+			return client.SyncRedisWhitelist(iw, add, remove)
+		},
+		List: func() ([]aliyun.Entry, error) {
+			return client.ListRedisEntries(iw)
+		},
+		Delete: func(entries []aliyun.Entry) error {
+			return client.SyncRedisWhitelist(iw, nil, aliyun.CIDRs(entries))
+		},
+		ForAgent: func(other string) Target {
+			return redisTarget(account, iw, other)
+		},
+	}
+}
+
+// clbTarget returns the target of a CLB access control list managed by agent
+func clbTarget(account Account, lbw config.LoadBalancerWhitelist, agent string) Target {
+	client := account.Client
+	key := fmt.Sprintf("%s/clb/%s", account.Name, lbw.AclID)
+	owner := agent + "/" + key
+	return Target{
+		Key:      key,
+		Account:  account.Name,
+		Kind:     "clb",
+		Resource: lbw.AclID,
+		IPSets:   lbw.IPSets,
+		Static:   lbw.StaticCIDRs,
+		Owner:    owner,
+		Tagged:   true,
+		Hint:     "Please check if the CLB ACL ID is correct and the AccessKey has proper permissions.",
+		Apply: func(add, remove []string) error {
+			return client.SyncCLBWhitelist(lbw, owner, add, remove)
+		},
+		List: func() ([]aliyun.Entry, error) {
+			return client.ListCLBEntries(lbw)
+		},
+		Delete: func(entries []aliyun.Entry) error {
+			return client.SyncCLBWhitelist(lbw, owner, nil, aliyun.CIDRs(entries))
+		},
+	}
+}
+
+// discover refreshes the targets of the resources matching the selectors of
+// every account. When discovery fails for an account, its previously
+// discovered targets are kept.
+func (e *Engine) discover() {
+	if e.discovered == nil {
+		e.discovered = make(map[string][]Target)
+	}
+	for _, account := range e.accounts {
+		if !hasSelectors(account.Client.GetConfig()) {
+			delete(e.discovered, account.Name)
+			continue
+		}
+		targets, err := Discover(account, e.agent)
+		if err != nil {
+			e.logger.Errorf("Failed to discover resources of account %s, keeping %d known targets: %v", account.Name, len(e.discovered[account.Name]), err)
+			continue
+		}
+		e.setDiscovered(account.Name, targets)
+	}
+}
+
+// setDiscovered replaces the discovered targets of an account. Entries
+// applied to targets whose resources no longer match the selectors are
+// revoked before they are forgotten, unless the targets are also configured
+// by ID.
+func (e *Engine) setDiscovered(name string, targets []Target) {
+	current := make(map[string]bool)
+	for _, target := range e.targets {
+		current[target.Key] = true
+	}
+	for _, target := range targets {
+		current[target.Key] = true
+	}
+	var dropped []Target
+	for _, target := range e.discovered[name] {
+		if !current[target.Key] {
+			dropped = append(dropped, target)
+		}
+	}
+
+	e.discovered[name] = targets
+	if err := e.revoke(dropped); err != nil {
+		e.logger.Errorf("Failed to revoke targets no longer discovered in account %s: %v", name, err)
+	}
+}
+
+// allTargets returns the configured and discovered targets. Discovered
+// targets duplicating a configured one are dropped. Resources are discovered
+// on first use when no reconciliation has run yet.
+func (e *Engine) allTargets() []Target {
+	if e.discovered == nil {
+		e.discover()
+	}
+
+	targets := slices.Clone(e.targets)
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		seen[target.Key] = true
+	}
+	for _, account := range e.accounts {
+		for _, target := range e.discovered[account.Name] {
+			if seen[target.Key] {
+				continue
+			}
+			seen[target.Key] = true
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// Reconcile resolves the IP sets and applies the minimal changes needed to
+// bring every target to its desired set
+func (e *Engine) Reconcile() error {
+	snapshot := e.resolver.Resolve()
+	if snapshot.DetectedErr != nil {
+		e.logger.Errorf("Failed to get public IP: %v", snapshot.DetectedErr)
+	} else {
+		e.logger.Infof("Current public IP: %s", snapshot.Detected)
+	}
+	for name, err := range snapshot.Errors {
+		e.logger.Errorf("Failed to resolve IP set %s: %v", name, err)
+	}
+	for url, err := range snapshot.Stale {
+		e.logger.Warnf("Failed to refresh remote list %s, keeping last known entries: %v", url, err)
+	}
+
+	e.last = snapshot
+	e.discover()
+	return e.apply(snapshot)
+}
+
+// ExpireLeases removes expired leases from the state and revokes their
+// entries from the affected targets, reusing the last resolved IP sets
+func (e *Engine) ExpireLeases() error {
+	var expired []state.Lease
+	err := e.store.Update(func(st *state.State) error {
+		expired = st.PruneLeases(time.Now())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update state: %v", err)
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	for _, lease := range expired {
+		e.logger.Infof("Lease for %s expired at %s", lease.CIDR, lease.ExpiresAt.Format(time.RFC3339))
+	}
+	if e.last == nil {
+		// Nothing has been applied yet, the next reconciliation will skip them
+		return nil
+	}
+	return e.apply(e.last)
+}
+
+// apply brings every target to the desired set computed from the snapshot,
+// the static entries and the active leases, comparing it with the entries
+// listed from the target. Only the entries actually added are recorded as
+// applied.
+func (e *Engine) apply(snapshot *ipset.Snapshot) error {
+	st, err := e.store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load state: %v", err)
+	}
+	leases := st.ActiveLeases(time.Now())
+
+	targets := e.allTargets()
+	results := make(map[string]state.Result)
+	failed := 0
+	for _, target := range targets {
+		result := state.Result{Time: time.Now()}
+		fail := func(err error) {
+			result.Error = err.Error()
+			results[target.Key] = result
+			failed++
+		}
+
+		desired, err := e.desired(target, snapshot, leases)
+		if err != nil {
+			e.logger.Errorf("Skipping %s: %v", target.Key, err)
+			fail(err)
+			continue
+		}
+
+		present, owned, err := liveEntries(target, st.Applied[target.Key])
+		if err != nil {
+			e.logger.Errorf("Failed to list entries of %s: %v. %s", target.Key, err, target.Hint)
+			fail(err)
+			continue
+		}
+
+		// Entries added by hand are neither added again nor recorded, entries
+		// of the target removed by hand are restored
+		add, _ := ipset.Diff(present, desired)
+		_, remove := ipset.Diff(owned, desired)
+		var applied []string
+		for _, entry := range desired {
+			if slices.Contains(owned, entry) || slices.Contains(add, entry) {
+				applied = append(applied, entry)
+			}
+		}
+
+		changed := len(add) > 0 || len(remove) > 0
+		if !changed && slices.Equal(applied, st.Applied[target.Key]) {
+			e.logger.Debugf("%s is up to date", target.Key)
+			results[target.Key] = result
+			continue
+		}
+		if changed {
+			e.logger.Infof("Updating %s: adding [%s], removing [%s]", target.Key, strings.Join(add, ", "), strings.Join(remove, ", "))
+			err = target.Apply(add, remove)
+			if err != nil {
+				e.logger.Errorf("Failed to update %s: %v. %s", target.Key, err, target.Hint)
+				fail(err)
+				continue
+			}
+		}
+
+		err = e.store.Update(func(st *state.State) error {
+			st.Applied[target.Key] = applied
+			st.Owners[target.Key] = target.Owner
+			return nil
+		})
+		if err != nil {
+			e.logger.Errorf("Failed to record state for %s: %v", target.Key, err)
+			fail(err)
+			continue
+		}
+		if changed {
+			e.logger.Infof("%s updated successfully", target.Key)
+		}
+		results[target.Key] = result
+	}
+
+	// Record the outcome for the status command, replacing the results of
+	// targets that are gone
+	err = e.store.Update(func(st *state.State) error {
+		st.Results = results
+		st.LastRun = &state.Run{Time: time.Now(), DetectedIP: snapshot.Detected, Targets: len(targets), Failed: failed}
+		return nil
+	})
+	if err != nil {
+		e.logger.Errorf("Failed to record results: %v", err)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d targets failed to update", failed, len(targets))
+	}
+	return nil
+}
+
+// liveEntries lists the entries of a target and returns those present,
+// whoever added them, and those owned by the agent
+func liveEntries(target Target, applied []string) (present, owned []string, err error) {
+	entries, err := target.List()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, entry := range entries {
+		present = append(present, entry.CIDR)
+		if ownsEntry(target, entry, applied) {
+			owned = append(owned, entry.CIDR)
+		}
+	}
+	slices.Sort(present)
+	slices.Sort(owned)
+	return slices.Compact(present), slices.Compact(owned), nil
+}
+
+// ownsEntry reports whether the agent owns a listed entry of a target. The
+// agent owns the whole of a dedicated group and the entries of a tagged
+// target carrying its owner ID. Listings of untagged targets do not tell who
+// added an entry, so the agent owns those recorded as applied.
+func ownsEntry(target Target, entry aliyun.Entry, applied []string) bool {
+	switch {
+	case target.Dedicated:
+		return true
+	case target.Tagged:
+		// Entries added before ownership tagging have no owner ID
+		owner, managed := aliyun.ParseOwner(entry.Description)
+		return aliyun.Owns(target.Owner, entry) || (managed && owner == "" && slices.Contains(applied, entry.CIDR))
+	default:
+		return slices.Contains(applied, entry.CIDR)
+	}
+}
+
+// desired computes the desired entries of a target
+func (e *Engine) desired(target Target, snapshot *ipset.Snapshot, leases []state.Lease) ([]string, error) {
+	desired, err := snapshot.Desired(target.IPSets)
+	if err != nil {
+		return nil, err
+	}
+
+	desired = append(desired, target.Static...)
+	for _, lease := range leases {
+		if lease.Matches(target.Key) {
+			desired = append(desired, lease.CIDR)
+		}
+	}
+	return ipset.NormalizeAll(desired)
+}
@@ -0,0 +1,526 @@
+package engine
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+func newStore(t *testing.T) *state.Store {
+	store, err := state.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// fakeWhitelist is a whitelist holding the entries applied to it, tagged
+// with the owner ID when one is set
+type fakeWhitelist struct {
+	entries      []string
+	descriptions map[string]string                // descriptions of the entries
+	owner        string                           // owner ID of added entries
+	apply        func(add, remove []string) error // called before each update
+}
+
+func (w *fakeWhitelist) List() ([]aliyun.Entry, error) {
+	var entries []aliyun.Entry
+	for _, cidr := range w.entries {
+		entries = append(entries, aliyun.Entry{CIDR: cidr, Description: w.descriptions[cidr]})
+	}
+	return entries, nil
+}
+
+func (w *fakeWhitelist) Apply(add, remove []string) error {
+	if w.apply != nil {
+		if err := w.apply(add, remove); err != nil {
+			return err
+		}
+	}
+	var entries []string
+	for _, entry := range w.entries {
+		if !slices.Contains(remove, entry) {
+			entries = append(entries, entry)
+		}
+	}
+	w.entries = append(entries, add...)
+	if w.owner != "" {
+		if w.descriptions == nil {
+			w.descriptions = make(map[string]string)
+		}
+		for _, entry := range add {
+			w.descriptions[entry] = aliyun.Description(w.owner, 512)
+		}
+	}
+	return nil
+}
+
+func TestReconcile(t *testing.T) {
+	currentIP := "192.168.1.1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(currentIP))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		IPSource: config.IPSource{Type: "http", URL: server.URL, Timeout: 10},
+		IPSets: []config.IPSet{
+			{Name: "office", CIDRs: []string{"10.0.0.0/8"}},
+		},
+	}
+
+	var calls [][2][]string
+	var fail bool
+	eng := New(logrus.New(), cfg, nil, newStore(t))
+	sg := &fakeWhitelist{apply: func(add, remove []string) error {
+		calls = append(calls, [2][]string{add, remove})
+		if fail {
+			return errors.New("api error")
+		}
+		return nil
+	}}
+	rds := &fakeWhitelist{apply: func(add, remove []string) error {
+		calls = append(calls, [2][]string{add, remove})
+		return nil
+	}}
+	eng.targets = []Target{
+		{Key: "test/ecs/sg-test:22", IPSets: []string{"office"}, List: sg.List, Apply: sg.Apply},
+		{Key: "test/rds/rm-test:default", List: rds.List, Apply: rds.Apply},
+	}
+
+	if err := eng.Reconcile(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 updates on first run, got %d", len(calls))
+	}
+
+	// Nothing changed, so nothing should be applied
+	calls = nil
+	if err := eng.Reconcile(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("Expected no updates, got %v", calls)
+	}
+
+	// IP changed, only the target using the detected IP is updated
+	currentIP = "192.168.1.2"
+	if err := eng.Reconcile(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := [][2][]string{{{"192.168.1.2/32"}, {"192.168.1.1/32"}}}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected %v, got %v", expected, calls)
+	}
+
+	// A failed update is retried on the next run
+	cfg.IPSets[0].CIDRs = []string{"10.0.0.0/8", "172.16.0.0/12"}
+	fail = true
+	if err := eng.Reconcile(); err == nil {
+		t.Error("Expected error when a target fails to update")
+	}
+	fail = false
+	calls = nil
+	if err := eng.Reconcile(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(calls) != 1 || !reflect.DeepEqual(calls[0][0], []string{"172.16.0.0/12"}) {
+		t.Errorf("Expected retry adding 172.16.0.0/12, got %v", calls)
+	}
+}
+
+func TestReconcileStaticEntries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("192.168.1.1"))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		IPSource: config.IPSource{Type: "http", URL: server.URL, Timeout: 10},
+	}
+
+	var added []string
+	eng := New(logrus.New(), cfg, nil, newStore(t))
+	acl := &fakeWhitelist{apply: func(add, remove []string) error {
+		added = append(added, add...)
+		return nil
+	}}
+	eng.targets = []Target{
+		{Key: "test/clb/acl-test", Static: []string{"10.8.0.0/16", "172.16.0.10"}, List: acl.List, Apply: acl.Apply},
+	}
+
+	if err := eng.Reconcile(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := []string{"10.8.0.0/16", "172.16.0.10/32", "192.168.1.1/32"}
+	if !reflect.DeepEqual(added, expected) {
+		t.Errorf("Expected %v, got %v", expected, added)
+	}
+}
+
+func TestReconcileLiveEntries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("192.168.1.1"))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		IPSource: config.IPSource{Type: "http", URL: server.URL, Timeout: 10},
+	}
+
+	store := newStore(t)
+	var calls [][2][]string
+	rds := &fakeWhitelist{
+		// Entries added by hand, one of them also a static entry
+		entries: []string{"10.8.0.0/16", "192.168.9.9/32"},
+		apply: func(add, remove []string) error {
+			calls = append(calls, [2][]string{add, remove})
+			return nil
+		},
+	}
+	owner := "gw-1/test/ecs/sg-test:22"
+	var ecsCalls [][2][]string
+	sg := &fakeWhitelist{
+		entries: []string{"172.16.0.1/32", "172.16.0.2/32", "10.8.0.0/16"},
+		descriptions: map[string]string{
+			"172.16.0.1/32": aliyun.Description(owner, 512),
+			"172.16.0.2/32": aliyun.Description(owner+"2", 512),
+			"10.8.0.0/16":   "office VPN",
+		},
+		owner: owner,
+		apply: func(add, remove []string) error {
+			ecsCalls = append(ecsCalls, [2][]string{add, remove})
+			return nil
+		},
+	}
+	eng := New(logrus.New(), cfg, nil, store)
+	eng.targets = []Target{
+		{Key: "test/rds/rm-test:default", Static: []string{"10.8.0.0/16"}, List: rds.List, Apply: rds.Apply},
+		{Key: "test/ecs/sg-test:22", Owner: owner, Tagged: true, Static: []string{"10.8.0.0/16"}, List: sg.List, Apply: sg.Apply},
+	}
+
+	if err := eng.Reconcile(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := [][2][]string{{{"192.168.1.1/32"}, nil}}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected only the detected IP to be added, got %v", calls)
+	}
+	st, _ := store.Load()
+	if !reflect.DeepEqual(st.Applied["test/rds/rm-test:default"], []string{"192.168.1.1/32"}) {
+		t.Errorf("Expected entries added by hand not to be recorded, got %v", st.Applied["test/rds/rm-test:default"])
+	}
+
+	// Entries of the tagged target added by hand or by another owner are
+	// left as they are, on every run
+	for i := 0; i < 2; i++ {
+		if err := eng.Reconcile(); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	}
+	expectedECS := [][2][]string{{{"192.168.1.1/32"}, {"172.16.0.1/32"}}}
+	if !reflect.DeepEqual(ecsCalls, expectedECS) {
+		t.Errorf("Expected %v, got %v", expectedECS, ecsCalls)
+	}
+	st, _ = store.Load()
+	if !reflect.DeepEqual(st.Applied["test/ecs/sg-test:22"], []string{"192.168.1.1/32"}) {
+		t.Errorf("Expected entries added by hand not to be recorded, got %v", st.Applied["test/ecs/sg-test:22"])
+	}
+
+	// An entry removed by hand is restored
+	rds.entries = []string{"10.8.0.0/16", "192.168.9.9/32"}
+	calls = nil
+	if err := eng.Reconcile(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected the detected IP to be restored, got %v", calls)
+	}
+}
+
+func TestExpireLeases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("192.168.1.1"))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		IPSource: config.IPSource{Type: "http", URL: server.URL, Timeout: 10},
+	}
+
+	store := newStore(t)
+	err := store.Update(func(st *state.State) error {
+		st.Leases = []state.Lease{
+			{CIDR: "203.0.113.7/32", Targets: []string{"test/ecs"}, ExpiresAt: time.Now().Add(time.Hour)},
+			{CIDR: "203.0.113.8/32", ExpiresAt: time.Now().Add(time.Hour)},
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls [][2][]string
+	eng := New(logrus.New(), cfg, nil, store)
+	sg := &fakeWhitelist{apply: func(add, remove []string) error {
+		calls = append(calls, [2][]string{add, remove})
+		return nil
+	}}
+	rds := &fakeWhitelist{}
+	eng.targets = []Target{
+		{Key: "test/ecs/sg-test:22", List: sg.List, Apply: sg.Apply},
+		{Key: "test/rds/rm-test:default", List: rds.List, Apply: rds.Apply},
+	}
+
+	if err := eng.Reconcile(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := []string{"192.168.1.1/32", "203.0.113.7/32", "203.0.113.8/32"}
+	if len(calls) != 1 || !reflect.DeepEqual(calls[0][0], expected) {
+		t.Fatalf("Expected %v to be added, got %v", expected, calls)
+	}
+
+	// Expire the first lease, only its entry is revoked
+	err = store.Update(func(st *state.State) error {
+		st.Leases[0].ExpiresAt = time.Now().Add(-time.Second)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls = nil
+	if err := eng.ExpireLeases(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(calls) != 1 || len(calls[0][0]) != 0 || !reflect.DeepEqual(calls[0][1], []string{"203.0.113.7/32"}) {
+		t.Errorf("Expected only 203.0.113.7/32 to be removed, got %v", calls)
+	}
+
+	st, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Leases) != 1 {
+		t.Errorf("Expected 1 remaining lease, got %d", len(st.Leases))
+	}
+}
+
+func TestReconcileDedicatedGroup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("192.168.1.1"))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		IPSource: config.IPSource{Type: "http", URL: server.URL, Timeout: 10},
+	}
+
+	var added, removed []string
+	eng := New(logrus.New(), cfg, nil, newStore(t))
+	eng.targets = []Target{
+		{
+			Key:       "test/rds/rm-test:cwm_agent",
+			Dedicated: true,
+			List: func() ([]aliyun.Entry, error) {
+				// An entry added by hand to the dedicated group
+				return []aliyun.Entry{{CIDR: "10.8.0.0/16"}}, nil
+			},
+			Apply: func(add, remove []string) error {
+				added = append(added, add...)
+				removed = append(removed, remove...)
+				return nil
+			},
+		},
+	}
+
+	if err := eng.Reconcile(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(added, []string{"192.168.1.1/32"}) || !reflect.DeepEqual(removed, []string{"10.8.0.0/16"}) {
+		t.Errorf("Expected group to be brought to the desired set, got add %v remove %v", added, removed)
+	}
+}
+
+func TestECSRuleKey(t *testing.T) {
+	tests := []struct {
+		sg       config.SecurityGroup
+		expected string
+	}{
+		{config.SecurityGroup{Port: "22"}, "22"},
+		{config.SecurityGroup{Ports: []string{"80", "443"}}, "80+443"},
+		{config.SecurityGroup{Port: "-1/-1"}, "-1/-1"},
+		{config.SecurityGroup{Port: "-1/-1", Protocol: "all"}, "-1/-1"},
+		{config.SecurityGroup{Port: "-1/-1", Protocol: "icmp"}, "-1/-1,icmp"},
+		{config.SecurityGroup{Port: "53", Protocol: "udp", Direction: "egress"}, "53,udp,egress"},
+		{config.SecurityGroup{Port: "22", Policy: "drop", NicType: "internet"}, "22,drop,internet"},
+	}
+	for _, test := range tests {
+		if key := ecsRuleKey(test.sg); key != test.expected {
+			t.Errorf("ecsRuleKey(%+v) = %s, expected %s", test.sg, key, test.expected)
+		}
+	}
+}
+
+func TestRenderedConfigTargets(t *testing.T) {
+	data := `interval: 300
+agent_name: gw-1
+ip_source:
+  type: http
+  url: "http://ipinfo.io/ip"
+accounts:
+  - name: prod
+    access_key_id: "test_key"
+    access_key_secret: "test_secret"
+    region_id: "cn-hangzhou"
+    ecs:
+      enabled: true
+      security_groups:
+        - security_group_id: "sg-all"
+          priority: 1
+          port: "-1/-1"
+        - security_group_id: "sg-web"
+          priority: 1
+          ports: ["80", "443"]
+        - security_group_id: "sg-ping"
+          priority: 1
+          protocol: icmp
+        - security_group_id: "sg-dns"
+          priority: 1
+          port: "53"
+          protocol: udp
+          direction: egress
+    rds:
+      enabled: true
+      instance_whitelists:
+        - instance_id: "rm-app"
+          whitelist_name: "app"
+    redis:
+      enabled: true
+      instance_whitelists:
+        - instance_id: "r-app"
+          dedicated: true
+`
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// The rendered configuration is valid and manages the same targets
+	renderedPath := filepath.Join(dir, "rendered.yaml")
+	if err := os.WriteFile(renderedPath, []byte(cfg.Effective().String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := config.LoadConfig(renderedPath)
+	if err != nil {
+		t.Fatalf("Failed to load rendered config: %v", err)
+	}
+	if issues := rendered.Check(); len(issues) != 0 {
+		t.Errorf("Expected the rendered config to be valid, got %v", issues)
+	}
+
+	keys := func(cfg *config.Config) []string {
+		var keys []string
+		for _, account := range cfg.Accounts {
+			for _, target := range configuredTargets(Account{Name: account.Name}, account.GetAliyun(), cfg.GetAgentName()) {
+				keys = append(keys, target.Key)
+			}
+		}
+		return keys
+	}
+	if expected, got := keys(cfg), keys(rendered); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected targets %v, got %v", expected, got)
+	}
+}
+
+func TestAllTargets(t *testing.T) {
+	eng := New(logrus.New(), &config.Config{}, nil, newStore(t))
+	eng.accounts = []Account{{Name: "prod"}}
+	eng.targets = []Target{{Key: "prod/ecs/sg-static:22"}}
+	eng.discovered = map[string][]Target{
+		"prod": {
+			{Key: "prod/ecs/sg-static:22"},
+			{Key: "prod/ecs/sg-tagged:22"},
+		},
+	}
+
+	var keys []string
+	for _, target := range eng.allTargets() {
+		keys = append(keys, target.Key)
+	}
+	expected := []string{"prod/ecs/sg-static:22", "prod/ecs/sg-tagged:22"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+}
+
+func TestSetDiscovered(t *testing.T) {
+	store := newStore(t)
+	eng := New(logrus.New(), &config.Config{}, nil, store)
+	eng.targets = []Target{{Key: "prod/ecs/sg-static:22"}}
+
+	revoked := make(map[string][]string)
+	target := func(key string) Target {
+		return Target{
+			Key: key,
+			Apply: func(add, remove []string) error {
+				revoked[key] = remove
+				return nil
+			},
+		}
+	}
+	eng.discovered = map[string][]Target{
+		"prod": {target("prod/ecs/sg-static:22"), target("prod/ecs/sg-kept:22"), target("prod/ecs/sg-untagged:22")},
+	}
+	store.Update(func(st *state.State) error {
+		for _, key := range []string{"prod/ecs/sg-static:22", "prod/ecs/sg-kept:22", "prod/ecs/sg-untagged:22"} {
+			st.Applied[key] = []string{"192.168.1.1/32"}
+			st.Owners[key] = "gw-1/" + key
+		}
+		return nil
+	})
+
+	// The untagged security group no longer matches, the static one is still
+	// configured by ID
+	eng.setDiscovered("prod", []Target{target("prod/ecs/sg-kept:22")})
+
+	expected := map[string][]string{"prod/ecs/sg-untagged:22": {"192.168.1.1/32"}}
+	if !reflect.DeepEqual(revoked, expected) {
+		t.Errorf("Expected %v to be revoked, got %v", expected, revoked)
+	}
+	st, _ := store.Load()
+	if _, ok := st.Applied["prod/ecs/sg-untagged:22"]; ok {
+		t.Error("Expected the applied entries of the dropped target to be forgotten")
+	}
+	if _, ok := st.Owners["prod/ecs/sg-untagged:22"]; ok {
+		t.Error("Expected the owner of the dropped target to be forgotten")
+	}
+	if len(st.Applied["prod/ecs/sg-static:22"]) != 1 || len(st.Applied["prod/ecs/sg-kept:22"]) != 1 {
+		t.Errorf("Expected the other targets to keep their entries, got %v", st.Applied)
+	}
+	if len(eng.discovered["prod"]) != 1 {
+		t.Errorf("Expected only the kept target to be discovered, got %v", eng.discovered["prod"])
+	}
+}
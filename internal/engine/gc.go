@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+)
+
+// GCResult describes the orphaned rules found in a security group
+type GCResult struct {
+	Key     string // key of the target the rules were listed through
+	Entries []aliyun.Entry
+}
+
+// CollectGarbage revokes managed ECS rules whose IP is no longer desired,
+// such as rules left behind by crashes and restarts. Rules owned by other
+// agents, rules added by hand and rules younger than minAge are kept. With
+// dryRun the orphaned rules are only reported.
+func (e *Engine) CollectGarbage(minAge time.Duration, dryRun bool) ([]GCResult, error) {
+	snapshot := e.last
+	if snapshot == nil {
+		snapshot = e.resolver.Resolve()
+		e.last = snapshot
+	}
+
+	st, err := e.store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %v", err)
+	}
+	leases := st.ActiveLeases(time.Now())
+
+	// Compute the desired entries of every ECS target. The rules of a security
+	// group are listed per direction, and a direction is skipped entirely
+	// when the desired entries of any of its targets are unknown, as anything
+	// found there could still be wanted.
+	targets := e.allTargets()
+	desiredByKey := make(map[string][]string)
+	desiredBySG := make(map[string][]string)
+	unknownSG := make(map[string]bool)
+	for _, target := range targets {
+		if target.Kind != "ecs" {
+			continue
+		}
+		group := target.Resource + "/" + target.Direction
+		desired, err := e.desired(target, snapshot, leases)
+		if err != nil {
+			e.logger.Warnf("Skipping garbage collection of %s rules of security group %s: %v", target.Direction, target.Resource, err)
+			unknownSG[group] = true
+			continue
+		}
+		desiredByKey[target.Key] = desired
+		desiredBySG[group] = append(desiredBySG[group], desired...)
+	}
+
+	var results []GCResult
+	seen := make(map[string]bool) // security groups and directions already listed
+	failed := 0
+	now := time.Now()
+	for _, target := range targets {
+		group := target.Resource + "/" + target.Direction
+		if target.Kind != "ecs" || seen[group] || unknownSG[group] {
+			continue
+		}
+		seen[group] = true
+
+		entries, err := target.List()
+		if err != nil {
+			e.logger.Errorf("Failed to list rules of security group %s: %v. %s", target.Resource, err, target.Hint)
+			failed++
+			continue
+		}
+
+		var orphans []aliyun.Entry
+		for _, entry := range entries {
+			owner, managed := aliyun.ParseOwner(entry.Description)
+			if !managed || (owner != "" && !aliyun.OwnedBy(owner, e.agent)) {
+				continue
+			}
+			if entry.CreatedAt.IsZero() || now.Sub(entry.CreatedAt) < minAge {
+				continue
+			}
+
+			var orphan bool
+			if owner != "" {
+				// The owner ID names the target that added the rule
+				desired, ok := desiredByKey[strings.TrimPrefix(owner, e.agent+"/")]
+				orphan = !ok || !slices.Contains(desired, entry.CIDR)
+			} else {
+				// Rules added before ownership tagging
+				orphan = !slices.Contains(desiredBySG[group], entry.CIDR)
+			}
+			if orphan {
+				orphans = append(orphans, entry)
+			}
+		}
+
+		if len(orphans) == 0 {
+			continue
+		}
+		results = append(results, GCResult{Key: target.Key, Entries: orphans})
+		if dryRun {
+			continue
+		}
+
+		err = target.Delete(orphans)
+		if err != nil {
+			e.logger.Errorf("Failed to revoke orphaned rules from security group %s: %v. %s", target.Resource, err, target.Hint)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return results, fmt.Errorf("%d security groups failed garbage collection", failed)
+	}
+	return results, nil
+}
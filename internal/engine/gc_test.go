@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("192.168.1.1"))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		IPSource:  config.IPSource{Type: "http", URL: server.URL, Timeout: 10},
+		AgentName: "agent-1",
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	entries := []aliyun.Entry{
+		// Current IP, still desired
+		{CIDR: "192.168.1.1/32", RuleID: "sgr-1", CreatedAt: old, Description: aliyun.Description("agent-1/prod/ecs/sg-test:22", 512)},
+		// Old IP owned by this agent
+		{CIDR: "192.168.0.9/32", RuleID: "sgr-2", CreatedAt: old, Description: aliyun.Description("agent-1/prod/ecs/sg-test:22", 512)},
+		// Legacy rule without owner
+		{CIDR: "192.168.0.8/32", RuleID: "sgr-3", CreatedAt: old, Description: aliyun.ManagedDescription},
+		// Too young
+		{CIDR: "192.168.0.7/32", RuleID: "sgr-4", CreatedAt: time.Now(), Description: aliyun.ManagedDescription},
+		// Owned by another agent
+		{CIDR: "192.168.0.6/32", RuleID: "sgr-5", CreatedAt: old, Description: aliyun.Description("agent-2/prod/ecs/sg-test:22", 512)},
+		// Added by hand
+		{CIDR: "10.8.0.0/16", RuleID: "sgr-6", CreatedAt: old, Description: "office VPN"},
+		// Owned by a target that is no longer configured
+		{CIDR: "192.168.1.1/32", RuleID: "sgr-7", CreatedAt: old, Description: aliyun.Description("agent-1/prod/ecs/sg-test:3306", 512)},
+	}
+
+	var revoked []aliyun.Entry
+	eng := New(logrus.New(), cfg, nil, newStore(t))
+	eng.targets = []Target{
+		{
+			Key:      "prod/ecs/sg-test:22",
+			Kind:     "ecs",
+			Resource: "sg-test",
+			List:     func() ([]aliyun.Entry, error) { return entries, nil },
+			Delete: func(entries []aliyun.Entry) error {
+				revoked = append(revoked, entries...)
+				return nil
+			},
+		},
+		{
+			Key:      "prod/ecs/sg-test:443",
+			Kind:     "ecs",
+			Resource: "sg-test",
+			List:     func() ([]aliyun.Entry, error) { return entries, nil },
+			Delete: func(entries []aliyun.Entry) error {
+				revoked = append(revoked, entries...)
+				return nil
+			},
+		},
+	}
+
+	results, err := eng.CollectGarbage(time.Hour, true)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Entries) != 3 || len(revoked) != 0 {
+		t.Fatalf("Expected 3 orphaned rules reported and none revoked, got %v and %v", results, revoked)
+	}
+
+	if _, err := eng.CollectGarbage(time.Hour, false); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	var ruleIDs []string
+	for _, entry := range revoked {
+		ruleIDs = append(ruleIDs, entry.RuleID)
+	}
+	if len(ruleIDs) != 3 || ruleIDs[0] != "sgr-2" || ruleIDs[1] != "sgr-3" || ruleIDs[2] != "sgr-7" {
+		t.Errorf("Expected rules sgr-2, sgr-3 and sgr-7 to be revoked, got %v", ruleIDs)
+	}
+}
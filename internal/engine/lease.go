@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/ipset"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+// Grant records a lease and applies its entry to the matching targets
+// immediately. Targets already holding the entry are left as is. It returns
+// the keys of the targets that were updated.
+func (e *Engine) Grant(lease state.Lease) ([]string, error) {
+	cidr, err := ipset.Normalize(lease.CIDR)
+	if err != nil {
+		return nil, err
+	}
+	lease.CIDR = cidr
+
+	targets := e.match(lease.Targets)
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no configured target matches %v", lease.Targets)
+	}
+
+	// Record the lease first, so the daemon keeps the entry even if applying
+	// it below fails for some targets
+	var st *state.State
+	err = e.store.Update(func(current *state.State) error {
+		var leases []state.Lease
+		for _, existing := range current.Leases {
+			if existing.CIDR != lease.CIDR || !slices.Equal(existing.Targets, lease.Targets) {
+				leases = append(leases, existing)
+			}
+		}
+		current.Leases = append(leases, lease)
+		st = current
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update state: %v", err)
+	}
+
+	var updated []string
+	failed := 0
+	for _, target := range targets {
+		// An entry already present is not recorded, so that entries added by
+		// hand are left in place when the lease expires
+		present, _, err := liveEntries(target, st.Applied[target.Key])
+		if err != nil {
+			e.logger.Errorf("Failed to list entries of %s: %v. %s", target.Key, err, target.Hint)
+			failed++
+			continue
+		}
+		if slices.Contains(present, cidr) {
+			e.logger.Infof("%s is already present in %s", cidr, target.Key)
+			continue
+		}
+
+		err = target.Apply([]string{cidr}, nil)
+		if err != nil {
+			e.logger.Errorf("Failed to add %s to %s: %v. %s", cidr, target.Key, err, target.Hint)
+			failed++
+			continue
+		}
+
+		err = e.store.Update(func(st *state.State) error {
+			applied, err := ipset.NormalizeAll(append(st.Applied[target.Key], cidr))
+			if err != nil {
+				return err
+			}
+			st.Applied[target.Key] = applied
+			st.Owners[target.Key] = target.Owner
+			return nil
+		})
+		if err != nil {
+			e.logger.Errorf("Failed to record state for %s: %v", target.Key, err)
+			failed++
+			continue
+		}
+		updated = append(updated, target.Key)
+	}
+
+	if failed > 0 {
+		return updated, fmt.Errorf("%d of %d targets failed to update", failed, len(targets))
+	}
+	return updated, nil
+}
+
+// Revoke deletes every lease of an entry and revokes the entry from the
+// matching targets owning it, unless it is one of their static entries.
+// Entries added by hand are left in place. Targets left out by the patterns are revoked by the daemon on its
+// next check. It returns the keys of the targets that were updated.
+func (e *Engine) Revoke(entry string, patterns []string) ([]string, error) {
+	cidr, err := ipset.Normalize(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	var st *state.State
+	err = e.store.Update(func(current *state.State) error {
+		var leases []state.Lease
+		for _, lease := range current.Leases {
+			if lease.CIDR != cidr {
+				leases = append(leases, lease)
+			}
+		}
+		current.Leases = leases
+		st = current
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update state: %v", err)
+	}
+
+	var updated []string
+	failed := 0
+	for _, target := range e.match(patterns) {
+		if slices.Contains(normalizeQuiet(target.Static), cidr) {
+			continue
+		}
+		// Only entries owned by the agent are revoked, never those added by hand
+		_, owned, err := liveEntries(target, st.Applied[target.Key])
+		if err != nil {
+			e.logger.Errorf("Failed to list entries of %s: %v. %s", target.Key, err, target.Hint)
+			failed++
+			continue
+		}
+		if !slices.Contains(owned, cidr) {
+			continue
+		}
+
+		err = target.Apply(nil, []string{cidr})
+		if err != nil {
+			e.logger.Errorf("Failed to remove %s from %s: %v. %s", cidr, target.Key, err, target.Hint)
+			failed++
+			continue
+		}
+
+		err = e.store.Update(func(st *state.State) error {
+			var applied []string
+			for _, existing := range st.Applied[target.Key] {
+				if existing != cidr {
+					applied = append(applied, existing)
+				}
+			}
+			st.Applied[target.Key] = applied
+			st.Owners[target.Key] = target.Owner
+			return nil
+		})
+		if err != nil {
+			e.logger.Errorf("Failed to record state for %s: %v", target.Key, err)
+			failed++
+			continue
+		}
+		updated = append(updated, target.Key)
+	}
+
+	if failed > 0 {
+		return updated, fmt.Errorf("%d targets failed to update", failed)
+	}
+	return updated, nil
+}
+
+// match returns the targets matching any of the patterns, or every target
+// when no pattern is given
+func (e *Engine) match(patterns []string) []Target {
+	lease := state.Lease{Targets: patterns}
+	var targets []Target
+	for _, target := range e.allTargets() {
+		if lease.Matches(target.Key) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// normalizeQuiet normalizes entries, ignoring invalid ones
+func normalizeQuiet(entries []string) []string {
+	var result []string
+	for _, entry := range entries {
+		if normalized, err := ipset.Normalize(entry); err == nil {
+			result = append(result, normalized)
+		}
+	}
+	return result
+}
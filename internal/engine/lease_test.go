@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+func TestGrantAndRevoke(t *testing.T) {
+	store := newStore(t)
+	calls := make(map[string][][2][]string)
+	record := func(key string) func(add, remove []string) error {
+		return func(add, remove []string) error {
+			calls[key] = append(calls[key], [2][]string{add, remove})
+			return nil
+		}
+	}
+
+	eng := New(logrus.New(), &config.Config{}, nil, store)
+	target := func(key string, static, entries []string) Target {
+		whitelist := &fakeWhitelist{entries: entries, apply: record(key)}
+		return Target{Key: key, Static: static, List: whitelist.List, Apply: whitelist.Apply}
+	}
+	eng.targets = []Target{
+		target("prod/ecs/sg-test:22", nil, nil),
+		target("prod/rds/rm-test:default", nil, nil),
+		target("staging/ecs/sg-test:22", []string{"203.0.113.7"}, nil),
+		// The entry was added to these whitelists by hand
+		target("staging/rds/rm-test:default", nil, []string{"203.0.113.7/32"}),
+		{
+			Key:    "staging/clb/acl-test",
+			Owner:  "gw-1/staging/clb/acl-test",
+			Tagged: true,
+			List: func() ([]aliyun.Entry, error) {
+				return []aliyun.Entry{{CIDR: "203.0.113.7/32", Description: "office VPN"}}, nil
+			},
+			Apply: record("staging/clb/acl-test"),
+		},
+	}
+	// Recorded as applied by an earlier version
+	store.Update(func(st *state.State) error {
+		st.Applied["staging/clb/acl-test"] = []string{"203.0.113.7/32"}
+		return nil
+	})
+
+	updated, err := eng.Grant(state.Lease{
+		CIDR:      "203.0.113.7",
+		Targets:   []string{"prod/ecs", "staging"},
+		ExpiresAt: time.Now().Add(4 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := []string{"prod/ecs/sg-test:22", "staging/ecs/sg-test:22"}
+	if !reflect.DeepEqual(updated, expected) {
+		t.Errorf("Expected %v to be updated, got %v", expected, updated)
+	}
+	if len(calls["prod/rds/rm-test:default"]) != 0 || len(calls["staging/rds/rm-test:default"]) != 0 {
+		t.Error("Expected RDS targets not to be updated")
+	}
+
+	st, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Leases) != 1 || st.Leases[0].CIDR != "203.0.113.7/32" {
+		t.Errorf("Expected lease to be recorded, got %v", st.Leases)
+	}
+	if !reflect.DeepEqual(st.Applied["prod/ecs/sg-test:22"], []string{"203.0.113.7/32"}) {
+		t.Errorf("Expected applied entries to be recorded, got %v", st.Applied)
+	}
+	if _, ok := st.Applied["staging/rds/rm-test:default"]; ok {
+		t.Errorf("Expected the entry added by hand not to be recorded, got %v", st.Applied)
+	}
+
+	// The static entry of the staging target is kept
+	updated, err = eng.Revoke("203.0.113.7/32", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(updated, []string{"prod/ecs/sg-test:22"}) {
+		t.Errorf("Expected only prod/ecs/sg-test:22 to be updated, got %v", updated)
+	}
+
+	st, err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Leases) != 0 {
+		t.Errorf("Expected lease to be deleted, got %v", st.Leases)
+	}
+	if len(calls["staging/clb/acl-test"]) != 0 {
+		t.Errorf("Expected the entry added by hand to be kept, got %v", calls["staging/clb/acl-test"])
+	}
+
+	if _, err := eng.Grant(state.Lease{CIDR: "203.0.113.8", Targets: []string{"unknown"}}); err == nil {
+		t.Error("Expected error when no target matches")
+	}
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+)
+
+// ListResult holds the current entries of a target
+type ListResult struct {
+	Key     string
+	Entries []ListedEntry
+	Err     error // error listing the entries
+}
+
+// ListedEntry is an entry of a whitelist with the agent that added it
+type ListedEntry struct {
+	aliyun.Entry
+	Managed bool   // whether the entry was added by the tool
+	Owner   string // owner ID of the agent that added it, empty if unknown
+}
+
+// List returns the current entries of the targets matching any of the
+// patterns, or of every target when no pattern is given. ECS and CLB
+// entries are attributed by their description, RDS and Redis entries by the
+// state and entries of dedicated groups belong to their agent.
+func (e *Engine) List(patterns []string) ([]ListResult, error) {
+	st, err := e.store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %v", err)
+	}
+
+	var results []ListResult
+	failed := 0
+	for _, target := range e.match(patterns) {
+		result := ListResult{Key: target.Key}
+		entries, err := target.List()
+		if err != nil {
+			e.logger.Errorf("Failed to list entries of %s: %v. %s", target.Key, err, target.Hint)
+			result.Err = err
+			results = append(results, result)
+			failed++
+			continue
+		}
+
+		applied := st.Applied[target.Key]
+		for _, entry := range entries {
+			listed := ListedEntry{Entry: entry}
+			switch {
+			case target.Dedicated:
+				listed.Managed, listed.Owner = true, target.Owner
+			case target.Tagged:
+				listed.Owner, listed.Managed = aliyun.ParseOwner(entry.Description)
+			case slices.Contains(applied, entry.CIDR):
+				listed.Managed, listed.Owner = true, st.Owners[target.Key]
+			}
+			result.Entries = append(result.Entries, listed)
+		}
+		results = append(results, result)
+	}
+
+	if failed > 0 {
+		return results, fmt.Errorf("failed to list %d of %d targets", failed, len(results))
+	}
+	return results, nil
+}
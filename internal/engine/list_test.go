@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+func TestList(t *testing.T) {
+	store := newStore(t)
+	err := store.Update(func(st *state.State) error {
+		st.Applied["prod/rds/rm-test:default"] = []string{"192.168.1.1/32"}
+		st.Owners["prod/rds/rm-test:default"] = "agent-1/prod/rds/rm-test:default"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eng := New(logrus.New(), &config.Config{}, nil, store)
+	eng.targets = []Target{
+		{
+			Key:    "prod/ecs/sg-test:22",
+			Tagged: true,
+			List: func() ([]aliyun.Entry, error) {
+				return []aliyun.Entry{
+					{CIDR: "192.168.1.1/32", Description: aliyun.Description("agent-1/prod/ecs/sg-test:22", 512)},
+					{CIDR: "10.8.0.0/16", Description: "office VPN"},
+				}, nil
+			},
+		},
+		{
+			Key: "prod/rds/rm-test:default",
+			List: func() ([]aliyun.Entry, error) {
+				return []aliyun.Entry{{CIDR: "192.168.1.1/32"}, {CIDR: "10.8.0.0/16"}}, nil
+			},
+		},
+		{
+			Key:       "prod/redis/r-test:cwm_agent_1",
+			Owner:     "agent-1/prod/redis/r-test",
+			Dedicated: true,
+			List:      func() ([]aliyun.Entry, error) { return []aliyun.Entry{{CIDR: "192.168.1.1/32"}}, nil },
+		},
+		{
+			Key:  "prod/clb/acl-test",
+			List: func() ([]aliyun.Entry, error) { return nil, errors.New("api error") },
+		},
+	}
+
+	results, err := eng.List(nil)
+	if err == nil {
+		t.Error("Expected error when a target cannot be listed")
+	}
+	if len(results) != 4 || results[3].Err == nil {
+		t.Fatalf("Expected 4 results with the CLB failing, got %+v", results)
+	}
+
+	owners := func(result ListResult) []string {
+		var owners []string
+		for _, entry := range result.Entries {
+			if !entry.Managed {
+				owners = append(owners, "-")
+				continue
+			}
+			owners = append(owners, entry.Owner)
+		}
+		return owners
+	}
+	expected := [][]string{
+		{"agent-1/prod/ecs/sg-test:22", "-"},
+		{"agent-1/prod/rds/rm-test:default", "-"},
+		{"agent-1/prod/redis/r-test"},
+	}
+	for i, want := range expected {
+		if got := owners(results[i]); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected owners %v, got %v", results[i].Key, want, got)
+		}
+	}
+
+	// Patterns select the targets
+	results, err = eng.List([]string{"*/rds"})
+	if err != nil || len(results) != 1 || results[0].Key != "prod/rds/rm-test:default" {
+		t.Errorf("Expected the RDS target only, got %+v %v", results, err)
+	}
+}
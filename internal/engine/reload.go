@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/ipset"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+// Accounts returns the accounts of the engine
+func (e *Engine) Accounts() []Account {
+	return e.accounts
+}
+
+// Reload switches the engine to a new configuration and accounts, keeping
+// the state and the last resolved IP sets. Entries applied to targets that
+// are no longer configured or discovered are revoked, and dedicated groups
+// of removed targets are deleted. The new configuration is used even when
+// some revocations fail.
+func (e *Engine) Reload(cfg *config.Config, accounts []Account) error {
+	previous := e.allTargets()
+
+	e.agent = cfg.GetAgentName()
+	e.resolver = ipset.NewResolver(cfg)
+	e.accounts = accounts
+	e.targets = nil
+	for _, account := range accounts {
+		e.targets = append(e.targets, Targets(account, e.agent)...)
+	}
+
+	// Discovered targets of removed accounts go away, those of accounts whose
+	// discovery fails are kept
+	names := make(map[string]bool)
+	for _, account := range accounts {
+		names[account.Name] = true
+	}
+	for name := range e.discovered {
+		if !names[name] {
+			delete(e.discovered, name)
+		}
+	}
+	e.discover()
+
+	current := make(map[string]bool)
+	for _, target := range e.allTargets() {
+		current[target.Key] = true
+	}
+	var removed []Target
+	for _, target := range previous {
+		if !current[target.Key] {
+			removed = append(removed, target)
+		}
+	}
+	return e.revoke(removed)
+}
+
+// revoke removes the entries the agent owns in the given targets and forgets
+// them
+func (e *Engine) revoke(targets []Target) error {
+	if len(targets) == 0 {
+		return nil
+	}
+	st, err := e.store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load state: %v", err)
+	}
+
+	failed := 0
+	for _, target := range targets {
+		applied := st.Applied[target.Key]
+		if target.Dedicated || target.Tagged {
+			// Listed entries tell which are owned, entries added by hand are
+			// kept. Removing every entry of a dedicated group deletes it.
+			entries, err := target.List()
+			var owned []aliyun.Entry
+			for _, entry := range entries {
+				if ownsEntry(target, entry, applied) {
+					owned = append(owned, entry)
+				}
+			}
+			if err == nil && len(owned) > 0 {
+				if target.Dedicated {
+					e.logger.Infof("Removing dedicated group of %s", target.Key)
+				} else {
+					e.logger.Infof("Target %s was removed, revoking [%s]", target.Key, strings.Join(aliyun.CIDRs(owned), ", "))
+				}
+				err = target.Delete(owned)
+			}
+			if err != nil {
+				e.logger.Errorf("Failed to revoke %s: %v. %s", target.Key, err, target.Hint)
+				failed++
+				continue
+			}
+		} else if len(applied) > 0 {
+			// Listings of RDS and Redis whitelists do not tell who added an
+			// entry, so the state does
+			e.logger.Infof("Target %s was removed, revoking [%s]", target.Key, strings.Join(applied, ", "))
+			err := target.Apply(nil, applied)
+			if err != nil {
+				e.logger.Errorf("Failed to revoke %s: %v. %s", target.Key, err, target.Hint)
+				failed++
+				continue
+			}
+		}
+
+		err = e.store.Update(func(st *state.State) error {
+			delete(st.Applied, target.Key)
+			delete(st.Owners, target.Key)
+			return nil
+		})
+		if err != nil {
+			e.logger.Errorf("Failed to record state for %s: %v", target.Key, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d removed targets failed to revoke", failed, len(targets))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/aliyun"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+func TestReload(t *testing.T) {
+	client, err := aliyun.NewClient(&config.Aliyun{
+		AccessKeyID:     "test_key",
+		AccessKeySecret: "test_secret",
+		RegionID:        "cn-hangzhou",
+		RDS: config.RDS{
+			Enabled:            true,
+			InstanceWhitelists: []config.InstanceWhitelist{{InstanceID: "rm-test", WhitelistName: "default"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	accounts := []Account{{Name: "test", Client: client}}
+
+	cfg := &config.Config{AgentName: "gw-1"}
+	store := newStore(t)
+	eng := New(logrus.New(), cfg, accounts, store)
+
+	var revoked, deleted []aliyun.Entry
+	owner := "gw-1/test/ecs/sg-removed:22"
+	eng.targets = append(eng.targets,
+		Target{
+			Key:    "test/ecs/sg-removed:22",
+			Owner:  owner,
+			Tagged: true,
+			List: func() ([]aliyun.Entry, error) {
+				return []aliyun.Entry{
+					{CIDR: "192.168.1.1/32", Description: aliyun.Description(owner, 512), RuleID: "sgr-1"},
+					// Added by hand, though recorded as applied by an earlier version
+					{CIDR: "10.8.0.0/16", Description: "office VPN", RuleID: "sgr-2"},
+				}, nil
+			},
+			Delete: func(entries []aliyun.Entry) error {
+				revoked = entries
+				return nil
+			},
+		},
+		Target{
+			Key:       "test/rds/rm-removed:cwm_gw_1",
+			Dedicated: true,
+			List: func() ([]aliyun.Entry, error) {
+				return []aliyun.Entry{{CIDR: "192.168.1.1/32"}}, nil
+			},
+			Delete: func(entries []aliyun.Entry) error {
+				deleted = entries
+				return nil
+			},
+		},
+	)
+	store.Update(func(st *state.State) error {
+		st.Applied["test/ecs/sg-removed:22"] = []string{"10.8.0.0/16", "192.168.1.1/32"}
+		st.Owners["test/ecs/sg-removed:22"] = "gw-1/test/ecs/sg-removed:22"
+		st.Applied["test/rds/rm-test:default"] = []string{"192.168.1.1/32"}
+		return nil
+	})
+
+	// The RDS target is still configured, the other two were removed
+	if err := eng.Reload(cfg, accounts); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []aliyun.Entry{{CIDR: "192.168.1.1/32", Description: aliyun.Description(owner, 512), RuleID: "sgr-1"}}
+	if !reflect.DeepEqual(revoked, expected) {
+		t.Errorf("Expected only owned entries to be revoked, got %v", revoked)
+	}
+	if len(deleted) != 1 {
+		t.Errorf("Expected the dedicated group to be emptied, got %v", deleted)
+	}
+	if len(eng.targets) != 1 || eng.targets[0].Key != "test/rds/rm-test:default" {
+		t.Errorf("Expected only the configured target, got %v", eng.targets)
+	}
+
+	st, _ := store.Load()
+	if _, ok := st.Applied["test/ecs/sg-removed:22"]; ok {
+		t.Error("Expected state of the removed target to be cleared")
+	}
+	if _, ok := st.Owners["test/ecs/sg-removed:22"]; ok {
+		t.Error("Expected owner of the removed target to be cleared")
+	}
+	if len(st.Applied["test/rds/rm-test:default"]) != 1 {
+		t.Error("Expected state of the remaining target to be kept")
+	}
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+// Status is the recorded state of the engine
+type Status struct {
+	LastRun *state.Run    // last reconciliation, nil if none has run yet
+	Leases  []state.Lease // active leases
+	Targets []TargetStatus
+}
+
+// TargetStatus is the recorded state of a target
+type TargetStatus struct {
+	Key        string
+	Configured bool          // false for discovered targets and targets no longer configured
+	Applied    []string      // entries applied by this agent
+	Result     *state.Result // outcome of the last update, nil if never updated
+}
+
+// ReadStatus returns the state recorded for the configured targets, followed
+// by the other targets found in the state. It reads the configuration and the
+// state store only, without creating clients or calling the cloud APIs.
+func ReadStatus(cfg *config.Config, store *state.Store) (*Status, error) {
+	st, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %v", err)
+	}
+
+	status := &Status{LastRun: st.LastRun, Leases: st.ActiveLeases(time.Now())}
+	seen := make(map[string]bool)
+	add := func(key string, configured bool) {
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		target := TargetStatus{Key: key, Configured: configured, Applied: st.Applied[key]}
+		if result, ok := st.Results[key]; ok {
+			target.Result = &result
+		}
+		status.Targets = append(status.Targets, target)
+	}
+
+	for _, account := range cfg.Accounts {
+		for _, target := range configuredTargets(Account{Name: account.Name}, account.GetAliyun(), cfg.GetAgentName()) {
+			add(target.Key, true)
+		}
+	}
+	var others []string
+	for key := range st.Applied {
+		others = append(others, key)
+	}
+	for key := range st.Results {
+		others = append(others, key)
+	}
+	sort.Strings(others)
+	for _, key := range others {
+		add(key, false)
+	}
+	return status, nil
+}
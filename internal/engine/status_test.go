@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/state"
+)
+
+func TestStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("192.168.1.1"))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		IPSource: config.IPSource{Type: "http", URL: server.URL, Timeout: 10},
+		Accounts: []config.Account{
+			{
+				Name:     "prod",
+				RegionID: "cn-hangzhou",
+				ECS: config.ECS{
+					Enabled:          true,
+					SecurityGroupIDs: []config.SecurityGroup{{SecurityGroupID: "sg-test", Port: "22", Priority: 100}},
+				},
+				RDS: config.RDS{
+					Enabled:            true,
+					InstanceWhitelists: []config.InstanceWhitelist{{InstanceID: "rm-test", WhitelistName: "default"}},
+				},
+			},
+		},
+	}
+	store := newStore(t)
+	err := store.Update(func(st *state.State) error {
+		st.Applied["old/ecs/sg-old:22"] = []string{"192.168.1.1/32"}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eng := New(logrus.New(), cfg, nil, store)
+	sgWhitelist := &fakeWhitelist{}
+	rdsWhitelist := &fakeWhitelist{apply: func(add, remove []string) error { return errors.New("api error") }}
+	eng.targets = []Target{
+		{Key: "prod/ecs/sg-test:22", List: sgWhitelist.List, Apply: sgWhitelist.Apply},
+		{Key: "prod/rds/rm-test:default", List: rdsWhitelist.List, Apply: rdsWhitelist.Apply},
+	}
+
+	// Nothing has run yet, the configured targets come from the configuration
+	status, err := ReadStatus(cfg, store)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if status.LastRun != nil || len(status.Targets) != 3 || status.Targets[0].Result != nil {
+		t.Fatalf("Expected no results before the first run, got %+v", status)
+	}
+
+	if err := eng.Reconcile(); err == nil {
+		t.Fatal("Expected error when a target fails to update")
+	}
+	status, err = ReadStatus(cfg, store)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if run := status.LastRun; run == nil || run.DetectedIP != "192.168.1.1" || run.Targets != 2 || run.Failed != 1 {
+		t.Errorf("Expected last run with 1 of 2 targets failed, got %+v", run)
+	}
+
+	ecs, rds, old := status.Targets[0], status.Targets[1], status.Targets[2]
+	if !ecs.Configured || ecs.Result == nil || ecs.Result.Error != "" || len(ecs.Applied) != 1 {
+		t.Errorf("Expected successful ECS target, got %+v", ecs)
+	}
+	if rds.Result == nil || rds.Result.Error != "api error" || len(rds.Applied) != 0 {
+		t.Errorf("Expected failed RDS target, got %+v", rds)
+	}
+	// Targets only known from the state follow the configured ones
+	if old.Key != "old/ecs/sg-old:22" || old.Configured || old.Result != nil {
+		t.Errorf("Expected unconfigured target from the state, got %+v", old)
+	}
+}
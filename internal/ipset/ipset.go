@@ -0,0 +1,203 @@
+package ipset
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/ip"
+)
+
+// Resolver resolves the configured IP sets into normalized CIDR lists
+type Resolver struct {
+	source  config.IPSource
+	sets    []config.IPSet
+	remotes map[string]*remoteCache // cached remote lists by URL
+}
+
+// Snapshot holds the result of resolving every IP set once
+type Snapshot struct {
+	Detected    string              // detected public IP, empty if detection failed
+	DetectedErr error               // error returned by IP detection
+	Sets        map[string][]string // resolved entries by IP set name
+	Errors      map[string]error    // resolution errors by IP set name
+	Stale       map[string]error    // remote lists served from cache after a failed refresh, by URL
+}
+
+// NewResolver creates a new IP set resolver
+func NewResolver(cfg *config.Config) *Resolver {
+	return &Resolver{
+		source:  cfg.IPSource,
+		sets:    cfg.IPSets,
+		remotes: make(map[string]*remoteCache),
+	}
+}
+
+// Resolve resolves every configured IP set. A set that fails to resolve is
+// reported in Errors and left out of Sets, so that callers never act on a
+// partial set.
+func (r *Resolver) Resolve() *Snapshot {
+	snapshot := &Snapshot{
+		Sets:   make(map[string][]string),
+		Errors: make(map[string]error),
+		Stale:  make(map[string]error),
+	}
+
+	snapshot.Detected, snapshot.DetectedErr = ip.GetPublicIP([]config.IPSource{r.source})
+
+	for _, set := range r.sets {
+		entries, err := r.resolveSet(set, snapshot)
+		if err != nil {
+			snapshot.Errors[set.Name] = err
+			continue
+		}
+		snapshot.Sets[set.Name] = entries
+	}
+
+	return snapshot
+}
+
+// resolveSet resolves a single IP set
+func (r *Resolver) resolveSet(set config.IPSet, snapshot *Snapshot) ([]string, error) {
+	var entries []string
+
+	if set.Detected {
+		if snapshot.DetectedErr != nil {
+			return nil, fmt.Errorf("failed to get public IP: %v", snapshot.DetectedErr)
+		}
+		entries = append(entries, snapshot.Detected)
+	}
+
+	entries = append(entries, set.CIDRs...)
+
+	for _, name := range set.DNSNames {
+		addrs, err := net.LookupHost(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %v", name, err)
+		}
+		entries = append(entries, addrs...)
+	}
+
+	for _, list := range set.RemoteLists {
+		listEntries, err := r.remoteList(list, snapshot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch remote list %s: %v", list.URL, err)
+		}
+		entries = append(entries, listEntries...)
+	}
+
+	return NormalizeAll(entries)
+}
+
+// Desired returns the union of the referenced IP sets. A target without
+// references gets the detected public IP only.
+func (s *Snapshot) Desired(refs []string) ([]string, error) {
+	if len(refs) == 0 {
+		if s.DetectedErr != nil {
+			return nil, fmt.Errorf("failed to get public IP: %v", s.DetectedErr)
+		}
+		return NormalizeAll([]string{s.Detected})
+	}
+
+	var entries []string
+	for _, ref := range refs {
+		if err, ok := s.Errors[ref]; ok {
+			return nil, fmt.Errorf("IP set %s: %v", ref, err)
+		}
+		set, ok := s.Sets[ref]
+		if !ok {
+			return nil, fmt.Errorf("IP set %s is not defined", ref)
+		}
+		entries = append(entries, set...)
+	}
+
+	return NormalizeAll(entries)
+}
+
+// Normalize converts an IP address or CIDR block into canonical CIDR form,
+// e.g. "1.2.3.4" becomes "1.2.3.4/32" and "10.1.2.3/8" becomes "10.0.0.0/8"
+func Normalize(entry string) (string, error) {
+	entry = strings.TrimSpace(entry)
+
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return "", fmt.Errorf("invalid CIDR: %s", entry)
+		}
+		return prefix.Masked().String(), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP address: %s", entry)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()).String(), nil
+}
+
+// NormalizeAll normalizes a list of entries, removing duplicates and sorting
+// the result
+func NormalizeAll(entries []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var result []string
+	for _, entry := range entries {
+		normalized, err := Normalize(entry)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[normalized] {
+			seen[normalized] = true
+			result = append(result, normalized)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Diff returns the entries to add and to remove to turn current into desired
+func Diff(current, desired []string) (add, remove []string) {
+	currentMap := make(map[string]bool)
+	for _, entry := range current {
+		currentMap[entry] = true
+	}
+	desiredMap := make(map[string]bool)
+	for _, entry := range desired {
+		desiredMap[entry] = true
+	}
+
+	for _, entry := range desired {
+		if !currentMap[entry] {
+			add = append(add, entry)
+		}
+	}
+	for _, entry := range current {
+		if !desiredMap[entry] {
+			remove = append(remove, entry)
+		}
+	}
+
+	sort.Strings(add)
+	sort.Strings(remove)
+	return add, remove
+}
+
+// IsHost reports whether a normalized entry covers a single address
+func IsHost(entry string) bool {
+	prefix, err := netip.ParsePrefix(entry)
+	if err != nil {
+		return false
+	}
+	return prefix.IsSingleIP()
+}
+
+// IsIPv6 reports whether a normalized entry is an IPv6 prefix
+func IsIPv6(entry string) bool {
+	prefix, err := netip.ParsePrefix(entry)
+	if err != nil {
+		return false
+	}
+	return prefix.Addr().Is6()
+}
@@ -0,0 +1,101 @@
+package ipset
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"192.168.1.1":     "192.168.1.1/32",
+		" 10.1.2.3/8 ":    "10.0.0.0/8",
+		"2001:db8::1":     "2001:db8::1/128",
+		"2001:db8::/32":   "2001:db8::/32",
+		"::ffff:10.0.0.1": "10.0.0.1/32",
+	}
+
+	for input, expected := range tests {
+		result, err := Normalize(input)
+		if err != nil {
+			t.Errorf("Normalize(%q) returned error: %v", input, err)
+			continue
+		}
+		if result != expected {
+			t.Errorf("Normalize(%q): expected %s, got %s", input, expected, result)
+		}
+	}
+
+	if _, err := Normalize("not-an-ip"); err == nil {
+		t.Error("Expected error for invalid entry, got none")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	current := []string{"1.1.1.1/32", "2.2.2.2/32"}
+	desired := []string{"2.2.2.2/32", "3.3.3.3/32"}
+
+	add, remove := Diff(current, desired)
+	if !reflect.DeepEqual(add, []string{"3.3.3.3/32"}) {
+		t.Errorf("Expected add [3.3.3.3/32], got %v", add)
+	}
+	if !reflect.DeepEqual(remove, []string{"1.1.1.1/32"}) {
+		t.Errorf("Expected remove [1.1.1.1/32], got %v", remove)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	ipServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("192.168.1.1"))
+	}))
+	defer ipServer.Close()
+
+	listServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("# CI egress\n10.0.0.0/24\n\n10.0.1.5\n"))
+	}))
+	defer listServer.Close()
+
+	cfg := &config.Config{
+		IPSource: config.IPSource{Type: "http", URL: ipServer.URL, Timeout: 10},
+		IPSets: []config.IPSet{
+			{Name: "office", Detected: true, CIDRs: []string{"172.16.0.0/16"}},
+			{Name: "ci", RemoteLists: []config.RemoteList{{URL: listServer.URL, Timeout: 10}}},
+			{Name: "broken", CIDRs: []string{"bad"}},
+		},
+	}
+
+	snapshot := NewResolver(cfg).Resolve()
+
+	if snapshot.Detected != "192.168.1.1" {
+		t.Errorf("Expected detected IP 192.168.1.1, got %s", snapshot.Detected)
+	}
+	if _, ok := snapshot.Errors["broken"]; !ok {
+		t.Error("Expected error for IP set 'broken'")
+	}
+
+	desired, err := snapshot.Desired([]string{"office", "ci"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := []string{"10.0.0.0/24", "10.0.1.5/32", "172.16.0.0/16", "192.168.1.1/32"}
+	if !reflect.DeepEqual(desired, expected) {
+		t.Errorf("Expected %v, got %v", expected, desired)
+	}
+
+	desired, err = snapshot.Desired(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(desired, []string{"192.168.1.1/32"}) {
+		t.Errorf("Expected detected IP only, got %v", desired)
+	}
+
+	if _, err := snapshot.Desired([]string{"office", "broken"}); err == nil {
+		t.Error("Expected error when a referenced IP set failed to resolve")
+	}
+}
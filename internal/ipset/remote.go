@@ -0,0 +1,216 @@
+package ipset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+// maxRemoteListSize is the largest remote list response accepted
+const maxRemoteListSize = 10 << 20
+
+// remoteCache holds the last successfully fetched entries of a remote list
+type remoteCache struct {
+	entries   []string
+	fetchedAt time.Time
+}
+
+// remoteList returns the entries of a remote list, fetching it when the
+// refresh interval has elapsed. If a refresh fails, the last good entries are
+// kept and the failure is recorded in the snapshot.
+func (r *Resolver) remoteList(list config.RemoteList, snapshot *Snapshot) ([]string, error) {
+	key := list.URL + "#" + list.JSONPath + "#" + list.LinePattern
+	cache := r.remotes[key]
+
+	if cache != nil && time.Since(cache.fetchedAt) < time.Duration(list.Refresh)*time.Second {
+		return cache.entries, nil
+	}
+
+	entries, err := fetchRemoteList(list)
+	if err != nil {
+		if cache != nil {
+			snapshot.Stale[list.URL] = err
+			return cache.entries, nil
+		}
+		return nil, err
+	}
+
+	r.remotes[key] = &remoteCache{entries: entries, fetchedAt: time.Now()}
+	return entries, nil
+}
+
+// fetchRemoteList downloads a remote list and extracts its entries
+func fetchRemoteList(list config.RemoteList) ([]string, error) {
+	client := &http.Client{
+		Timeout: list.GetTimeout(),
+	}
+
+	req, err := http.NewRequest("GET", list.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+
+	// Add custom headers
+	for key, value := range list.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP request failed with status: %d", resp.StatusCode)
+	}
+
+	// Read one byte past the limit so that an oversized list is rejected
+	// rather than parsed partially
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteListSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if len(body) > maxRemoteListSize {
+		return nil, fmt.Errorf("response exceeds the limit of %d bytes", maxRemoteListSize)
+	}
+
+	return ParseRemoteList(list, body)
+}
+
+// ParseRemoteList extracts and validates the entries of a remote list body
+func ParseRemoteList(list config.RemoteList, body []byte) ([]string, error) {
+	var entries []string
+	var err error
+
+	if list.Format == "json" {
+		entries, err = parseJSONList(body, list.JSONPath)
+	} else {
+		entries, err = parseLineList(body, list.LinePattern)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty list would revoke every entry, which is almost always a broken
+	// upstream rather than an intended change
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("list contains no entries")
+	}
+	if len(entries) > list.GetMaxEntries() {
+		return nil, fmt.Errorf("list contains %d entries, exceeding the limit of %d", len(entries), list.GetMaxEntries())
+	}
+
+	normalized, err := NormalizeAll(entries)
+	if err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// parseLineList extracts one entry per line, skipping blank lines and
+// comments. With a pattern, the first capture group (or the whole match) of
+// each matching line is used and other lines are skipped.
+func parseLineList(body []byte, pattern string) ([]string, error) {
+	var re *regexp.Regexp
+	if pattern != "" {
+		var err error
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line pattern: %v", err)
+		}
+	}
+
+	var entries []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if re != nil {
+			match := re.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			line = match[0]
+			if len(match) > 1 {
+				line = match[1]
+			}
+		} else if i := strings.IndexAny(line, " \t#;"); i >= 0 {
+			line = line[:i]
+		}
+
+		entries = append(entries, strings.TrimSpace(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read list: %v", err)
+	}
+
+	return entries, nil
+}
+
+// parseJSONList extracts entries from a JSON document. The path is a
+// dot-separated list of object keys; arrays met along the way are walked
+// element by element and "*" selects every value of an object, so
+// "prefixes.ip_prefix" and "result.ipv4_cidrs" both work.
+func parseJSONList(body []byte, path string) ([]string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %v", err)
+	}
+
+	var entries []string
+	var walk func(node interface{}, keys []string) error
+	walk = func(node interface{}, keys []string) error {
+		if array, ok := node.([]interface{}); ok {
+			for _, item := range array {
+				if err := walk(item, keys); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if len(keys) == 0 {
+			value, ok := node.(string)
+			if !ok {
+				return fmt.Errorf("value at %s is not a string", path)
+			}
+			entries = append(entries, value)
+			return nil
+		}
+
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("value at %s is not an object", path)
+		}
+		if keys[0] == "*" {
+			for _, value := range object {
+				if err := walk(value, keys[1:]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		value, ok := object[keys[0]]
+		if !ok {
+			return fmt.Errorf("key %s not found", keys[0])
+		}
+		return walk(value, keys[1:])
+	}
+
+	if err := walk(doc, strings.Split(path, ".")); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
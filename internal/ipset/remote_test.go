@@ -0,0 +1,135 @@
+package ipset
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ConanStudio/cloud-whitelist-manager/internal/config"
+)
+
+func TestParseRemoteListJSON(t *testing.T) {
+	body := []byte(`{"result":{"ipv4_cidrs":["173.245.48.0/20","103.21.244.0/22"],"ipv6_cidrs":["2400:cb00::/32"]}}`)
+
+	entries, err := ParseRemoteList(config.RemoteList{Format: "json", JSONPath: "result.ipv4_cidrs"}, body)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := []string{"103.21.244.0/22", "173.245.48.0/20"}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %v, got %v", expected, entries)
+	}
+
+	entries, err = ParseRemoteList(config.RemoteList{Format: "json", JSONPath: "result.*"}, body)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("Expected 3 entries, got %v", entries)
+	}
+
+	// Arrays of objects are walked element by element
+	body = []byte(`{"prefixes":[{"ip_prefix":"3.5.140.0/22"},{"ip_prefix":"13.34.37.64/27"}]}`)
+	entries, err = ParseRemoteList(config.RemoteList{Format: "json", JSONPath: "prefixes.ip_prefix"}, body)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got %v", entries)
+	}
+
+	if _, err := ParseRemoteList(config.RemoteList{Format: "json", JSONPath: "missing"}, body); err == nil {
+		t.Error("Expected error for missing JSON path, got none")
+	}
+}
+
+func TestParseRemoteListLines(t *testing.T) {
+	body := []byte("# UptimeRobot\n216.144.250.150 ; monitor\n\n69.162.124.226\n")
+
+	entries, err := ParseRemoteList(config.RemoteList{}, body)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := []string{"216.144.250.150/32", "69.162.124.226/32"}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %v, got %v", expected, entries)
+	}
+
+	body = []byte("allow 10.0.0.0/24;\ndeny all;\nallow 10.0.1.0/24;\n")
+	entries, err = ParseRemoteList(config.RemoteList{LinePattern: `^allow ([0-9./]+);`}, body)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected = []string{"10.0.0.0/24", "10.0.1.0/24"}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %v, got %v", expected, entries)
+	}
+}
+
+func TestParseRemoteListSanity(t *testing.T) {
+	if _, err := ParseRemoteList(config.RemoteList{}, []byte("# nothing here\n")); err == nil {
+		t.Error("Expected error for empty list, got none")
+	}
+
+	body := []byte("10.0.0.1\n10.0.0.2\n10.0.0.3\n")
+	if _, err := ParseRemoteList(config.RemoteList{MaxEntries: 2}, body); err == nil {
+		t.Error("Expected error when exceeding max_entries, got none")
+	}
+
+	if _, err := ParseRemoteList(config.RemoteList{}, []byte("<html>\n")); err == nil {
+		t.Error("Expected error for invalid entry, got none")
+	}
+}
+
+func TestRemoteListKeepsLastGoodEntries(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("10.0.0.0/24\n"))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		IPSource: config.IPSource{Type: "http", URL: server.URL, Timeout: 1},
+		IPSets: []config.IPSet{
+			{Name: "ci", RemoteLists: []config.RemoteList{{URL: server.URL, Timeout: 10}}},
+		},
+	}
+	resolver := NewResolver(cfg)
+
+	snapshot := resolver.Resolve()
+	if !reflect.DeepEqual(snapshot.Sets["ci"], []string{"10.0.0.0/24"}) {
+		t.Fatalf("Expected [10.0.0.0/24], got %v (errors: %v)", snapshot.Sets["ci"], snapshot.Errors)
+	}
+
+	healthy = false
+	snapshot = resolver.Resolve()
+	if !reflect.DeepEqual(snapshot.Sets["ci"], []string{"10.0.0.0/24"}) {
+		t.Errorf("Expected last good entries, got %v", snapshot.Sets["ci"])
+	}
+	if _, ok := snapshot.Stale[server.URL]; !ok {
+		t.Error("Expected remote list to be reported as stale")
+	}
+}
+
+func TestFetchRemoteListSizeLimit(t *testing.T) {
+	// A list just over the limit must not be parsed partially
+	body := bytes.Repeat([]byte("10.0.0.0/24\n"), maxRemoteListSize/12+1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	_, err := fetchRemoteList(config.RemoteList{URL: server.URL, Timeout: 10})
+	if err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Errorf("Expected error for an oversized list, got %v", err)
+	}
+}
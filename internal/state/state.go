@@ -0,0 +1,238 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// State represents the persistent state shared by the daemon and the CLI
+type State struct {
+	Applied map[string][]string `json:"applied"` // entries applied by the tool, by target key
+	Owners  map[string]string   `json:"owners"`  // owner ID of the applied entries, by target key
+	Leases  []Lease             `json:"leases"`  // manually granted entries
+	Results map[string]Result   `json:"results"` // outcome of the last update, by target key
+	LastRun *Run                `json:"last_run,omitempty"`
+}
+
+// Run records the last reconciliation of every target
+type Run struct {
+	Time       time.Time `json:"time"`
+	DetectedIP string    `json:"detected_ip,omitempty"` // empty if detection failed
+	Targets    int       `json:"targets"`
+	Failed     int       `json:"failed"`
+}
+
+// Result records the outcome of the last update of a target
+type Result struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"` // empty on success
+}
+
+// Lease represents an entry granted for a limited or unlimited time
+type Lease struct {
+	CIDR      string    `json:"cidr"`
+	Targets   []string  `json:"targets,omitempty"` // target key patterns, empty for all targets
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // zero for no expiry
+	Comment   string    `json:"comment,omitempty"`
+}
+
+// Store persists the state as a JSON file. A store without a path keeps the
+// state in memory only.
+type Store struct {
+	path   string
+	mu     sync.Mutex
+	memory []byte
+}
+
+// Open opens the state store at path, creating an empty state if the file
+// does not exist yet
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	if _, err := s.Load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path returns the path of the state file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load reads the current state. The file is read on every call so that
+// changes made by other processes, such as the add and remove commands, are
+// picked up.
+func (s *Store) Load() (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return s.load()
+}
+
+// Update reads the current state, applies fn and writes the result back.
+// Other processes cannot read or update the state in between.
+func (s *Store) Update(fn func(*State) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+	if err := fn(st); err != nil {
+		return err
+	}
+	return s.save(st)
+}
+
+// lock takes the advisory lock of the state file, held in a separate
+// "<path>.lock" file as the state file itself is replaced on every save.
+// Stores without a path are not shared and need no lock.
+func (s *Store) lock(exclusive bool) (func(), error) {
+	if s.path == "" {
+		return func() {}, nil
+	}
+	return lockFile(s.path+".lock", exclusive)
+}
+
+// load reads the state without locking
+func (s *Store) load() (*State, error) {
+	data := s.memory
+	if s.path != "" {
+		var err error
+		data, err = os.ReadFile(s.path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read state file: %v", err)
+		}
+	}
+
+	st := &State{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, st); err != nil {
+			return nil, fmt.Errorf("failed to parse state file: %v", err)
+		}
+	}
+	if st.Applied == nil {
+		st.Applied = make(map[string][]string)
+	}
+	if st.Owners == nil {
+		st.Owners = make(map[string]string)
+	}
+	if st.Results == nil {
+		st.Results = make(map[string]Result)
+	}
+	return st, nil
+}
+
+// save writes the state without locking. The file is replaced atomically so
+// that a crash never leaves a truncated state behind.
+func (s *Store) save(st *State) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %v", err)
+	}
+
+	if s.path == "" {
+		s.memory = data
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
+}
+
+// Active reports whether the lease has not expired at the given time
+func (l Lease) Active(now time.Time) bool {
+	return l.ExpiresAt.IsZero() || now.Before(l.ExpiresAt)
+}
+
+// Matches reports whether the lease applies to the given target key
+func (l Lease) Matches(key string) bool {
+	if len(l.Targets) == 0 {
+		return true
+	}
+	for _, pattern := range l.Targets {
+		if MatchTarget(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchTarget reports whether a target key matches a pattern. Patterns are
+// matched segment by segment with path.Match and may omit trailing segments,
+// so "prod" matches every target of account prod and "*/rds" matches every
+// RDS target.
+func MatchTarget(pattern, key string) bool {
+	patternParts := strings.Split(pattern, "/")
+	keyParts := strings.Split(key, "/")
+	if len(patternParts) > len(keyParts) {
+		return false
+	}
+	for i, part := range patternParts {
+		matched, err := path.Match(part, keyParts[i])
+		if err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// ActiveLeases returns the leases that have not expired at the given time
+func (st *State) ActiveLeases(now time.Time) []Lease {
+	var leases []Lease
+	for _, lease := range st.Leases {
+		if lease.Active(now) {
+			leases = append(leases, lease)
+		}
+	}
+	return leases
+}
+
+// PruneLeases removes the leases that have expired at the given time and
+// returns them
+func (st *State) PruneLeases(now time.Time) []Lease {
+	var active, expired []Lease
+	for _, lease := range st.Leases {
+		if lease.Active(now) {
+			active = append(active, lease)
+		} else {
+			expired = append(expired, lease)
+		}
+	}
+	st.Leases = active
+	return expired
+}
@@ -0,0 +1,138 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStorePersistence(t *testing.T) {
+	dir, err := os.MkdirTemp("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open state store: %v", err)
+	}
+
+	err = store.Update(func(st *State) error {
+		st.Applied["prod/ecs/sg-test:22"] = []string{"192.168.1.1/32"}
+		st.Leases = append(st.Leases, Lease{CIDR: "203.0.113.7/32", ExpiresAt: time.Now().Add(time.Hour)})
+		st.Results["prod/ecs/sg-test:22"] = Result{Time: time.Now(), Error: "api error"}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to update state: %v", err)
+	}
+
+	// A second store sees the changes, as the CLI and the daemon do
+	other, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open state store: %v", err)
+	}
+	st, err := other.Load()
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if len(st.Applied["prod/ecs/sg-test:22"]) != 1 {
+		t.Errorf("Expected applied entries to be persisted, got %v", st.Applied)
+	}
+	if len(st.Leases) != 1 || st.Leases[0].CIDR != "203.0.113.7/32" {
+		t.Errorf("Expected lease to be persisted, got %v", st.Leases)
+	}
+	if st.Results["prod/ecs/sg-test:22"].Error != "api error" {
+		t.Errorf("Expected result to be persisted, got %v", st.Results)
+	}
+}
+
+func TestStoreConcurrentProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	// Separate stores stand for the daemon and the CLI, which share nothing
+	// but the file lock
+	const updates = 20
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		store, err := Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open state store: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				err := store.Update(func(st *State) error {
+					st.Leases = append(st.Leases, Lease{CIDR: "203.0.113.7/32"})
+					return nil
+				})
+				if err != nil {
+					t.Errorf("Failed to update state: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Leases) != 2*updates {
+		t.Errorf("Expected %d leases, got %d", 2*updates, len(st.Leases))
+	}
+}
+
+func TestPruneLeases(t *testing.T) {
+	now := time.Now()
+	st := &State{
+		Leases: []Lease{
+			{CIDR: "203.0.113.1/32", ExpiresAt: now.Add(-time.Minute)},
+			{CIDR: "203.0.113.2/32", ExpiresAt: now.Add(time.Minute)},
+			{CIDR: "203.0.113.3/32"},
+		},
+	}
+
+	if active := st.ActiveLeases(now); len(active) != 2 {
+		t.Errorf("Expected 2 active leases, got %d", len(active))
+	}
+
+	expired := st.PruneLeases(now)
+	if len(expired) != 1 || expired[0].CIDR != "203.0.113.1/32" {
+		t.Errorf("Expected 203.0.113.1/32 to expire, got %v", expired)
+	}
+	if len(st.Leases) != 2 {
+		t.Errorf("Expected 2 remaining leases, got %d", len(st.Leases))
+	}
+}
+
+func TestMatchTarget(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		matched bool
+	}{
+		{"prod", "prod/ecs/sg-test:22", true},
+		{"prod/ecs", "prod/ecs/sg-test:22", true},
+		{"*/rds", "prod/rds/rm-test:default", true},
+		{"*/rds", "prod/ecs/sg-test:22", false},
+		{"prod/ecs/sg-test:*", "prod/ecs/sg-test:22", true},
+		{"staging", "prod/ecs/sg-test:22", false},
+		{"prod/ecs/sg-test:22/extra", "prod/ecs/sg-test:22", false},
+	}
+
+	for _, test := range tests {
+		if MatchTarget(test.pattern, test.key) != test.matched {
+			t.Errorf("MatchTarget(%q, %q): expected %v", test.pattern, test.key, test.matched)
+		}
+	}
+}